@@ -0,0 +1,621 @@
+// Copyright 2023 Daniel Valdivia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// AIP160Options contains configuration options for compiling AIP-160 filters
+type AIP160Options struct {
+	// Repeated lists the repeated fields, matched case-insensitively, e.g. "tags", where
+	// tags:"go" matches when any element equals "go"
+	Repeated []string
+
+	// Maps lists the map fields, matched case-insensitively, e.g. "labels", where
+	// labels:"env" matches when the map has the key "env"
+	Maps []string
+}
+
+// CompileAIP160 parses a filter written in the Google AIP-160 filter syntax, as used by the
+// List methods of APIs following the AIPs, e.g.
+//
+//	state = "ACTIVE" AND (age >= 30 OR labels.team:*) AND -tags:"legacy"
+//
+// It supports AND, OR (which binds tighter than AND, as the AIP specifies), NOT and -,
+// implicit AND between adjacent restrictions, the =, !=, <, <=, > and >= comparators,
+// * wildcards in string values compared with = or !=, and the : has operator: field:*
+// checks that a field or map key is present, and field:value that a repeated field listed
+// in AIP160Options.Repeated contains the value, that a map listed in AIP160Options.Maps has
+// the value as a key, or that any other field equals the value, * wildcards included.
+// Values can be compared against a parenthesized list, e.g. state = ("ACTIVE" OR "PENDING").
+// The timestamp() function is accepted for values. Global restrictions (bare values
+// searched across all fields) are not supported
+func CompileAIP160(filter string, options ...AIP160Options) (*Query, error) {
+	p := &aipParser{lexer: aipLexer{input: filter}}
+	if len(options) > 0 {
+		p.options = options[0]
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if p.token.kind == aipEOF {
+		return &Query{}, nil
+	}
+
+	condition, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	if p.token.kind != aipEOF {
+		return nil, p.unexpected()
+	}
+	return &Query{condition: condition}, nil
+}
+
+// aipTokenKind is the kind of a token in an AIP-160 filter
+type aipTokenKind int
+
+const (
+	aipEOF        aipTokenKind = iota
+	aipText                    // An unquoted word, number or keyword
+	aipString                  // A quoted string, with escapes still in place
+	aipComparator              // =, !=, <, <=, >, >= or :
+	aipLeftParen
+	aipRightParen
+	aipDot
+	aipComma
+	aipMinus
+)
+
+// aipToken is a token in an AIP-160 filter
+type aipToken struct {
+	kind aipTokenKind
+	text string
+	pos  int
+}
+
+// aipLexer splits an AIP-160 filter into tokens
+type aipLexer struct {
+	input string
+	pos   int
+}
+
+// next returns the next token
+func (l *aipLexer) next() (aipToken, error) {
+	for l.pos < len(l.input) && isAIPSpace(l.input[l.pos]) {
+		l.pos++
+	}
+	start := l.pos
+	if l.pos >= len(l.input) {
+		return aipToken{kind: aipEOF, pos: start}, nil
+	}
+
+	single := map[byte]aipTokenKind{'(': aipLeftParen, ')': aipRightParen, '.': aipDot, ',': aipComma, '-': aipMinus}
+	switch ch := l.input[l.pos]; {
+	case ch == '"' || ch == '\'':
+		l.pos++
+		for l.pos < len(l.input) && l.input[l.pos] != ch {
+			if l.input[l.pos] == '\\' {
+				l.pos++
+			}
+			l.pos++
+		}
+		if l.pos >= len(l.input) {
+			return aipToken{}, fmt.Errorf("unterminated string at position %d", start)
+		}
+		l.pos++
+		return aipToken{kind: aipString, text: l.input[start+1 : l.pos-1], pos: start}, nil
+	case strings.HasPrefix(l.input[l.pos:], "<=") || strings.HasPrefix(l.input[l.pos:], ">=") || strings.HasPrefix(l.input[l.pos:], "!="):
+		l.pos += 2
+		return aipToken{kind: aipComparator, text: l.input[start:l.pos], pos: start}, nil
+	case ch == '<' || ch == '>' || ch == '=' || ch == ':':
+		l.pos++
+		return aipToken{kind: aipComparator, text: l.input[start:l.pos], pos: start}, nil
+	case ch >= '0' && ch <= '9':
+		return l.number(), nil
+	default:
+		if kind, ok := single[ch]; ok {
+			l.pos++
+			return aipToken{kind: kind, text: string(ch), pos: start}, nil
+		}
+	}
+
+	for l.pos < len(l.input) {
+		r, size := utf8.DecodeRuneInString(l.input[l.pos:])
+		if r < utf8.RuneSelf && (isAIPSpace(byte(r)) || strings.ContainsRune(`()<>=!:.,"'`, r)) {
+			break
+		}
+		if r == '-' && l.pos == start {
+			break
+		}
+		if r != '_' && r != '*' && r != '-' && r != '/' && r != '@' && r != '+' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return aipToken{}, fmt.Errorf("unexpected character %q at position %d", r, l.pos)
+		}
+		l.pos += size
+	}
+	return aipToken{kind: aipText, text: l.input[start:l.pos], pos: start}, nil
+}
+
+// number lexes an integer or decimal number, with an optional exponent
+func (l *aipLexer) number() aipToken {
+	start := l.pos
+	digits := func() {
+		for l.pos < len(l.input) && l.input[l.pos] >= '0' && l.input[l.pos] <= '9' {
+			l.pos++
+		}
+	}
+
+	digits()
+	if l.pos+1 < len(l.input) && l.input[l.pos] == '.' && l.input[l.pos+1] >= '0' && l.input[l.pos+1] <= '9' {
+		l.pos++
+		digits()
+	}
+	if l.pos < len(l.input) && (l.input[l.pos] == 'e' || l.input[l.pos] == 'E') {
+		l.pos++
+		if l.pos < len(l.input) && (l.input[l.pos] == '+' || l.input[l.pos] == '-') {
+			l.pos++
+		}
+		digits()
+	}
+	// Words starting with digits, such as 20s or 3rd, are text
+	for l.pos < len(l.input) && (isIdentChar(l.input[l.pos]) || l.input[l.pos] == '*') {
+		l.pos++
+	}
+	return aipToken{kind: aipText, text: l.input[start:l.pos], pos: start}
+}
+
+// isAIPSpace reports whether a byte is whitespace separating tokens
+func isAIPSpace(ch byte) bool {
+	return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r'
+}
+
+// aipParser is a recursive descent parser for the AIP-160 grammar
+type aipParser struct {
+	lexer   aipLexer
+	token   aipToken
+	depth   parseDepth
+	options AIP160Options
+}
+
+// advance moves to the next token
+func (p *aipParser) advance() error {
+	token, err := p.lexer.next()
+	if err != nil {
+		return err
+	}
+	p.token = token
+	return nil
+}
+
+// unexpected returns an error for the current token
+func (p *aipParser) unexpected() error {
+	if p.token.kind == aipEOF {
+		return fmt.Errorf("unexpected end of filter")
+	}
+	return fmt.Errorf("unexpected %q at position %d", p.token.text, p.token.pos)
+}
+
+// isKeyword reports whether the current token is the given keyword
+func (p *aipParser) isKeyword(keyword string) bool {
+	return p.token.kind == aipText && p.token.text == keyword
+}
+
+// expression parses sequences joined by AND
+func (p *aipParser) expression() (Condition, error) {
+	if err := p.depth.enter(); err != nil {
+		return nil, err
+	}
+	defer p.depth.leave()
+	condition, err := p.sequence()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("AND") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		right, err := p.sequence()
+		if err != nil {
+			return nil, err
+		}
+		condition = &AndCondition{left: condition, right: right}
+	}
+	return condition, nil
+}
+
+// sequence parses adjacent factors, which are implicitly joined by AND
+func (p *aipParser) sequence() (Condition, error) {
+	condition, err := p.factor()
+	if err != nil {
+		return nil, err
+	}
+	for p.token.kind != aipEOF && p.token.kind != aipRightParen && !p.isKeyword("AND") {
+		right, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		condition = &AndCondition{left: condition, right: right}
+	}
+	return condition, nil
+}
+
+// factor parses terms joined by OR
+func (p *aipParser) factor() (Condition, error) {
+	condition, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("OR") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		right, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		condition = &OrCondition{left: condition, right: right}
+	}
+	return condition, nil
+}
+
+// term parses a simple expression, optionally negated with NOT or -
+func (p *aipParser) term() (Condition, error) {
+	if p.isKeyword("NOT") || p.token.kind == aipMinus {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		condition, err := p.simple()
+		if err != nil {
+			return nil, err
+		}
+		return &NotCondition{condition: condition}, nil
+	}
+	return p.simple()
+}
+
+// simple parses a parenthesized expression or a restriction
+func (p *aipParser) simple() (Condition, error) {
+	if p.token.kind == aipLeftParen {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		condition, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		if p.token.kind != aipRightParen {
+			return nil, p.unexpected()
+		}
+		return condition, p.advance()
+	}
+	return p.restriction()
+}
+
+// restriction parses a comparison between a field and a value
+func (p *aipParser) restriction() (Condition, error) {
+	if p.token.kind != aipText && p.token.kind != aipString || p.isKeyword("AND") || p.isKeyword("OR") || p.isKeyword("NOT") {
+		return nil, p.unexpected()
+	}
+	start := p.token
+
+	field, err := p.member()
+	if err != nil {
+		return nil, err
+	}
+	if p.token.kind == aipLeftParen {
+		return nil, fmt.Errorf("unsupported function %s at position %d", field, start.pos)
+	}
+	if p.token.kind != aipComparator {
+		return nil, fmt.Errorf("global restrictions are not supported, expected a comparator after %q at position %d", start.text, start.pos)
+	}
+	comparator := p.token.text
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	// A parenthesized list applies the comparator to each value, e.g. state = ("A" OR "B")
+	if p.token.kind == aipLeftParen {
+		return p.valueList(field, comparator)
+	}
+
+	value, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	return p.comparison(field, comparator, value)
+}
+
+// member parses a field path such as labels.team or labels."app.kubernetes.io/name"
+func (p *aipParser) member() (string, error) {
+	var parts []string
+	for {
+		if p.token.kind != aipText && p.token.kind != aipString {
+			return "", p.unexpected()
+		}
+		part := p.token.text
+		if p.token.kind == aipString {
+			part = unescapeAIP(part)
+		}
+		parts = append(parts, part)
+
+		if err := p.advance(); err != nil {
+			return "", err
+		}
+		if p.token.kind != aipDot {
+			return formatFieldPath(parts), nil
+		}
+		if err := p.advance(); err != nil {
+			return "", err
+		}
+	}
+}
+
+// valueList parses a parenthesized list of values joined by OR or by AND, and applies the
+// comparator to each of them
+func (p *aipParser) valueList(field, comparator string) (Condition, error) {
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	var condition Condition
+	operator := ""
+	for {
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		next, err := p.comparison(field, comparator, value)
+		if err != nil {
+			return nil, err
+		}
+
+		switch operator {
+		case "":
+			condition = next
+		case "OR":
+			condition = &OrCondition{left: condition, right: next}
+		case "AND":
+			condition = &AndCondition{left: condition, right: next}
+		}
+
+		if p.token.kind == aipRightParen {
+			return condition, p.advance()
+		}
+		if !p.isKeyword("OR") && !p.isKeyword("AND") || operator != "" && p.token.text != operator {
+			return nil, p.unexpected()
+		}
+		operator = p.token.text
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// aipValue is a value on the right side of a restriction
+type aipValue struct {
+	Literal
+	wildcard bool // The value is a string containing unescaped * wildcards
+	pattern  string
+}
+
+// value parses a literal value, a negative number or a timestamp() call
+func (p *aipParser) value() (aipValue, error) {
+	token := p.token
+	switch token.kind {
+	case aipString:
+		text, pattern, wildcard := unquoteAIP(token.text)
+		return aipValue{Literal: Literal{text: text, kind: StringLiteral}, wildcard: wildcard, pattern: pattern}, p.advance()
+	case aipMinus:
+		if err := p.advance(); err != nil {
+			return aipValue{}, err
+		}
+		if _, err := strconv.ParseFloat(p.token.text, 64); p.token.kind != aipText || err != nil {
+			return aipValue{}, p.unexpected()
+		}
+		value := aipValue{Literal: Literal{text: "-" + p.token.text, kind: NumberLiteral}}
+		return value, p.advance()
+	case aipText:
+		if token.text == "AND" || token.text == "OR" || token.text == "NOT" {
+			return aipValue{}, p.unexpected()
+		}
+	default:
+		return aipValue{}, p.unexpected()
+	}
+
+	if err := p.advance(); err != nil {
+		return aipValue{}, err
+	}
+	if p.token.kind == aipLeftParen {
+		return p.function(token)
+	}
+
+	// Bare words are strings unless they are numbers or booleans, and dotted words such as
+	// hostnames are joined back together
+	text := token.text
+	for p.token.kind == aipDot {
+		if err := p.advance(); err != nil {
+			return aipValue{}, err
+		}
+		if p.token.kind != aipText {
+			return aipValue{}, p.unexpected()
+		}
+		text += "." + p.token.text
+		if err := p.advance(); err != nil {
+			return aipValue{}, err
+		}
+	}
+
+	switch {
+	case text == "true" || text == "false":
+		return aipValue{Literal: Literal{text: text, kind: BoolLiteral}}, nil
+	case isAIPNumber(text):
+		return aipValue{Literal: Literal{text: text, kind: NumberLiteral}}, nil
+	}
+	pattern := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`, "*", "%").Replace(text)
+	return aipValue{Literal: Literal{text: text, kind: StringLiteral}, wildcard: strings.Contains(text, "*"), pattern: pattern}, nil
+}
+
+// function parses a function call used as a value, of which only timestamp() is supported
+func (p *aipParser) function(name aipToken) (aipValue, error) {
+	if name.text != "timestamp" {
+		return aipValue{}, fmt.Errorf("unsupported function %s at position %d", name.text, name.pos)
+	}
+	if err := p.advance(); err != nil {
+		return aipValue{}, err
+	}
+	if p.token.kind != aipString {
+		return aipValue{}, fmt.Errorf("timestamp() expects a quoted RFC 3339 timestamp at position %d", p.token.pos)
+	}
+	text, _, _ := unquoteAIP(p.token.text)
+	if _, ok := parseTime(text); !ok {
+		return aipValue{}, fmt.Errorf("invalid timestamp %q at position %d", text, p.token.pos)
+	}
+	if err := p.advance(); err != nil {
+		return aipValue{}, err
+	}
+	if p.token.kind != aipRightParen {
+		return aipValue{}, p.unexpected()
+	}
+	return aipValue{Literal: Literal{text: text, kind: StringLiteral}}, p.advance()
+}
+
+// aipComparison builds the condition for a field, a comparator and a value
+func (p *aipParser) comparison(field, comparator string, value aipValue) (Condition, error) {
+	switch comparator {
+	case "=", "!=":
+		var condition Condition
+		switch {
+		case value.wildcard:
+			condition = newLikeCondition(field, Literal{text: value.pattern, kind: StringLiteral}, false)
+		case comparator == "=":
+			return &EqualCondition{field: field, value: value.Literal}, nil
+		default:
+			return &NotEqualCondition{field: field, value: value.Literal}, nil
+		}
+		if comparator == "!=" {
+			// Like inequalities, a negated wildcard match requires the field to be present
+			return &AndCondition{left: &HasCondition{field: field}, right: &NotCondition{condition: condition}}, nil
+		}
+		return condition, nil
+	case "<":
+		return &ComparisonCondition{field: field, value: value.Literal, operator: "<"}, nil
+	case "<=":
+		return &ComparisonCondition{field: field, value: value.Literal, operator: "<="}, nil
+	case ">":
+		return &ComparisonCondition{field: field, value: value.Literal, operator: ">"}, nil
+	case ">=":
+		return &ComparisonCondition{field: field, value: value.Literal, operator: ">="}, nil
+	case ":":
+		if value.kind == StringLiteral && value.text == "*" {
+			return &HasCondition{field: field}, nil
+		}
+		return p.has(field, value)
+	}
+	return nil, fmt.Errorf("unsupported comparator %s", comparator)
+}
+
+// has builds the condition for field:value, whose meaning depends on what the options
+// declare the field to be: a repeated field must contain the value, a map must have the
+// value as a key, and any other field must equal the value, or match it when it has wildcards
+func (p *aipParser) has(field string, value aipValue) (Condition, error) {
+	pattern := Literal{text: value.pattern, kind: StringLiteral}
+	switch {
+	case aipFieldListed(p.options.Repeated, field):
+		if value.wildcard {
+			return &QuantifierCondition{field: field, condition: newLikeCondition("", pattern, false)}, nil
+		}
+		return &AnyArrayContainsCondition{field: field, value: value.Literal}, nil
+	case aipFieldListed(p.options.Maps, field):
+		if value.wildcard {
+			return nil, fmt.Errorf("wildcards are not supported in the keys of map %s", field)
+		}
+		return &HasCondition{field: formatFieldPath(append(splitFieldPath(field), value.text))}, nil
+	case value.wildcard:
+		return newLikeCondition(field, pattern, false), nil
+	}
+	return &EqualCondition{field: field, value: value.Literal}, nil
+}
+
+// aipFieldListed reports whether a field is one of the listed fields, ignoring case
+func aipFieldListed(fields []string, field string) bool {
+	for _, listed := range fields {
+		if strings.EqualFold(formatFieldPath(splitFieldPath(listed)), field) {
+			return true
+		}
+	}
+	return false
+}
+
+// unquoteAIP processes the escapes of a quoted string. It returns the string, the equivalent
+// LIKE pattern where unescaped * wildcards become %, and whether there were any wildcards
+func unquoteAIP(quoted string) (text, pattern string, wildcard bool) {
+	var t, pat strings.Builder
+	escaped := false
+	for _, r := range quoted {
+		switch {
+		case escaped:
+			escaped = false
+			switch r {
+			case 'n':
+				r = '\n'
+			case 't':
+				r = '\t'
+			case 'r':
+				r = '\r'
+			}
+			t.WriteRune(r)
+			if r == '%' || r == '_' || r == '\\' || r == '*' {
+				pat.WriteByte('\\')
+			}
+			pat.WriteRune(r)
+		case r == '\\':
+			escaped = true
+		case r == '*':
+			wildcard = true
+			t.WriteRune(r)
+			pat.WriteByte('%')
+		default:
+			t.WriteRune(r)
+			if r == '%' || r == '_' {
+				pat.WriteByte('\\')
+			}
+			pat.WriteRune(r)
+		}
+	}
+	if wildcard {
+		return t.String(), pat.String(), true
+	}
+	return t.String(), "", false
+}
+
+// unescapeAIP processes the escapes of a quoted field name
+func unescapeAIP(quoted string) string {
+	text, _, _ := unquoteAIP(quoted)
+	return text
+}
+
+// isAIPNumber reports whether a word is an integer or decimal number
+func isAIPNumber(text string) bool {
+	if text == "" || text[0] < '0' || text[0] > '9' {
+		return false
+	}
+	_, err := strconv.ParseFloat(text, 64)
+	return err == nil
+}
@@ -0,0 +1,207 @@
+// Copyright 2023 Daniel Valdivia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rql
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// aipPeopleOptions declares the repeated fields of TestPerson
+var aipPeopleOptions = AIP160Options{Repeated: []string{"Tags", "Metadata.Categories", "Scores"}}
+
+func TestCompileAIP160(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  string
+		wantIDs []int
+		wantErr bool
+	}{
+		{name: "empty filter", filter: "  ", wantIDs: []int{1, 2, 3, 4, 5}},
+		{name: "equality", filter: `Name = "Alice"`, wantIDs: []int{1}},
+		{name: "single quotes", filter: `Name = 'Alice'`, wantIDs: []int{1}},
+		{name: "bare values", filter: `Email = alice@example.com`, wantIDs: []int{1}},
+		{name: "AND", filter: `Age >= 30 AND Active = true`, wantIDs: []int{2, 4}},
+		{name: "OR binds tighter than AND", filter: `Age > 40 OR Name = "Alice" AND Active = true`, wantIDs: []int{1}},
+		{name: "implicit AND", filter: `Active = true Department.Name = "Engineering"`, wantIDs: []int{1, 2}},
+		{name: "parentheses", filter: `(Age > 40 OR Name = "Alice") AND Active = true`, wantIDs: []int{1}},
+		{name: "negation", filter: `-Name = "Alice" NOT Age < 30`, wantIDs: []int{2, 3, 4, 5}},
+		{name: "NOT before parentheses", filter: `NOT (Active = true OR Age = 35)`, wantIDs: []int{5}},
+		{name: "inequality", filter: `Department.Name != "Engineering"`, wantIDs: []int{3, 4, 5}},
+		{name: "negative numbers", filter: `Age > -1 AND Age < 30.5`, wantIDs: []int{1, 2}},
+		{name: "has element", filter: `Tags:"go"`, wantIDs: []int{2}},
+		{name: "has element with a bare value", filter: `Metadata.Categories:developer`, wantIDs: []int{1, 2}},
+		{name: "has numeric element", filter: `Scores:90`, wantIDs: []int{1, 3}},
+		{name: "negated has", filter: `-Tags:go`, wantIDs: []int{1, 3, 4, 5}},
+		{name: "presence", filter: `Department.Location:*`, wantIDs: []int{1, 2, 3, 4, 5}},
+		{name: "missing field", filter: `Department.Budget:*`, wantIDs: []int{}},
+		{name: "wildcard", filter: `Email = "*@example.com"`, wantIDs: []int{1, 2, 3, 5}},
+		{name: "bare wildcard", filter: `Name = A*`, wantIDs: []int{1}},
+		{name: "negated wildcard", filter: `Email != "*@example.com"`, wantIDs: []int{4}},
+		{name: "escaped asterisk", filter: `Name = "A\*"`, wantIDs: []int{}},
+		{name: "value list with OR", filter: `Name = ("Alice" OR "Bob")`, wantIDs: []int{1, 2}},
+		{name: "value list with AND", filter: `Name != ("Alice" AND "Bob")`, wantIDs: []int{3, 4, 5}},
+		{name: "global restriction", filter: `Alice`, wantErr: true},
+		{name: "missing value", filter: `Name =`, wantErr: true},
+		{name: "missing right operand", filter: `Name = "Alice" AND`, wantErr: true},
+		{name: "unbalanced parentheses", filter: `(Name = "Alice"`, wantErr: true},
+		{name: "unterminated string", filter: `Name = "Alice`, wantErr: true},
+		{name: "unknown function", filter: `Name = lower("Alice")`, wantErr: true},
+		{name: "function restriction", filter: `matches(Name, "A")`, wantErr: true},
+		{name: "invalid timestamp", filter: `Created > timestamp("yesterday")`, wantErr: true},
+		{name: "mixed value list", filter: `Name = ("Alice" OR "Bob" AND "Eve")`, wantErr: true},
+		{name: "unknown comparator", filter: `Name ~ "A"`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := CompileAIP160(tt.filter, aipPeopleOptions)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CompileAIP160() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			gotIDs := []int{}
+			for _, person := range ApplyQuery(query, testPeople).Items {
+				gotIDs = append(gotIDs, person.ID)
+			}
+			if !reflect.DeepEqual(gotIDs, tt.wantIDs) {
+				t.Errorf("CompileAIP160() got IDs = %v, want %v", gotIDs, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func TestCompileAIP160Timestamp(t *testing.T) {
+	type event struct {
+		Name    string
+		Created time.Time
+	}
+	events := []event{
+		{Name: "old", Created: time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{Name: "new", Created: time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	query, err := CompileAIP160(`Created >= timestamp("2024-01-01T00:00:00Z")`)
+	if err != nil {
+		t.Fatalf("CompileAIP160() unexpected error = %v", err)
+	}
+	if result := ApplyQuery(query, events); result.Count != 1 || result.Items[0].Name != "new" {
+		t.Errorf("CompileAIP160() matched %v, want the new event", result.Items)
+	}
+}
+
+// TestCompileAIP160Has tests that the : operator matches depending on what the options
+// declare the field to be
+func TestCompileAIP160Has(t *testing.T) {
+	item := map[string]any{
+		"name":   "a",
+		"tags":   []any{"go", "rust"},
+		"labels": map[string]any{"env": "prod", "tier": "web"},
+		"count":  3,
+	}
+
+	options := AIP160Options{Repeated: []string{"Tags"}, Maps: []string{"labels"}}
+
+	tests := []struct {
+		filter  string
+		want    bool
+		wantErr bool
+	}{
+		{filter: `tags:go`, want: true},
+		{filter: `tags:java`, want: false},
+		{filter: `tags:"ru*"`, want: true},
+		{filter: `labels:env`, want: true},
+		{filter: `labels:prod`, want: false},
+		{filter: `labels.env:prod`, want: true},
+		{filter: `labels.env:"pr*"`, want: true},
+		{filter: `labels.env:dev`, want: false},
+		{filter: `labels.env:*`, want: true},
+		{filter: `labels.owner:*`, want: false},
+		{filter: `name:a`, want: true},
+		{filter: `name:b`, want: false},
+		{filter: `name:"*"`, want: true},
+		{filter: `count:3`, want: true},
+		{filter: `count:4`, want: false},
+		{filter: `missing:a`, want: false},
+		{filter: `-labels:env`, want: false},
+		{filter: `labels:"e*"`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		query, err := CompileAIP160(tt.filter, options)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("CompileAIP160(%q) error = %v, wantErr %v", tt.filter, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if got := query.Match(item); got != tt.want {
+			t.Errorf("CompileAIP160(%q) matches = %v, want %v", tt.filter, got, tt.want)
+		}
+	}
+	// Without options, : compares any field for equality
+	for filter, want := range map[string]bool{`name:a`: true, `tags:go`: false, `labels:env`: false} {
+		query, err := CompileAIP160(filter)
+		if err != nil {
+			t.Fatalf("CompileAIP160(%q) unexpected error = %v", filter, err)
+		}
+		if got := query.Match(item); got != want {
+			t.Errorf("CompileAIP160(%q) without options matches = %v, want %v", filter, got, want)
+		}
+	}
+}
+
+// TestCompileAIP160ConditionTree tests that AIP-160 filters compile to the same conditions as
+// the equivalent SQL-like filters, so translators work unchanged
+func TestCompileAIP160ConditionTree(t *testing.T) {
+	options := AIP160Options{Repeated: []string{"tags", "scores"}, Maps: []string{"labels"}}
+
+	tests := []struct {
+		aip string
+		sql string
+	}{
+		{aip: `age >= 30 AND (name = "Bob" OR tags:"go")`, sql: `age >= 30 AND (name = 'Bob' OR ANY(tags) = 'go')`},
+		{aip: `-active = true department.name != "HR"`, sql: `NOT active = true AND department.name != 'HR'`},
+		{aip: `labels.env:*`, sql: `has(labels.env)`},
+		{aip: `email = "*@example.com"`, sql: `email LIKE '%@example.com'`},
+		{aip: `scores:90 OR score < 4.5`, sql: `ANY(scores) = 90 OR score < 4.5`},
+		{aip: `tags:"g*"`, sql: `ANY(tags, "" LIKE 'g%')`},
+		{aip: `labels:"env"`, sql: `has(labels.env)`},
+		{aip: `labels.env:"prod*"`, sql: `labels.env LIKE 'prod%'`},
+		{aip: `name:"Al"`, sql: `name = 'Al'`},
+		{aip: `name:"Al*"`, sql: `name LIKE 'Al%'`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.aip, func(t *testing.T) {
+			aip, err := CompileAIP160(tt.aip, options)
+			if err != nil {
+				t.Fatalf("CompileAIP160() unexpected error = %v", err)
+			}
+			sql, err := Compile(tt.sql)
+			if err != nil {
+				t.Fatalf("Compile() unexpected error = %v", err)
+			}
+			if !reflect.DeepEqual(aip, sql) {
+				t.Errorf("CompileAIP160(%q) = %#v, want %#v", tt.aip, aip.condition, sql.condition)
+			}
+		})
+	}
+}
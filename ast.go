@@ -0,0 +1,260 @@
+// Copyright 2023 Daniel Valdivia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rql
+
+import (
+	"maps"
+	"slices"
+)
+
+// Condition returns the root of the query's syntax tree, or nil for an empty query
+func (q *Query) Condition() Condition {
+	return q.condition
+}
+
+// Fields returns the full paths of the fields the query references, sorted and without
+// duplicates. Fields inside quantifiers are prefixed with the quantified field
+func (q *Query) Fields() []string {
+	fields := map[string]bool{}
+	collectFields(q.condition, nil, fields)
+	return slices.Sorted(maps.Keys(fields))
+}
+
+// collectFields adds the full paths of the fields referenced by a condition to fields
+func collectFields(condition Condition, scope []string, fields map[string]bool) {
+	Inspect(condition, func(c Condition) bool {
+		// A function's comparison refers to its result, so its fields are those of its arguments
+		if function, ok := c.(*FunctionCondition); ok {
+			for _, field := range function.function.Fields() {
+				fields[formatFieldPath(append(slices.Clip(scope), SplitFieldPath(field)...))] = true
+			}
+			return false
+		}
+
+		field, ok := c.(interface{ Field() string })
+		if !ok {
+			return true
+		}
+		path := formatFieldPath(append(slices.Clip(scope), SplitFieldPath(field.Field())...))
+		fields[path] = true
+
+		// Fields inside a quantifier are relative to the quantified field
+		if quantifier, ok := c.(*QuantifierCondition); ok {
+			collectFields(quantifier.condition, splitFieldPath(path), fields)
+			return false
+		}
+		return true
+	})
+}
+
+// SplitFieldPath splits a field path into its parts, e.g. Labels['app.kubernetes.io/name']
+// into Labels and app.kubernetes.io/name
+func SplitFieldPath(field string) []string {
+	if field == "" {
+		return nil
+	}
+	return splitFieldPath(field)
+}
+
+// A Visitor's Visit method is called by Walk for each condition. If the returned visitor w
+// is not nil, Walk visits each of the condition's operands with w, followed by a call of
+// w.Visit(nil)
+type Visitor interface {
+	Visit(condition Condition) (w Visitor)
+}
+
+// Walk traverses a syntax tree in depth-first order, starting with v.Visit(condition)
+func Walk(v Visitor, condition Condition) {
+	if v = v.Visit(condition); v == nil {
+		return
+	}
+
+	switch c := condition.(type) {
+	case *AndCondition:
+		Walk(v, c.left)
+		Walk(v, c.right)
+	case *OrCondition:
+		Walk(v, c.left)
+		Walk(v, c.right)
+	case *NotCondition:
+		Walk(v, c.condition)
+	case *QuantifierCondition:
+		Walk(v, c.condition)
+	case *FunctionCondition:
+		Walk(v, c.condition)
+	}
+
+	v.Visit(nil)
+}
+
+// inspector adapts a function to the Visitor interface
+type inspector func(Condition) bool
+
+func (f inspector) Visit(condition Condition) Visitor {
+	if f(condition) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses a syntax tree in depth-first order, calling f for each condition. If f
+// returns true, Inspect visits the condition's operands, followed by a call of f(nil)
+func Inspect(condition Condition, f func(Condition) bool) {
+	if condition == nil {
+		return
+	}
+	Walk(inspector(f), condition)
+}
+
+// Left returns the left operand
+func (c *AndCondition) Left() Condition { return c.left }
+
+// Right returns the right operand
+func (c *AndCondition) Right() Condition { return c.right }
+
+// Left returns the left operand
+func (c *OrCondition) Left() Condition { return c.left }
+
+// Right returns the right operand
+func (c *OrCondition) Right() Condition { return c.right }
+
+// Operand returns the negated condition
+func (c *NotCondition) Operand() Condition { return c.condition }
+
+// Value returns the value of the condition
+func (c *ConstantCondition) Value() bool { return c.value }
+
+// Field returns the compared field
+func (c *EqualCondition) Field() string { return c.field }
+
+// Value returns the value the field is compared with
+func (c *EqualCondition) Value() Literal { return c.value }
+
+// Field returns the compared field
+func (c *NotEqualCondition) Field() string { return c.field }
+
+// Value returns the value the field is compared with
+func (c *NotEqualCondition) Value() Literal { return c.value }
+
+// Field returns the compared field
+func (c *ComparisonCondition) Field() string { return c.field }
+
+// Operator returns the comparison operator: >, >=, < or <=
+func (c *ComparisonCondition) Operator() string { return c.operator }
+
+// Value returns the value the field is compared with
+func (c *ComparisonCondition) Value() Literal { return c.value }
+
+// Field returns the matched field
+func (c *LikeCondition) Field() string { return c.field }
+
+// Value returns the pattern, where % matches any sequence of characters, _ any single
+// character and a backslash escapes the next character, whatever the filter's ESCAPE
+func (c *LikeCondition) Value() Literal { return c.value }
+
+// CaseInsensitive reports whether the condition is an ILIKE match
+func (c *LikeCondition) CaseInsensitive() bool { return c.caseInsensitive }
+
+// Field returns the matched field
+func (c *RegexCondition) Field() string { return c.field }
+
+// Value returns the regular expression, in Go's RE2 syntax
+func (c *RegexCondition) Value() Literal { return c.value }
+
+// CaseInsensitive reports whether the condition is a ~* match
+func (c *RegexCondition) CaseInsensitive() bool { return c.caseInsensitive }
+
+// Field returns the field or map key that must be present
+func (c *HasCondition) Field() string { return c.field }
+
+// Field returns the array field
+func (c *AnyArrayContainsCondition) Field() string { return c.field }
+
+// Value returns the value an element must equal
+func (c *AnyArrayContainsCondition) Value() Literal { return c.value }
+
+// Field returns the array field
+func (c *AnyArrayNotContainsCondition) Field() string { return c.field }
+
+// Value returns the value no element may equal
+func (c *AnyArrayNotContainsCondition) Value() Literal { return c.value }
+
+// Field returns the array field
+func (c *AnyArrayContainsAnyCondition) Field() string { return c.field }
+
+// Values returns the values any of which an element must equal
+func (c *AnyArrayContainsAnyCondition) Values() []Literal { return slices.Clone(c.values) }
+
+// Field returns the array field
+func (c *AnyArrayNotContainsAnyCondition) Field() string { return c.field }
+
+// Values returns the values no element may equal
+func (c *AnyArrayNotContainsAnyCondition) Values() []Literal { return slices.Clone(c.values) }
+
+// Field returns the quantified array field
+func (c *QuantifierCondition) Field() string { return c.field }
+
+// Predicate returns the condition applied to each element, whose fields are relative to
+// the element
+func (c *QuantifierCondition) Predicate() Condition { return c.condition }
+
+// All reports whether every element must match the predicate, rather than any element
+func (c *QuantifierCondition) All() bool { return c.all }
+
+// Function returns the function whose result is compared
+func (c *FunctionCondition) Function() *Function { return c.function }
+
+// Predicate returns the comparison applied to the function's result, whose field "" refers
+// to the result
+func (c *FunctionCondition) Predicate() Condition { return c.condition }
+
+// Name returns the lower-case name of the function, e.g. lower
+func (f *Function) Name() string { return f.name }
+
+// Args returns the arguments of the function
+func (f *Function) Args() []FunctionArg { return slices.Clone(f.args) }
+
+// Fields returns the fields the function's arguments reference, including those of nested
+// functions
+func (f *Function) Fields() []string {
+	var fields []string
+	for _, arg := range f.args {
+		if field, ok := arg.Field(); ok {
+			fields = append(fields, field)
+		} else if function, ok := arg.Function(); ok {
+			fields = append(fields, function.Fields()...)
+		}
+	}
+	return fields
+}
+
+// String returns the function call as written in a canonical filter, e.g. lower("Email")
+func (f *Function) String() string { return formatFunction(f) }
+
+// Field returns the field the argument references, if it's a field
+func (a FunctionArg) Field() (string, bool) {
+	return a.field, a.value == nil && a.function == nil
+}
+
+// Value returns the literal value of the argument, if it's a literal
+func (a FunctionArg) Value() (Literal, bool) {
+	if a.value == nil {
+		return Literal{}, false
+	}
+	return *a.value, true
+}
+
+// Function returns the function whose result is the argument, if it's a function call
+func (a FunctionArg) Function() (*Function, bool) { return a.function, a.function != nil }
@@ -0,0 +1,218 @@
+// Copyright 2023 Daniel Valdivia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rql
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// describe writes a condition as an S-expression using only the exported accessors, like
+// a translator outside the package would
+func describe(condition Condition) string {
+	switch c := condition.(type) {
+	case *AndCondition:
+		return fmt.Sprintf("(and %s %s)", describe(c.Left()), describe(c.Right()))
+	case *OrCondition:
+		return fmt.Sprintf("(or %s %s)", describe(c.Left()), describe(c.Right()))
+	case *NotCondition:
+		return fmt.Sprintf("(not %s)", describe(c.Operand()))
+	case *ConstantCondition:
+		return fmt.Sprint(c.Value())
+	case *EqualCondition:
+		return fmt.Sprintf("(= %s %s:%s)", c.Field(), c.Value(), c.Value().Kind())
+	case *NotEqualCondition:
+		return fmt.Sprintf("(!= %s %s:%s)", c.Field(), c.Value(), c.Value().Kind())
+	case *ComparisonCondition:
+		return fmt.Sprintf("(%s %s %#v)", c.Operator(), c.Field(), c.Value().Value())
+	case *LikeCondition:
+		op := "like"
+		if c.CaseInsensitive() {
+			op = "ilike"
+		}
+		return fmt.Sprintf("(%s %s %q)", op, c.Field(), c.Value().Text())
+	case *RegexCondition:
+		op := "~"
+		if c.CaseInsensitive() {
+			op = "~*"
+		}
+		return fmt.Sprintf("(%s %s %q)", op, c.Field(), c.Value().Text())
+	case *HasCondition:
+		return fmt.Sprintf("(has %q)", SplitFieldPath(c.Field()))
+	case *AnyArrayContainsCondition:
+		return fmt.Sprintf("(contains %s %s)", c.Field(), c.Value())
+	case *AnyArrayNotContainsCondition:
+		return fmt.Sprintf("(not-contains %s %s)", c.Field(), c.Value())
+	case *AnyArrayContainsAnyCondition:
+		return fmt.Sprintf("(contains-any %s %v)", c.Field(), c.Values())
+	case *AnyArrayNotContainsAnyCondition:
+		return fmt.Sprintf("(not-contains-any %s %v)", c.Field(), c.Values())
+	case *QuantifierCondition:
+		quantifier := "any"
+		if c.All() {
+			quantifier = "all"
+		}
+		return fmt.Sprintf("(%s %s %s)", quantifier, c.Field(), describe(c.Predicate()))
+	case *FunctionCondition:
+		return fmt.Sprintf("(apply %s %s)", describeFunction(c.Function()), describe(c.Predicate()))
+	}
+	return fmt.Sprintf("%T", condition)
+}
+
+// describeFunction writes a function call as an S-expression
+func describeFunction(function *Function) string {
+	parts := []string{function.Name()}
+	for _, arg := range function.Args() {
+		if field, ok := arg.Field(); ok {
+			parts = append(parts, field)
+		} else if value, ok := arg.Value(); ok {
+			parts = append(parts, value.String())
+		} else if nested, ok := arg.Function(); ok {
+			parts = append(parts, describeFunction(nested))
+		}
+	}
+	return "(" + strings.Join(parts, " ") + ")"
+}
+
+func TestConditionAccessors(t *testing.T) {
+	tests := []struct {
+		filter string
+		want   string
+	}{
+		{filter: `Age >= 30 AND Active = true`, want: `(and (>= age 30) (= active true:bool))`},
+		{filter: `NOT (Name ILIKE 'a%' OR Email LIKE '%@x.com')`, want: `(not (or (ilike name "a%") (like email "%@x.com")))`},
+		{filter: `Email ~* '@(a|b)\.com$' AND Name !~ '^A'`, want: `(and (~* email "@(a|b)\\.com$") (and (has ["name"]) (not (~ name "^A"))))`},
+		{filter: `Score < 4.5 OR Name != 'O''Brien'`, want: `(or (< score 4.5) (!= name 'O''Brien':string))`},
+		{filter: `Code = 7`, want: `(= code 7:number)`},
+		{filter: `has(Labels['app.kubernetes.io/name'])`, want: `(has ["labels" "app.kubernetes.io/name"])`},
+		{filter: `ANY(Tags) = 'go' AND ANY(Tags) != 'js'`, want: `(and (contains tags 'go') (not-contains tags 'js'))`},
+		{filter: `ANY(Tags) = ANY('a', 'b') OR ANY(Tags) != ANY(1, 2)`, want: `(or (contains-any tags ['a' 'b']) (not-contains-any tags [1 2]))`},
+		{filter: `ALL(Orders, ANY(Items, Quantity > 2)) AND true`, want: `(and (all orders (any items (> quantity 2))) true)`},
+		{filter: `lower(Name) NOT LIKE 'a%' OR length(trim(Email, '.')) > 3`, want: `(or (and (apply (lower name) (has [])) (not (apply (lower name) (like  "a%")))) (apply (length (trim email '.')) (>  3)))`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			query, err := Compile(tt.filter)
+			if err != nil {
+				t.Fatalf("Compile() unexpected error = %v", err)
+			}
+			if got := describe(query.Condition()); got != tt.want {
+				t.Errorf("describe() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConditionsAreImmutable(t *testing.T) {
+	query, err := Compile(`ANY(Tags) = ANY('go', 'rust')`)
+	if err != nil {
+		t.Fatalf("Compile() unexpected error = %v", err)
+	}
+
+	values := query.Condition().(*AnyArrayContainsAnyCondition).Values()
+	values[0] = Literal{text: "javascript"}
+	if got := ApplyQuery(query, testPeople); got.Count != 1 || got.Items[0].Name != "Bob" {
+		t.Errorf("modifying Values() changed the query, matched %v", got.Items)
+	}
+}
+
+// countingVisitor counts the conditions it visits and the calls marking the end of their
+// operands
+type countingVisitor struct {
+	visited []string
+	ends    int
+}
+
+func (v *countingVisitor) Visit(condition Condition) Visitor {
+	if condition == nil {
+		v.ends++
+		return nil
+	}
+	v.visited = append(v.visited, strings.TrimPrefix(fmt.Sprintf("%T", condition), "*rql."))
+	return v
+}
+
+func TestWalk(t *testing.T) {
+	query, err := Compile(`Age > 30 AND NOT (Name = 'Bob' OR ANY(Orders, Total > 10))`)
+	if err != nil {
+		t.Fatalf("Compile() unexpected error = %v", err)
+	}
+
+	v := &countingVisitor{}
+	Walk(v, query.Condition())
+	want := []string{"AndCondition", "ComparisonCondition", "NotCondition", "OrCondition", "EqualCondition", "QuantifierCondition", "ComparisonCondition"}
+	if !reflect.DeepEqual(v.visited, want) {
+		t.Errorf("Walk() visited %v, want %v", v.visited, want)
+	}
+	if v.ends != len(want) {
+		t.Errorf("Walk() called Visit(nil) %d times, want %d", v.ends, len(want))
+	}
+
+	// Returning false skips the operands, here the predicate of the quantifier
+	var fields []string
+	Inspect(query.Condition(), func(condition Condition) bool {
+		if field, ok := condition.(interface{ Field() string }); ok {
+			fields = append(fields, field.Field())
+		}
+		_, quantifier := condition.(*QuantifierCondition)
+		return !quantifier
+	})
+	if want := []string{"age", "name", "orders"}; !reflect.DeepEqual(fields, want) {
+		t.Errorf("Inspect() found fields %v, want %v", fields, want)
+	}
+
+	// Empty queries have no conditions to inspect
+	Inspect((&Query{}).Condition(), func(Condition) bool {
+		t.Errorf("Inspect() called for an empty query")
+		return true
+	})
+}
+
+func TestQueryFields(t *testing.T) {
+	tests := []struct {
+		filter string
+		want   []string
+	}{
+		{filter: ``, want: nil},
+		{filter: `Age > 30 AND (age < 40 OR Name = 'Bob')`, want: []string{"age", "name"}},
+		{filter: `ANY(Orders, Status = 'paid' AND ANY(Items, SKU = 'A'))`, want: []string{"orders", "orders.items", "orders.items.sku", "orders.status"}},
+		{filter: `has(Labels['app.kubernetes.io/name']) AND ANY(Tags) = 'go'`, want: []string{"labels['app.kubernetes.io/name']", "tags"}},
+		{filter: `coalesce(Nickname, lower(Name)) = 'al' AND ANY(Orders, length(Status) > 3)`, want: []string{"name", "nickname", "orders", "orders.status"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			query, err := Compile(tt.filter)
+			if err != nil {
+				t.Fatalf("Compile() unexpected error = %v", err)
+			}
+			if got := query.Fields(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Fields() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// Fields inside OData lambdas may refer to the elements themselves
+	query, err := CompileOData(`Scores/any(s: s gt 90)`)
+	if err != nil {
+		t.Fatalf("CompileOData() unexpected error = %v", err)
+	}
+	if got, want := query.Fields(), []string{"Scores"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Fields() = %q, want %q", got, want)
+	}
+}
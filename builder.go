@@ -0,0 +1,301 @@
+// Copyright 2023 Daniel Valdivia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rql
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Expr is a filter built in Go rather than parsed, e.g.
+//
+//	rql.Field("Age").Gte(30).And(rql.Field("Tags").AnyEq("go"))
+//
+// Values are kept apart from the filter's syntax, so they never need escaping. The zero
+// Expr matches everything
+type Expr struct {
+	condition Condition // nil for the zero Expr
+}
+
+// FieldRef is a reference to a field, from which expressions comparing it are built
+type FieldRef struct {
+	parts []string
+}
+
+// Field returns a reference to a field, given as a path like in filters, e.g. "Age",
+// "Department.Name" or "Labels['app.kubernetes.io/name']". Inside the predicate of Any or
+// All, paths are relative to the elements, and the empty path "" is the element itself
+func Field(path string) FieldRef {
+	return FieldRef{parts: SplitFieldPath(path)}
+}
+
+// Key returns a reference to a key of a map field, which may contain any character
+func (f FieldRef) Key(key string) FieldRef {
+	return FieldRef{parts: append(f.parts[:len(f.parts):len(f.parts)], key)}
+}
+
+// path returns the field path used by conditions
+func (f FieldRef) path() string {
+	return formatFieldPath(f.parts)
+}
+
+// Eq matches items whose field equals the value. Eq(nil) matches items where the field is
+// missing or nil
+func (f FieldRef) Eq(value any) Expr {
+	if isNil(value) {
+		return Expr{condition: &NotCondition{condition: &HasCondition{field: f.path()}}}
+	}
+	return Expr{condition: &EqualCondition{field: f.path(), value: literalOf(value)}}
+}
+
+// Ne matches items whose field is present and does not equal the value. Ne(nil) matches
+// items where the field is present and not nil
+func (f FieldRef) Ne(value any) Expr {
+	if isNil(value) {
+		return f.Has()
+	}
+	return Expr{condition: &NotEqualCondition{field: f.path(), value: literalOf(value)}}
+}
+
+// Gt matches items whose field is greater than the value
+func (f FieldRef) Gt(value any) Expr {
+	return f.compare(">", value)
+}
+
+// Gte matches items whose field is greater than or equal to the value
+func (f FieldRef) Gte(value any) Expr {
+	return f.compare(">=", value)
+}
+
+// Lt matches items whose field is less than the value
+func (f FieldRef) Lt(value any) Expr {
+	return f.compare("<", value)
+}
+
+// Lte matches items whose field is less than or equal to the value
+func (f FieldRef) Lte(value any) Expr {
+	return f.compare("<=", value)
+}
+
+// compare builds a comparison with one of the >, >=, < and <= operators
+func (f FieldRef) compare(operator string, value any) Expr {
+	return Expr{condition: &ComparisonCondition{field: f.path(), value: literalOf(value), operator: operator}}
+}
+
+// Like matches items whose field matches a LIKE pattern, where % matches any sequence of
+// characters and _ any single character
+func (f FieldRef) Like(pattern string) Expr {
+	return Expr{condition: newLikeCondition(f.path(), literalOf(pattern), false)}
+}
+
+// ILike matches items whose field matches a LIKE pattern, ignoring case
+func (f FieldRef) ILike(pattern string) Expr {
+	return Expr{condition: newLikeCondition(f.path(), literalOf(pattern), true)}
+}
+
+// In matches items whose field equals any of the values, and nothing without values
+func (f FieldRef) In(values ...any) Expr {
+	if len(values) == 0 {
+		return Expr{condition: &ConstantCondition{value: false}}
+	}
+	return Expr{condition: inCondition(f.path(), literalsOf(values), false)}
+}
+
+// NotIn matches items whose field is present and equals none of the values, and everything
+// without values
+func (f FieldRef) NotIn(values ...any) Expr {
+	if len(values) == 0 {
+		return Expr{condition: &ConstantCondition{value: true}}
+	}
+	return Expr{condition: inCondition(f.path(), literalsOf(values), true)}
+}
+
+// Matches matches items whose field matches the regular expression anywhere, like ~ in
+// filters. Flags such as (?i) are kept in the expression
+func (f FieldRef) Matches(re *regexp.Regexp) Expr {
+	// The expression was compiled already, so compiling it again can't fail
+	condition, _ := newRegexCondition(f.path(), Literal{text: re.String(), kind: StringLiteral}, false)
+	return Expr{condition: condition}
+}
+
+// Has matches items where the field or map key is present and not nil
+func (f FieldRef) Has() Expr {
+	return Expr{condition: &HasCondition{field: f.path()}}
+}
+
+// AnyEq matches items where any element of the slice field equals the value
+func (f FieldRef) AnyEq(value any) Expr {
+	return Expr{condition: &AnyArrayContainsCondition{field: f.path(), value: literalOf(value)}}
+}
+
+// AnyNe matches items where no element of the slice field equals the value
+func (f FieldRef) AnyNe(value any) Expr {
+	return Expr{condition: &AnyArrayNotContainsCondition{field: f.path(), value: literalOf(value)}}
+}
+
+// AnyIn matches items where any element of the slice field equals any of the values
+func (f FieldRef) AnyIn(values ...any) Expr {
+	if len(values) == 0 {
+		return Expr{condition: &ConstantCondition{value: false}}
+	}
+	return Expr{condition: &AnyArrayContainsAnyCondition{field: f.path(), values: literalsOf(values)}}
+}
+
+// AnyNotIn matches items where no element of the slice field equals any of the values
+func (f FieldRef) AnyNotIn(values ...any) Expr {
+	if len(values) == 0 {
+		return Expr{condition: &ConstantCondition{value: true}}
+	}
+	return Expr{condition: &AnyArrayNotContainsAnyCondition{field: f.path(), values: literalsOf(values)}}
+}
+
+// Any matches items where any element of the slice field matches the predicate, whose
+// fields are relative to the element
+func (f FieldRef) Any(predicate Expr) Expr {
+	return Expr{condition: &QuantifierCondition{field: f.path(), condition: predicate.orTrue()}}
+}
+
+// All matches items where every element of the slice field matches the predicate, whose
+// fields are relative to the element
+func (f FieldRef) All(predicate Expr) Expr {
+	return Expr{condition: &QuantifierCondition{field: f.path(), condition: predicate.orTrue(), all: true}}
+}
+
+// And matches items matching the expression and all of the others
+func (e Expr) And(others ...Expr) Expr {
+	for _, other := range others {
+		switch {
+		case other.condition == nil:
+		case e.condition == nil:
+			e = other
+		default:
+			e = Expr{condition: &AndCondition{left: e.condition, right: other.condition}}
+		}
+	}
+	return e
+}
+
+// Or matches items matching the expression or any of the others
+func (e Expr) Or(others ...Expr) Expr {
+	for _, other := range others {
+		// The zero Expr matches everything, and so does any disjunction with it
+		if e.condition == nil || other.condition == nil {
+			return Expr{}
+		}
+		e = Expr{condition: &OrCondition{left: e.condition, right: other.condition}}
+	}
+	return e
+}
+
+// Not matches items not matching the expression
+func (e Expr) Not() Expr {
+	return Expr{condition: &NotCondition{condition: e.orTrue()}}
+}
+
+// And matches items matching all of the expressions, and everything without expressions
+func And(exprs ...Expr) Expr {
+	return Expr{}.And(exprs...)
+}
+
+// Or matches items matching any of the expressions, and nothing without expressions
+func Or(exprs ...Expr) Expr {
+	if len(exprs) == 0 {
+		return Expr{condition: &ConstantCondition{value: false}}
+	}
+	return exprs[0].Or(exprs[1:]...)
+}
+
+// Not matches items not matching the expression
+func Not(expr Expr) Expr {
+	return expr.Not()
+}
+
+// Query returns the compiled query, which is the same as compiling the expression's
+// String. The zero Expr gives an empty query
+func (e Expr) Query() *Query {
+	return &Query{condition: e.condition}
+}
+
+// String returns the expression as a SQL-like filter, with fields and values quoted and
+// escaped, which can be passed to Compile or ApplyFilter, or to other services
+func (e Expr) String() string {
+	if e.condition == nil {
+		return ""
+	}
+	return formatCondition(e.condition)
+}
+
+// orTrue returns the expression's condition, or a true constant for the zero Expr
+func (e Expr) orTrue() Condition {
+	if e.condition == nil {
+		return &ConstantCondition{value: true}
+	}
+	return e.condition
+}
+
+// literalOf converts a Go value into a literal: strings, booleans, integers and floats,
+// including types derived from them, keep their kind, times are formatted as RFC 3339 and
+// any other value is formatted with fmt as a string
+func literalOf(value any) Literal {
+	if t, ok := value.(time.Time); ok {
+		return Literal{text: t.Format(time.RFC3339Nano), kind: StringLiteral}
+	}
+	if stringer, ok := value.(fmt.Stringer); ok {
+		return Literal{text: stringer.String(), kind: StringLiteral}
+	}
+
+	v := reflect.ValueOf(value)
+	for v.Kind() == reflect.Pointer && !v.IsNil() {
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return Literal{text: v.String(), kind: StringLiteral}
+	case reflect.Bool:
+		return Literal{text: strconv.FormatBool(v.Bool()), kind: BoolLiteral}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return Literal{text: strconv.FormatInt(v.Int(), 10), kind: NumberLiteral}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return Literal{text: strconv.FormatUint(v.Uint(), 10), kind: NumberLiteral}
+	case reflect.Float32, reflect.Float64:
+		// NaN and infinities have no number syntax in filters
+		if f := v.Float(); !math.IsNaN(f) && !math.IsInf(f, 0) {
+			return Literal{text: strconv.FormatFloat(f, 'g', -1, v.Type().Bits()), kind: NumberLiteral}
+		}
+	}
+	if v.IsValid() && v.Kind() != reflect.Pointer {
+		value = v.Interface()
+	}
+	return Literal{text: fmt.Sprint(value), kind: StringLiteral}
+}
+
+// literalsOf converts Go values into literals
+func literalsOf(values []any) []Literal {
+	literals := make([]Literal, len(values))
+	for i, value := range values {
+		literals[i] = literalOf(value)
+	}
+	return literals
+}
+
+// isNil reports whether a value is nil or a nil pointer
+func isNil(value any) bool {
+	v := reflect.ValueOf(value)
+	return !v.IsValid() || v.Kind() == reflect.Pointer && v.IsNil()
+}
@@ -0,0 +1,183 @@
+// Copyright 2023 Daniel Valdivia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rql
+
+import (
+	"math"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBuilder(t *testing.T) {
+	tests := []struct {
+		name       string
+		expr       Expr
+		wantFilter string
+		wantIDs    []int
+	}{
+		{
+			name:       "zero expression",
+			expr:       Expr{},
+			wantFilter: ``,
+			wantIDs:    []int{1, 2, 3, 4, 5},
+		},
+		{
+			name:       "comparison and element equality",
+			expr:       Field("Age").Gte(30).And(Field("Tags").AnyEq("go")),
+			wantFilter: `"Age" >= 30 AND ANY("Tags") = 'go'`,
+			wantIDs:    []int{2},
+		},
+		{
+			name:       "precedence is kept",
+			expr:       Field("Age").Gt(40).Or(Field("Name").Eq("Alice")).And(Field("Active").Eq(true)),
+			wantFilter: `("Age" > 40 OR "Name" = 'Alice') AND "Active" = true`,
+			wantIDs:    []int{1},
+		},
+		{
+			name:       "right-nested groups",
+			expr:       Field("Age").Lt(30).Or(Field("Age").Gt(40).Or(Field("Name").Eq("Charlie"))),
+			wantFilter: `"Age" < 30 OR ("Age" > 40 OR "Name" = 'Charlie')`,
+			wantIDs:    []int{1, 3, 5},
+		},
+		{
+			name:       "variadic and with zero expressions",
+			expr:       And(Expr{}, Field("Active").Eq(true), Expr{}, Field("Age").Lte(30)),
+			wantFilter: `"Active" = true AND "Age" <= 30`,
+			wantIDs:    []int{1, 2},
+		},
+		{
+			name:       "not",
+			expr:       Not(Field("Department.Name").In("Engineering", "Sales")),
+			wantFilter: `NOT ("Department"."Name" = 'Engineering' OR "Department"."Name" = 'Sales')`,
+			wantIDs:    []int{3, 5},
+		},
+		{
+			name:       "not in",
+			expr:       Field("Age").NotIn(25, 45),
+			wantFilter: `"Age" != 25 AND "Age" != 45`,
+			wantIDs:    []int{2, 3, 4},
+		},
+		{
+			name:       "like and ilike",
+			expr:       Field("Email").Like("%@example.com").And(Field("Name").ILike("b%").Not()),
+			wantFilter: `"Email" LIKE '%@example.com' AND NOT "Name" ILIKE 'b%'`,
+			wantIDs:    []int{1, 3, 5},
+		},
+		{
+			name:       "quotes in values",
+			expr:       Field("Name").Eq(`x' OR '1'='1`).Or(Field("Name").Ne(`"Bob"`)),
+			wantFilter: `"Name" = 'x'' OR ''1''=''1' OR "Name" != '"Bob"'`,
+			wantIDs:    []int{1, 2, 3, 4, 5},
+		},
+		{
+			name:       "element comparisons",
+			expr:       Field("Scores").AnyIn(99, 80).And(Field("Tags").AnyNe("content"), Field("Skills").AnyNotIn("x")),
+			wantFilter: `ANY("Scores") = ANY(99, 80) AND ANY("Tags") != 'content' AND ANY("Skills") != ANY('x')`,
+			wantIDs:    []int{2},
+		},
+		{
+			name:       "quantifiers over elements",
+			expr:       Field("Scores").All(Field("").Gte(85)).And(Field("Tags").Any(Field("").Like("java%"))),
+			wantFilter: `ALL("Scores", "" >= 85) AND ANY("Tags", "" LIKE 'java%')`,
+			wantIDs:    []int{1},
+		},
+		{
+			name:       "presence and nil",
+			expr:       Field("Department").Has().And(Field("Metadata").Key("Missing").Eq(nil)),
+			wantFilter: `has("Department") AND NOT has("Metadata"."Missing")`,
+			wantIDs:    []int{1, 2, 3, 4, 5},
+		},
+		{
+			name:       "map keys",
+			expr:       Field("Labels").Key("app.kubernetes.io/name").Eq("api"),
+			wantFilter: `"Labels"."app.kubernetes.io/name" = 'api'`,
+			wantIDs:    []int{},
+		},
+		{
+			name:       "empty lists",
+			expr:       Or(Field("Age").In(), Field("Tags").AnyIn()).Or(Field("Age").NotIn().And(Field("Tags").AnyNotIn()).Not()),
+			wantFilter: `false OR false OR NOT (true AND true)`,
+			wantIDs:    []int{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.expr.String(); got != tt.wantFilter {
+				t.Errorf("String() = %s, want %s", got, tt.wantFilter)
+			}
+
+			gotIDs := []int{}
+			for _, person := range ApplyQuery(tt.expr.Query(), testPeople).Items {
+				gotIDs = append(gotIDs, person.ID)
+			}
+			if !reflect.DeepEqual(gotIDs, tt.wantIDs) {
+				t.Errorf("ApplyQuery() got IDs = %v, want %v", gotIDs, tt.wantIDs)
+			}
+
+			// Parsing the rendered filter gives the same query
+			parsed, err := Compile(tt.expr.String())
+			if err != nil {
+				t.Fatalf("Compile(%q) unexpected error = %v", tt.expr.String(), err)
+			}
+			if !reflect.DeepEqual(parsed, tt.expr.Query()) {
+				t.Errorf("Compile(%q) = %#v, want %#v", tt.expr.String(), parsed.Condition(), tt.expr.Query().Condition())
+			}
+		})
+	}
+}
+
+type testLevel int
+
+func TestBuilderValues(t *testing.T) {
+	type status string
+	count := uint8(7)
+
+	tests := []struct {
+		value any
+		want  Literal
+	}{
+		{value: "it's", want: Literal{text: "it's", kind: StringLiteral}},
+		{value: status("paid"), want: Literal{text: "paid", kind: StringLiteral}},
+		{value: false, want: Literal{text: "false", kind: BoolLiteral}},
+		{value: -42, want: Literal{text: "-42", kind: NumberLiteral}},
+		{value: testLevel(3), want: Literal{text: "3", kind: NumberLiteral}},
+		{value: &count, want: Literal{text: "7", kind: NumberLiteral}},
+		{value: uint64(math.MaxUint64), want: Literal{text: "18446744073709551615", kind: NumberLiteral}},
+		{value: float32(0.1), want: Literal{text: "0.1", kind: NumberLiteral}},
+		{value: 1e21, want: Literal{text: "1e+21", kind: NumberLiteral}},
+		{value: math.Inf(1), want: Literal{text: "+Inf", kind: StringLiteral}},
+		{value: time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC), want: Literal{text: "2024-01-15T10:30:00Z", kind: StringLiteral}},
+		{value: netip.MustParseAddr("10.0.0.1"), want: Literal{text: "10.0.0.1", kind: StringLiteral}},
+		{value: []int{1}, want: Literal{text: "[1]", kind: StringLiteral}},
+	}
+
+	for _, tt := range tests {
+		expr := Field("x").Eq(tt.value)
+		if got := expr.Query().Condition().(*EqualCondition).Value(); got != tt.want {
+			t.Errorf("Eq(%#v) value = %#v, want %#v", tt.value, got, tt.want)
+		}
+
+		parsed, err := Compile(expr.String())
+		if err != nil {
+			t.Fatalf("Compile(%q) unexpected error = %v", expr.String(), err)
+		}
+		if !reflect.DeepEqual(parsed, expr.Query()) {
+			t.Errorf("Compile(%q) = %#v, want %#v", expr.String(), parsed.Condition(), expr.Query().Condition())
+		}
+	}
+}
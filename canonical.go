@@ -0,0 +1,411 @@
+// Copyright 2023 Daniel Valdivia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rql
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// String returns the canonical form of the query as a SQL-like filter, which compiles to an
+// equivalent query. The operands of AND and OR, and the values of ANY lists, are sorted and
+// deduplicated, fields are double-quoted, strings single-quoted, numbers written in their
+// shortest form, keywords upper-case and parentheses only used where needed, so filters
+// that differ only in these respects have the same canonical form. Field names keep their
+// case: NormalizeQuery resolves them against a type first. An empty query has an empty
+// canonical form
+func (q *Query) String() string {
+	if q.condition == nil {
+		return ""
+	}
+	return formatCondition(canonicalCondition(q.condition))
+}
+
+// Hash returns a hex-encoded SHA-256 hash of the canonical form of the query, suitable as a
+// cache key
+func (q *Query) Hash() string {
+	sum := sha256.Sum256([]byte(q.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// canonicalCondition returns a condition in canonical form, with numbers in their shortest
+// form and the operands of AND and OR and the values of ANY lists sorted and without
+// duplicates, which doesn't change the result as both operators are commutative,
+// associative and idempotent
+func canonicalCondition(condition Condition) Condition {
+	switch c := condition.(type) {
+	case *AndCondition:
+		return canonicalChain(c, flattenAnd(c), flattenAnd, func(left, right Condition) Condition {
+			return &AndCondition{left: left, right: right}
+		})
+	case *OrCondition:
+		return canonicalChain(c, flattenOr(c), flattenOr, func(left, right Condition) Condition {
+			return &OrCondition{left: left, right: right}
+		})
+	case *NotCondition:
+		return &NotCondition{condition: canonicalCondition(c.condition)}
+	case *EqualCondition:
+		return &EqualCondition{field: c.field, value: canonicalLiteral(c.value)}
+	case *NotEqualCondition:
+		return &NotEqualCondition{field: c.field, value: canonicalLiteral(c.value)}
+	case *ComparisonCondition:
+		return &ComparisonCondition{field: c.field, value: canonicalLiteral(c.value), operator: c.operator}
+	case *AnyArrayContainsCondition:
+		return &AnyArrayContainsCondition{field: c.field, value: canonicalLiteral(c.value)}
+	case *AnyArrayNotContainsCondition:
+		return &AnyArrayNotContainsCondition{field: c.field, value: canonicalLiteral(c.value)}
+	case *AnyArrayContainsAnyCondition:
+		return &AnyArrayContainsAnyCondition{field: c.field, values: canonicalValues(c.values)}
+	case *AnyArrayNotContainsAnyCondition:
+		return &AnyArrayNotContainsAnyCondition{field: c.field, values: canonicalValues(c.values)}
+	case *QuantifierCondition:
+		return &QuantifierCondition{field: c.field, condition: canonicalCondition(c.condition), all: c.all}
+	case *FunctionCondition:
+		return &FunctionCondition{function: canonicalFunction(c.function), condition: canonicalCondition(c.condition)}
+	}
+	return condition
+}
+
+// canonicalChain rebuilds a chain of AND or OR from its operands in canonical form. Operands
+// are flattened again once canonical, since removing duplicates may leave a single operand
+// of the same operator, and a single remaining operand replaces the chain
+func canonicalChain(parent Condition, operands []Condition, flatten func(Condition) []Condition, join func(left, right Condition) Condition) Condition {
+	type operand struct {
+		text      string
+		condition Condition
+	}
+
+	var canonical []operand
+	for _, condition := range operands {
+		for _, flat := range flatten(canonicalCondition(condition)) {
+			canonical = append(canonical, operand{text: formatOperand(parent, flat, true), condition: flat})
+		}
+	}
+	slices.SortFunc(canonical, func(a, b operand) int { return strings.Compare(a.text, b.text) })
+	canonical = slices.CompactFunc(canonical, func(a, b operand) bool { return a.text == b.text })
+
+	chain := canonical[0].condition
+	for _, next := range canonical[1:] {
+		chain = join(chain, next.condition)
+	}
+	return chain
+}
+
+// canonicalValues returns a list of values sorted and without duplicates
+func canonicalValues(values []Literal) []Literal {
+	canonical := make([]Literal, len(values))
+	for i, value := range values {
+		canonical[i] = canonicalLiteral(value)
+	}
+	slices.SortFunc(canonical, func(a, b Literal) int { return strings.Compare(a.String(), b.String()) })
+	return slices.CompactFunc(canonical, func(a, b Literal) bool { return a.String() == b.String() })
+}
+
+// formatCondition writes a condition as a SQL-like filter, which compiles to an identical
+// condition
+func formatCondition(condition Condition) string {
+	switch c := condition.(type) {
+	case *AndCondition:
+		return formatOperand(c, c.left, false) + " AND " + formatOperand(c, c.right, true)
+	case *OrCondition:
+		return formatOperand(c, c.left, false) + " OR " + formatOperand(c, c.right, true)
+	case *NotCondition:
+		return "NOT " + formatOperand(c, c.condition, false)
+	case *ConstantCondition:
+		if c.value {
+			return "true"
+		}
+		return "false"
+	case *EqualCondition:
+		return canonicalField(c.field) + " = " + c.value.String()
+	case *NotEqualCondition:
+		return canonicalField(c.field) + " != " + c.value.String()
+	case *ComparisonCondition:
+		return canonicalField(c.field) + " " + c.operator + " " + c.value.String()
+	case *LikeCondition:
+		if c.caseInsensitive {
+			return canonicalField(c.field) + " ILIKE " + c.value.String()
+		}
+		return canonicalField(c.field) + " LIKE " + c.value.String()
+	case *RegexCondition:
+		if c.caseInsensitive {
+			return canonicalField(c.field) + " ~* " + c.value.String()
+		}
+		return canonicalField(c.field) + " ~ " + c.value.String()
+	case *HasCondition:
+		return "has(" + canonicalField(c.field) + ")"
+	case *AnyArrayContainsCondition:
+		return "ANY(" + canonicalField(c.field) + ") = " + c.value.String()
+	case *AnyArrayNotContainsCondition:
+		return "ANY(" + canonicalField(c.field) + ") != " + c.value.String()
+	case *AnyArrayContainsAnyCondition:
+		return "ANY(" + canonicalField(c.field) + ") = ANY(" + formatValues(c.values) + ")"
+	case *AnyArrayNotContainsAnyCondition:
+		return "ANY(" + canonicalField(c.field) + ") != ANY(" + formatValues(c.values) + ")"
+	case *QuantifierCondition:
+		quantifier := "ANY("
+		if c.all {
+			quantifier = "ALL("
+		}
+		return quantifier + canonicalField(c.field) + ", " + formatCondition(c.condition) + ")"
+	case *FunctionCondition:
+		// The comparison's field is "", the first "" written for it, e.g. "" = 'x' or has("")
+		return strings.Replace(formatCondition(c.condition), `""`, formatFunction(c.function), 1)
+	}
+	return ""
+}
+
+// formatOperand writes an operand of a boolean operator, in parentheses if it binds less
+// tightly than the operator. Right operands of the same operator are grouped too, since
+// chains of AND and OR compile to left-nested conditions
+func formatOperand(parent, operand Condition, right bool) string {
+	if sqlPrecedence(operand) > sqlPrecedence(parent) || sqlPrecedence(operand) == sqlPrecedence(parent) && !right {
+		return formatCondition(operand)
+	}
+	return "(" + formatCondition(operand) + ")"
+}
+
+// formatValues writes a list of values
+func formatValues(values []Literal) string {
+	texts := make([]string, len(values))
+	for i, value := range values {
+		texts[i] = value.String()
+	}
+	return strings.Join(texts, ", ")
+}
+
+// canonicalLiteral returns a literal with numbers in canonical form
+func canonicalLiteral(value Literal) Literal {
+	if value.kind == NumberLiteral {
+		value.text = canonicalNumber(value.text)
+	}
+	return value
+}
+
+// canonicalFunction returns a copy of a function with its literal arguments in canonical form
+func canonicalFunction(function *Function) *Function {
+	canonical := &Function{name: function.name, args: slices.Clone(function.args), result: function.result}
+	for i, arg := range canonical.args {
+		switch {
+		case arg.function != nil:
+			canonical.args[i].function = canonicalFunction(arg.function)
+		case arg.value != nil:
+			value := canonicalLiteral(*arg.value)
+			canonical.args[i].value = &value
+		}
+	}
+	return canonical
+}
+
+// canonicalNumber writes a decimal number in its shortest form, so that numbers with the
+// same value such as 1.50 and 1.5, or 1e2 and 100, are written the same. Numbers are written
+// with an exponent when they'd need more than 21 digits before the point or 5 zeros after it,
+// like 1.5e30. Other numbers, such as hexadecimal ones, are written as they are
+func canonicalNumber(text string) string {
+	mantissa, exponentText, hasExponent := strings.Cut(strings.ToLower(text), "e")
+	exponent := 0
+	if hasExponent {
+		// Longer exponents are left alone rather than risk overflowing
+		var err error
+		if exponent, err = strconv.Atoi(exponentText); err != nil || len(exponentText) > 9 {
+			return text
+		}
+	}
+
+	negative := strings.HasPrefix(mantissa, "-")
+	mantissa = strings.TrimLeft(mantissa, "+-")
+	whole, fraction, _ := strings.Cut(mantissa, ".")
+	digits := whole + fraction
+	if digits == "" || strings.Trim(digits, "0123456789") != "" {
+		return text
+	}
+
+	// The value is digits × 10^exponent, with no leading or trailing zeros in digits
+	exponent -= len(fraction)
+	digits = strings.TrimLeft(digits, "0")
+	if digits == "" {
+		return "0"
+	}
+	trimmed := strings.TrimRight(digits, "0")
+	exponent += len(digits) - len(trimmed)
+	digits = trimmed
+
+	sign := ""
+	if negative {
+		sign = "-"
+	}
+	point := len(digits) + exponent
+	switch {
+	case exponent >= 0 && point <= 21:
+		return sign + digits + strings.Repeat("0", exponent)
+	case exponent < 0 && point > 0:
+		return sign + digits[:point] + "." + digits[point:]
+	case exponent < 0 && point > -6:
+		return sign + "0." + strings.Repeat("0", -point) + digits
+	}
+	if len(digits) > 1 {
+		digits = digits[:1] + "." + digits[1:]
+	}
+	return sign + digits + "e" + strconv.Itoa(point-1)
+}
+
+// canonicalField writes a field path with each part double-quoted, e.g. "Department"."Name".
+// Names have at most four dot-separated parts, so any further parts are written as
+// subscripts, and the empty path that refers to the item itself is written as ""
+func canonicalField(field string) string {
+	parts := SplitFieldPath(field)
+	if len(parts) == 0 {
+		return `""`
+	}
+
+	var b strings.Builder
+	for i, part := range parts {
+		switch {
+		case i == 0:
+		case i < 4:
+			b.WriteByte('.')
+		default:
+			b.WriteString("['" + strings.ReplaceAll(part, "'", "''") + "']")
+			continue
+		}
+		b.WriteString(`"` + strings.ReplaceAll(part, `"`, `""`) + `"`)
+	}
+	return b.String()
+}
+
+// NormalizeQuery returns a copy of the query whose field names are spelled like the struct
+// fields of T they resolve to, so that filters differing only in the case of their field
+// names have the same canonical form. Fields that don't resolve to a struct field, such as
+// map keys, are left unchanged
+func NormalizeQuery[T any](query *Query) *Query {
+	if query.condition == nil {
+		return &Query{}
+	}
+	return &Query{condition: normalizeCondition(query.condition, reflect.TypeFor[T]())}
+}
+
+// normalizeCondition returns a copy of a condition with its fields resolved against a type
+func normalizeCondition(condition Condition, typ reflect.Type) Condition {
+	switch c := condition.(type) {
+	case *AndCondition:
+		return &AndCondition{left: normalizeCondition(c.left, typ), right: normalizeCondition(c.right, typ)}
+	case *OrCondition:
+		return &OrCondition{left: normalizeCondition(c.left, typ), right: normalizeCondition(c.right, typ)}
+	case *NotCondition:
+		return &NotCondition{condition: normalizeCondition(c.condition, typ)}
+	case *EqualCondition:
+		return &EqualCondition{field: normalizeField(c.field, typ), value: c.value}
+	case *NotEqualCondition:
+		return &NotEqualCondition{field: normalizeField(c.field, typ), value: c.value}
+	case *ComparisonCondition:
+		return &ComparisonCondition{field: normalizeField(c.field, typ), value: c.value, operator: c.operator}
+	case *LikeCondition:
+		return newLikeCondition(normalizeField(c.field, typ), c.value, c.caseInsensitive)
+	case *RegexCondition:
+		return &RegexCondition{field: normalizeField(c.field, typ), value: c.value, caseInsensitive: c.caseInsensitive, regexp: c.regexp}
+	case *HasCondition:
+		return &HasCondition{field: normalizeField(c.field, typ)}
+	case *AnyArrayContainsCondition:
+		return &AnyArrayContainsCondition{field: normalizeField(c.field, typ), value: c.value}
+	case *AnyArrayNotContainsCondition:
+		return &AnyArrayNotContainsCondition{field: normalizeField(c.field, typ), value: c.value}
+	case *AnyArrayContainsAnyCondition:
+		return &AnyArrayContainsAnyCondition{field: normalizeField(c.field, typ), values: c.values}
+	case *AnyArrayNotContainsAnyCondition:
+		return &AnyArrayNotContainsAnyCondition{field: normalizeField(c.field, typ), values: c.values}
+	case *QuantifierCondition:
+		// The predicate's fields are resolved against the elements of the quantified field
+		field, fieldType := resolveFieldType(c.field, typ)
+		var elemType reflect.Type
+		if fieldType = indirectType(fieldType); fieldType != nil && (fieldType.Kind() == reflect.Slice || fieldType.Kind() == reflect.Array) {
+			elemType = fieldType.Elem()
+		}
+		return &QuantifierCondition{field: field, condition: normalizeCondition(c.condition, elemType), all: c.all}
+	case *FunctionCondition:
+		return &FunctionCondition{function: normalizeFunction(c.function, typ), condition: c.condition}
+	}
+	return condition
+}
+
+// normalizeFunction returns a copy of a function with the fields of its arguments resolved
+// against a type
+func normalizeFunction(function *Function, typ reflect.Type) *Function {
+	normalized := &Function{name: function.name, args: slices.Clone(function.args), result: function.result}
+	for i, arg := range normalized.args {
+		switch {
+		case arg.function != nil:
+			normalized.args[i].function = normalizeFunction(arg.function, typ)
+		case arg.value == nil:
+			normalized.args[i].field = normalizeField(arg.field, typ)
+		}
+	}
+	return normalized
+}
+
+// normalizeField spells a field like the struct fields it resolves to
+func normalizeField(field string, typ reflect.Type) string {
+	field, _ = resolveFieldType(field, typ)
+	return field
+}
+
+// resolveFieldType resolves a field path against a type like resolveField does against a
+// value, returning the path spelled with the struct field names and the type of the field,
+// or nil if some part could not be resolved
+func resolveFieldType(field string, typ reflect.Type) (string, reflect.Type) {
+	parts := SplitFieldPath(field)
+	for i, part := range parts {
+		typ = indirectType(typ)
+		if typ == nil {
+			return formatFieldPath(parts), nil
+		}
+
+		switch typ.Kind() {
+		case reflect.Struct:
+			structField, ok := typ.FieldByName(part)
+			if !ok {
+				index := slices.IndexFunc(reflect.VisibleFields(typ), func(f reflect.StructField) bool {
+					return len(f.Index) == 1 && strings.EqualFold(f.Name, part)
+				})
+				if index < 0 {
+					return formatFieldPath(parts), nil
+				}
+				structField = reflect.VisibleFields(typ)[index]
+			}
+			parts[i] = structField.Name
+			typ = structField.Type
+		case reflect.Map:
+			typ = typ.Elem()
+		default:
+			return formatFieldPath(parts), nil
+		}
+	}
+	return formatFieldPath(parts), typ
+}
+
+// indirectType returns the type a pointer type points to, or nil for interfaces whose
+// dynamic type is unknown
+func indirectType(typ reflect.Type) reflect.Type {
+	for typ != nil && typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	if typ != nil && typ.Kind() == reflect.Interface {
+		return nil
+	}
+	return typ
+}
@@ -0,0 +1,190 @@
+// Copyright 2023 Daniel Valdivia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rql
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestQueryString(t *testing.T) {
+	tests := []struct {
+		filter string
+		want   string
+	}{
+		{filter: ``, want: ``},
+		{filter: `Age>30 and name='x'`, want: `"age" > 30 AND "name" = 'x'`},
+		{filter: `NAME = 'x' AND age > 30`, want: `"age" > 30 AND "name" = 'x'`},
+		{filter: `(b = 2 AND a = 1) AND (c = 3 AND a = 1)`, want: `"a" = 1 AND "b" = 2 AND "c" = 3`},
+		{filter: `x = 1 OR (y = 2 AND (z = 3 OR w = 4))`, want: `"x" = 1 OR "y" = 2 AND ("w" = 4 OR "z" = 3)`},
+		{filter: `(e = '' OR e = '') AND a = 0`, want: `"a" = 0 AND "e" = ''`},
+		{filter: `c = 3 AND (b = 2 AND d = 4 OR d = 4 AND b = 2)`, want: `"b" = 2 AND "c" = 3 AND "d" = 4`},
+		{filter: `NOT (b = 1 OR a = 1) AND NOT c = 1`, want: `NOT "c" = 1 AND NOT ("a" = 1 OR "b" = 1)`},
+		{filter: `name <> 'O''Brien' OR "Name" LIKE 'a%' OR name ilike 'B%'`, want: `"Name" LIKE 'a%' OR "name" != 'O''Brien' OR "name" ILIKE 'B%'`},
+		{filter: `Status IN ('b', 'a', 'b')`, want: `"status" = 'a' OR "status" = 'b'`},
+		{filter: `Age NOT IN (30, 25)`, want: `"age" != 25 AND "age" != 30`},
+		{filter: `active = TRUE AND score <= 4.50 AND code = '7'`, want: `"active" = true AND "code" = '7' AND "score" <= 4.5`},
+		{filter: `a = 1.50 OR a = 1.5 OR b = 1E2 OR b = 100.0 OR c = -0.0`, want: `"a" = 1.5 OR "b" = 100 OR "c" = 0`},
+		{filter: `a = .5 OR b = 007 OR c = -12.3400e1 OR d = 0x1F`, want: `"a" = 0.5 OR "b" = 7 OR "c" = -123.4 OR "d" = 0x1F`},
+		{filter: `a = 1e20 OR b = 1e21 OR c = 0.000001 OR d = 25e-8 OR e = 1.5e30`, want: `"a" = 100000000000000000000 OR "b" = 1e21 OR "c" = 0.000001 OR "d" = 2.5e-7 OR "e" = 1.5e30`},
+		{filter: `ANY(Scores) = ANY(2.0, 2, 1e0)`, want: `ANY("scores") = ANY(1, 2)`},
+		{filter: `coalesce(age, 1.50) = 25 AND length(name) = 5.0`, want: `coalesce("age", 1.5) = 25 AND length("name") = 5`},
+		{filter: `Labels ? 'env' OR has(Labels['app.kubernetes.io/name'])`, want: `has("labels"."app.kubernetes.io/name") OR has("labels"."env")`},
+		{filter: `'go' = ANY(Tags) AND ANY(Tags) != 'js'`, want: `ANY("tags") != 'js' AND ANY("tags") = 'go'`},
+		{filter: `ANY(Tags) = ANY('go', 'c', 'go') AND ANY(Tags) != ANY(2, 1)`, want: `ANY("tags") != ANY(1, 2) AND ANY("tags") = ANY('c', 'go')`},
+		{filter: `all(Orders, Total > 5 OR Shipped = true) AND ANY(Orders, has("") OR x = 1)`, want: `ALL("orders", "shipped" = true OR "total" > 5) AND ANY("orders", "x" = 1 OR has(""))`},
+		{filter: `a.b.c.d['e''s'] = 1`, want: `"a"."b"."c"."d"['e''s'] = 1`},
+		{filter: `"we""ird" = 1 AND true`, want: `"we""ird" = 1 AND true`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			query, err := Compile(tt.filter)
+			if err != nil {
+				t.Fatalf("Compile() unexpected error = %v", err)
+			}
+			got := query.String()
+			if got != tt.want {
+				t.Errorf("String() = %s, want %s", got, tt.want)
+			}
+
+			// The canonical form compiles to an equivalent query with the same canonical form
+			reparsed, err := Compile(got)
+			if err != nil {
+				t.Fatalf("Compile(%q) unexpected error = %v", got, err)
+			}
+			if reparsed.String() != got {
+				t.Errorf("String() of the canonical form = %s, want %s", reparsed.String(), got)
+			}
+			if want, got := ApplyQuery(query, testPeople), ApplyQuery(reparsed, testPeople); !reflect.DeepEqual(got, want) {
+				t.Errorf("canonical form matched %v, want %v", got.Items, want.Items)
+			}
+		})
+	}
+}
+
+func TestQueryHash(t *testing.T) {
+	first, err := Compile(`Age>30 and name='x'`)
+	if err != nil {
+		t.Fatalf("Compile() unexpected error = %v", err)
+	}
+	second, err := Compile(`NAME = 'x' AND age > 30`)
+	if err != nil {
+		t.Fatalf("Compile() unexpected error = %v", err)
+	}
+	third, err := Compile(`NAME = 'y' AND age > 30`)
+	if err != nil {
+		t.Fatalf("Compile() unexpected error = %v", err)
+	}
+
+	// The hash must not change between releases, or caches keyed by it would be invalidated
+	const want = "96a66907357ff74add83dc6c49d04818028b7b7d2ef888c4da232b0f9503c117"
+	if got := first.Hash(); got != want {
+		t.Errorf("Hash() = %s, want %s", got, want)
+	}
+	if first.Hash() != second.Hash() {
+		t.Errorf("Hash() differs for equivalent filters: %s and %s", first.Hash(), second.Hash())
+	}
+	if first.Hash() == third.Hash() {
+		t.Errorf("Hash() is the same for different filters")
+	}
+
+	// Numbers with the same value hash the same however they're written
+	decimal, err := Compile(`score = 1.50 AND rank = 1e2`)
+	if err != nil {
+		t.Fatalf("Compile() unexpected error = %v", err)
+	}
+	short, err := Compile(`score = 1.5 AND rank = 100`)
+	if err != nil {
+		t.Fatalf("Compile() unexpected error = %v", err)
+	}
+	if decimal.Hash() != short.Hash() {
+		t.Errorf("Hash() differs for equal numbers: %s and %s", decimal.Hash(), short.Hash())
+	}
+}
+
+func TestNormalizeQuery(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  func() (*Query, error)
+		want   string
+		normal func(*Query) *Query
+	}{
+		{
+			name:   "struct fields",
+			query:  func() (*Query, error) { return Compile(`age > 30 AND DEPARTMENT.name = 'x' AND ANY(tags) = 'go'`) },
+			want:   `"Age" > 30 AND "Department"."Name" = 'x' AND ANY("Tags") = 'go'`,
+			normal: NormalizeQuery[TestPerson],
+		},
+		{
+			name: "other syntaxes resolve to the same fields",
+			query: func() (*Query, error) {
+				return CompileOData(`department/NAME eq 'x' and AGE gt 30 and tags/any(t: t eq 'go')`)
+			},
+			want:   `"Age" > 30 AND "Department"."Name" = 'x' AND ANY("Tags") = 'go'`,
+			normal: NormalizeQuery[TestPerson],
+		},
+		{
+			name:   "quantifier predicates resolve against the elements",
+			query:  func() (*Query, error) { return Compile(`ANY(orders, status = 'paid' AND ALL(items, sku = 'A'))`) },
+			want:   `ANY("Orders", "Status" = 'paid' AND ALL("Items", "SKU" = 'A'))`,
+			normal: NormalizeQuery[*TestCustomer],
+		},
+		{
+			name:   "unknown fields and map keys are unchanged",
+			query:  func() (*Query, error) { return Compile(`missing = 1 AND labels.env = 'x' AND "Labels"."Env" = 'y'`) },
+			want:   `"Labels"."Env" = 'y' AND "labels"."env" = 'x' AND "missing" = 1`,
+			normal: NormalizeQuery[map[string]any],
+		},
+		{
+			name:   "map values of struct type",
+			query:  func() (*Query, error) { return Compile(`teams.core.location = 'x'`) },
+			want:   `"Teams"."core"."Location" = 'x'`,
+			normal: NormalizeQuery[struct{ Teams map[string]Department }],
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := tt.query()
+			if err != nil {
+				t.Fatalf("compile unexpected error = %v", err)
+			}
+			if got := tt.normal(query).String(); got != tt.want {
+				t.Errorf("NormalizeQuery().String() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryStringElementPredicates(t *testing.T) {
+	query, err := CompileOData(`Scores/all(s: s ge 85) and Tags/any(t: startswith(t, 'java'))`)
+	if err != nil {
+		t.Fatalf("CompileOData() unexpected error = %v", err)
+	}
+
+	want := `ALL("Scores", "" >= 85) AND ANY("Tags", "" LIKE 'java%')`
+	if got := query.String(); got != want {
+		t.Fatalf("String() = %s, want %s", got, want)
+	}
+
+	reparsed, err := Compile(want)
+	if err != nil {
+		t.Fatalf("Compile() unexpected error = %v", err)
+	}
+	if got := ApplyQuery(reparsed, testPeople); got.Count != 1 || got.Items[0].Name != "Alice" {
+		t.Errorf("canonical form matched %v, want Alice", got.Items)
+	}
+}
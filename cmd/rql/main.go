@@ -0,0 +1,420 @@
+// Copyright 2023 Daniel Valdivia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command rql filters JSON, JSONL and CSV records using the RQL filter syntax.
+//
+// Usage:
+//
+//	rql [flags] [file ...]
+//
+// Records are read from the given files, or from stdin when no file is given.
+// The input format is taken from --format, or guessed from each file extension.
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dvaldivia/rql"
+)
+
+// Supported input and output formats
+const (
+	formatJSON  = "json"
+	formatJSONL = "jsonl"
+	formatCSV   = "csv"
+)
+
+// record is a single decoded input row
+type record = map[string]any
+
+// config holds the parsed command-line flags
+type config struct {
+	filter string
+	sort   string
+	limit  int
+	offset int
+	fields string
+	format string
+	files  []string
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, "rql:", err)
+		os.Exit(1)
+	}
+}
+
+// run executes the command with the given arguments and streams
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	cfg, err := parseFlags(args, stderr)
+	if err != nil {
+		return err
+	}
+
+	records, format, err := readInputs(cfg, stdin)
+	if err != nil {
+		return err
+	}
+
+	result, err := rql.ApplyFilter(cfg.filter, records, rql.FilterOptions{
+		Limit:  cfg.limit,
+		Offset: cfg.offset,
+		Sort:   splitList(cfg.sort),
+	})
+	if err != nil {
+		return fmt.Errorf("invalid filter: %w", err)
+	}
+
+	fields := splitList(cfg.fields)
+	items := result.Items
+	if len(fields) > 0 {
+		items = make([]record, len(result.Items))
+		for i, item := range result.Items {
+			items[i] = project(item, fields)
+		}
+	}
+
+	switch format {
+	case formatJSON:
+		return writeJSON(stdout, items, result.Count)
+	case formatJSONL:
+		err = writeJSONL(stdout, items)
+	case formatCSV:
+		err = writeCSV(stdout, items, fields)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Line-oriented formats have nowhere to put the count, so report it separately
+	fmt.Fprintf(stderr, "count: %d\n", result.Count)
+	return nil
+}
+
+// parseFlags parses the command-line arguments into a config
+func parseFlags(args []string, output io.Writer) (config, error) {
+	var cfg config
+
+	flags := flag.NewFlagSet("rql", flag.ContinueOnError)
+	flags.SetOutput(output)
+	flags.StringVar(&cfg.filter, "filter", "", "RQL filter expression, e.g. \"Age >= 30 AND Active = true\"")
+	flags.StringVar(&cfg.sort, "sort", "", "comma-separated fields to sort by, prefix a field with - for descending order")
+	flags.IntVar(&cfg.limit, "limit", 0, "maximum number of records to output, 0 means no limit")
+	flags.IntVar(&cfg.offset, "offset", 0, "number of matching records to skip")
+	flags.StringVar(&cfg.fields, "select", "", "comma-separated fields to output, defaults to all fields")
+	flags.StringVar(&cfg.format, "format", "", "input and output format: json, jsonl or csv (guessed from the file extension by default)")
+	flags.Usage = func() {
+		fmt.Fprintln(output, "Usage: rql [flags] [file ...]")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return config{}, err
+	}
+	cfg.files = flags.Args()
+
+	if cfg.format != "" && !isFormat(cfg.format) {
+		return config{}, fmt.Errorf("unknown format %q, expected json, jsonl or csv", cfg.format)
+	}
+	if cfg.limit < 0 || cfg.offset < 0 {
+		return config{}, errors.New("limit and offset must not be negative")
+	}
+
+	return cfg, nil
+}
+
+// readInputs reads all records from the configured files, or stdin if there are none.
+// It returns the output format, which is the format of the first input unless overridden
+func readInputs(cfg config, stdin io.Reader) ([]record, string, error) {
+	if len(cfg.files) == 0 {
+		format := cfg.format
+		if format == "" {
+			format = formatJSON
+		}
+		records, err := readRecords(stdin, format)
+		return records, format, err
+	}
+
+	var records []record
+	outputFormat := cfg.format
+	for _, name := range cfg.files {
+		format := cfg.format
+		if format == "" {
+			format = formatFromExtension(name)
+		}
+		if outputFormat == "" {
+			outputFormat = format
+		}
+
+		fileRecords, err := readFile(name, format)
+		if err != nil {
+			return nil, "", err
+		}
+		records = append(records, fileRecords...)
+	}
+
+	return records, outputFormat, nil
+}
+
+// readFile reads all records from a single file
+func readFile(name, format string) ([]record, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	records, err := readRecords(f, format)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return records, nil
+}
+
+// readRecords decodes records in the given format
+func readRecords(r io.Reader, format string) ([]record, error) {
+	switch format {
+	case formatJSONL:
+		return readJSONL(r)
+	case formatCSV:
+		return readCSV(r)
+	default:
+		return readJSON(r)
+	}
+}
+
+// readJSON reads either a JSON array of objects or a stream of JSON objects. Numbers decode as
+// json.Number so integers beyond 2^53 survive the round trip
+func readJSON(r io.Reader) ([]record, error) {
+	var records []record
+
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	for {
+		var value any
+		if err := decoder.Decode(&value); err == io.EOF {
+			return records, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch v := value.(type) {
+		case []any:
+			for _, elem := range v {
+				rec, ok := elem.(record)
+				if !ok {
+					return nil, fmt.Errorf("expected an array of objects, found %T", elem)
+				}
+				records = append(records, rec)
+			}
+		case record:
+			records = append(records, v)
+		default:
+			return nil, fmt.Errorf("expected an object or an array of objects, found %T", value)
+		}
+	}
+}
+
+// readJSONL reads one JSON object per line, skipping blank lines. Numbers decode as json.Number
+// like readJSON
+func readJSONL(r io.Reader) ([]record, error) {
+	var records []record
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var rec record
+		decoder := json.NewDecoder(strings.NewReader(text))
+		decoder.UseNumber()
+		if err := decoder.Decode(&rec); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		records = append(records, rec)
+	}
+
+	return records, scanner.Err()
+}
+
+// readCSV reads a CSV file whose first row holds the column names, typing each column
+// so numeric, boolean and time comparisons behave as expected
+func readCSV(r io.Reader) ([]record, error) {
+	table, err := rql.ReadCSV(r)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]record, 0, len(table.Rows))
+	for _, row := range table.Rows {
+		records = append(records, row.TypedMap())
+	}
+
+	return records, nil
+}
+
+// writeJSON writes the matching records together with the total count
+func writeJSON(w io.Writer, items []record, count int) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(struct {
+		Count int      `json:"count"`
+		Items []record `json:"items"`
+	}{
+		Count: count,
+		Items: items,
+	})
+}
+
+// writeJSONL writes one record per line
+func writeJSONL(w io.Writer, items []record) error {
+	encoder := json.NewEncoder(w)
+	for _, item := range items {
+		if err := encoder.Encode(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeCSV writes the records as CSV, using the selected fields or every key found as columns
+func writeCSV(w io.Writer, items []record, fields []string) error {
+	columns := fields
+	if len(columns) == 0 {
+		for _, item := range items {
+			for key := range item {
+				if !slices.Contains(columns, key) {
+					columns = append(columns, key)
+				}
+			}
+		}
+		slices.Sort(columns)
+	}
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(columns); err != nil {
+		return err
+	}
+	for _, item := range items {
+		row := make([]string, len(columns))
+		for i, column := range columns {
+			row[i] = formatCSVValue(item[column])
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// formatCSVValue renders a value as a CSV cell, encoding nested values as JSON
+func formatCSVValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case map[string]any, []any:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprintf("%v", v)
+		}
+		return string(encoded)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// project returns a record containing only the selected fields, resolving dotted paths
+func project(item record, fields []string) record {
+	projected := make(record, len(fields))
+	for _, field := range fields {
+		if value, ok := lookup(item, field); ok {
+			projected[field] = value
+		}
+	}
+	return projected
+}
+
+// lookup resolves a dotted path through nested objects, matching keys case-insensitively
+func lookup(item record, path string) (any, bool) {
+	var current any = item
+	for _, part := range strings.Split(path, ".") {
+		obj, ok := current.(record)
+		if !ok {
+			return nil, false
+		}
+
+		value, found := obj[part]
+		if !found {
+			for key, v := range obj {
+				if strings.EqualFold(key, part) {
+					value, found = v, true
+					break
+				}
+			}
+		}
+		if !found {
+			return nil, false
+		}
+		current = value
+	}
+	return current, true
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries
+func splitList(value string) []string {
+	var list []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
+
+// formatFromExtension guesses the input format from a file name
+func formatFromExtension(name string) string {
+	switch ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(name)), "."); ext {
+	case formatJSONL, "ndjson":
+		return formatJSONL
+	case formatCSV:
+		return formatCSV
+	default:
+		return formatJSON
+	}
+}
+
+// isFormat reports whether the name is a supported format
+func isFormat(name string) bool {
+	return name == formatJSON || name == formatJSONL || name == formatCSV
+}
@@ -0,0 +1,201 @@
+// Copyright 2023 Daniel Valdivia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const peopleJSON = `[
+  {"id": 1, "name": "Alice", "age": 25, "active": true, "department": {"name": "Engineering"}, "tags": ["frontend", "react"]},
+  {"id": 2, "name": "Bob", "age": 30, "active": true, "department": {"name": "Engineering"}, "tags": ["backend", "go"]},
+  {"id": 3, "name": "Charlie", "age": 35, "active": false, "department": {"name": "Marketing"}, "tags": ["content"]},
+  {"id": 4, "name": "Dave", "age": 40, "active": true, "department": {"name": "Sales"}, "tags": []}
+]`
+
+const peopleCSV = `id,name,age,active
+1,Alice,25,true
+2,Bob,30,true
+3,Charlie,35,false
+4,Dave,40,true
+`
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		stdin      string
+		wantStdout string
+		wantStderr string
+		wantErr    bool
+	}{
+		{
+			name:  "json filter with count",
+			args:  []string{"--filter", "Age >= 30 AND Active = true", "--select", "name"},
+			stdin: peopleJSON,
+			wantStdout: `{
+  "count": 2,
+  "items": [
+    {
+      "name": "Bob"
+    },
+    {
+      "name": "Dave"
+    }
+  ]
+}
+`,
+		},
+		{
+			name:       "jsonl sort limit and offset",
+			args:       []string{"--format", "jsonl", "--sort", "-age", "--limit", "2", "--offset", "1", "--select", "id"},
+			stdin:      "{\"id\": 1, \"age\": 25}\n{\"id\": 2, \"age\": 30}\n\n{\"id\": 3, \"age\": 35}\n",
+			wantStdout: "{\"id\":2}\n{\"id\":1}\n",
+			wantStderr: "count: 3\n",
+		},
+		{
+			name:       "nested fields",
+			args:       []string{"--filter", "Department.Name = 'Engineering'", "--select", "id,department.name", "--format", "jsonl"},
+			stdin:      strings.ReplaceAll(strings.Trim(peopleJSON, "[]\n"), "},\n", "}\n"),
+			wantStdout: "{\"department.name\":\"Engineering\",\"id\":1}\n{\"department.name\":\"Engineering\",\"id\":2}\n",
+			wantStderr: "count: 2\n",
+		},
+		{
+			name:       "array fields",
+			args:       []string{"--filter", "ANY(Tags) = 'go'", "--select", "name", "--format", "jsonl"},
+			stdin:      strings.ReplaceAll(strings.Trim(peopleJSON, "[]\n"), "},\n", "}\n"),
+			wantStdout: "{\"name\":\"Bob\"}\n",
+			wantStderr: "count: 1\n",
+		},
+		{
+			name:       "jsonl integers above 2^53",
+			args:       []string{"--filter", "id = 9007199254740993", "--format", "jsonl"},
+			stdin:      "{\"id\": 9007199254740992}\n{\"id\": 9007199254740993}\n",
+			wantStdout: "{\"id\":9007199254740993}\n",
+			wantStderr: "count: 1\n",
+		},
+		{
+			name:  "json integers above 2^53",
+			args:  []string{"--filter", "id > 9007199254740992"},
+			stdin: `[{"id": 9007199254740992}, {"id": 9007199254740993, "ratio": 0.5}]`,
+			wantStdout: `{
+  "count": 1,
+  "items": [
+    {
+      "id": 9007199254740993,
+      "ratio": 0.5
+    }
+  ]
+}
+`,
+		},
+		{
+			name:       "csv",
+			args:       []string{"--format", "csv", "--filter", "age > 28", "--sort", "name"},
+			stdin:      peopleCSV,
+			wantStdout: "active,age,id,name\ntrue,30,2,Bob\nfalse,35,3,Charlie\ntrue,40,4,Dave\n",
+			wantStderr: "count: 3\n",
+		},
+		{
+			name:       "csv select",
+			args:       []string{"--format", "csv", "--filter", "active = false", "--select", "name,age"},
+			stdin:      peopleCSV,
+			wantStdout: "name,age\nCharlie,35\n",
+			wantStderr: "count: 1\n",
+		},
+		{
+			name:    "invalid filter",
+			args:    []string{"--filter", "quack?"},
+			stdin:   peopleJSON,
+			wantErr: true,
+		},
+		{
+			name:    "unknown format",
+			args:    []string{"--format", "xml"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid json",
+			args:    []string{"--format", "json"},
+			stdin:   `[1, 2]`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			err := run(tt.args, strings.NewReader(tt.stdin), &stdout, &stderr)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if stdout.String() != tt.wantStdout {
+				t.Errorf("run() stdout = %q, want %q", stdout.String(), tt.wantStdout)
+			}
+			if stderr.String() != tt.wantStderr {
+				t.Errorf("run() stderr = %q, want %q", stderr.String(), tt.wantStderr)
+			}
+		})
+	}
+}
+
+func TestRunFiles(t *testing.T) {
+	dir := t.TempDir()
+	jsonFile := filepath.Join(dir, "people.json")
+	csvFile := filepath.Join(dir, "more.csv")
+	if err := os.WriteFile(jsonFile, []byte(peopleJSON), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(csvFile, []byte("id,name\n5,Eve\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	err := run([]string{"--filter", "Name LIKE '%e'", "--select", "name", jsonFile, csvFile}, nil, &stdout, &stderr)
+	if err != nil {
+		t.Fatalf("run() unexpected error = %v", err)
+	}
+
+	want := `{
+  "count": 4,
+  "items": [
+    {
+      "name": "Alice"
+    },
+    {
+      "name": "Charlie"
+    },
+    {
+      "name": "Dave"
+    },
+    {
+      "name": "Eve"
+    }
+  ]
+}
+`
+	if stdout.String() != want {
+		t.Errorf("run() stdout = %q, want %q", stdout.String(), want)
+	}
+}
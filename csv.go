@@ -0,0 +1,236 @@
+// Copyright 2023 Daniel Valdivia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rql
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ColumnType is the type a CSV column is converted to before filtering
+type ColumnType int
+
+const (
+	ColumnString ColumnType = iota // Values are kept as strings
+	ColumnInt                      // Values are parsed as 64-bit integers
+	ColumnFloat                    // Values are parsed as 64-bit floats
+	ColumnBool                     // Values are parsed with strconv.ParseBool
+	ColumnTime                     // Values are parsed with the configured time layout
+)
+
+// String returns the name of the column type
+func (t ColumnType) String() string {
+	switch t {
+	case ColumnInt:
+		return "int"
+	case ColumnFloat:
+		return "float"
+	case ColumnBool:
+		return "bool"
+	case ColumnTime:
+		return "time"
+	default:
+		return "string"
+	}
+}
+
+// CSVOptions contains configuration options for reading CSV input
+type CSVOptions struct {
+	Types            map[string]ColumnType // Declared column types keyed by header name, matched case-insensitively
+	DisableInference bool                  // Treat undeclared columns as strings instead of inferring their type
+	TimeLayout       string                // Layout for time columns, defaults to the layouts accepted in filters
+	Comma            rune                  // Field delimiter, defaults to ','
+}
+
+// CSVTable holds CSV rows whose columns can be referenced by header name in filters
+type CSVTable struct {
+	Header []string     // Column names from the first row
+	Types  []ColumnType // Type of each column, in header order
+	Rows   []CSVRow     // Data rows, ready to be passed to ApplyFilter
+}
+
+// CSVRow is a single CSV record. Filters see its typed values, while the
+// original cells remain available for output
+type CSVRow struct {
+	Values []string // The raw cells as read from the input
+
+	header []string
+	typed  []any
+}
+
+// ReadCSV reads CSV input whose first row holds the column names. Each column is
+// converted to its declared or inferred type so that comparisons in filters behave
+// as they would for a struct field of that type
+func ReadCSV(r io.Reader, options ...CSVOptions) (*CSVTable, error) {
+	var opts CSVOptions
+	if len(options) > 0 {
+		opts = options[0]
+	}
+
+	reader := csv.NewReader(r)
+	if opts.Comma != 0 {
+		reader.Comma = opts.Comma
+	}
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return &CSVTable{}, nil
+	}
+
+	header := records[0]
+	records = records[1:]
+
+	types := make([]ColumnType, len(header))
+	for i, column := range header {
+		if declared, ok := lookupColumnType(opts.Types, column); ok {
+			types[i] = declared
+		} else if !opts.DisableInference {
+			types[i] = inferColumnType(records, i, opts.TimeLayout)
+		}
+	}
+
+	table := &CSVTable{
+		Header: header,
+		Types:  types,
+		Rows:   make([]CSVRow, 0, len(records)),
+	}
+	for line, record := range records {
+		row := CSVRow{Values: record, header: header, typed: make([]any, len(record))}
+		for i, cell := range record {
+			row.typed[i], err = convertCell(cell, types[i], opts.TimeLayout)
+			if err != nil {
+				// Line numbers are 1-based and the header is line 1
+				return nil, fmt.Errorf("line %d, column %q: %w", line+2, header[i], err)
+			}
+		}
+		table.Rows = append(table.Rows, row)
+	}
+
+	return table, nil
+}
+
+// Map returns the row's raw cells keyed by column name
+func (r CSVRow) Map() map[string]string {
+	m := make(map[string]string, len(r.header))
+	for i, column := range r.header {
+		m[column] = r.Values[i]
+	}
+	return m
+}
+
+// TypedMap returns the row's typed values keyed by column name. Empty cells in
+// non-string columns are nil
+func (r CSVRow) TypedMap() map[string]any {
+	m := make(map[string]any, len(r.header))
+	for i, column := range r.header {
+		m[column] = r.typed[i]
+	}
+	return m
+}
+
+// fieldValue resolves a column by name for filtering, matching case-insensitively
+func (r CSVRow) fieldValue(name string) (any, bool) {
+	for i, column := range r.header {
+		if column == name {
+			return r.typed[i], true
+		}
+	}
+	for i, column := range r.header {
+		if strings.EqualFold(column, name) {
+			return r.typed[i], true
+		}
+	}
+	return nil, false
+}
+
+// lookupColumnType finds a declared column type, matching the column name case-insensitively
+func lookupColumnType(types map[string]ColumnType, column string) (ColumnType, bool) {
+	if t, ok := types[column]; ok {
+		return t, true
+	}
+	for name, t := range types {
+		if strings.EqualFold(name, column) {
+			return t, true
+		}
+	}
+	return ColumnString, false
+}
+
+// inferColumnType picks the narrowest type that every non-empty cell in a column converts to
+func inferColumnType(records [][]string, column int, timeLayout string) ColumnType {
+	candidates := []ColumnType{ColumnInt, ColumnFloat, ColumnBool, ColumnTime}
+	seen := false
+
+	for _, record := range records {
+		cell := record[column]
+		if cell == "" {
+			continue
+		}
+		seen = true
+
+		remaining := candidates[:0]
+		for _, candidate := range candidates {
+			if _, err := convertCell(cell, candidate, timeLayout); err == nil {
+				remaining = append(remaining, candidate)
+			}
+		}
+		candidates = remaining
+		if len(candidates) == 0 {
+			return ColumnString
+		}
+	}
+
+	if !seen {
+		return ColumnString
+	}
+	return candidates[0]
+}
+
+// convertCell converts a single cell to the given column type. Empty cells in
+// non-string columns convert to nil so they behave like missing fields
+func convertCell(cell string, columnType ColumnType, timeLayout string) (any, error) {
+	if columnType == ColumnString {
+		return cell, nil
+	}
+	if cell == "" {
+		return nil, nil
+	}
+
+	switch columnType {
+	case ColumnInt:
+		return strconv.ParseInt(cell, 10, 64)
+	case ColumnFloat:
+		return strconv.ParseFloat(cell, 64)
+	case ColumnBool:
+		return strconv.ParseBool(cell)
+	case ColumnTime:
+		if timeLayout != "" {
+			return time.Parse(timeLayout, cell)
+		}
+		if t, ok := parseTime(cell); ok {
+			return t, nil
+		}
+		return nil, fmt.Errorf("cannot parse %q as a time", cell)
+	}
+
+	return nil, fmt.Errorf("unknown column type %d", columnType)
+}
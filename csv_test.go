@@ -0,0 +1,167 @@
+// Copyright 2023 Daniel Valdivia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rql
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const ordersCSV = `id,customer,amount,quantity,paid,created
+1,Alice,9.50,2,true,2024-01-15
+2,Bob,120.00,10,false,2024-02-01T10:30:00Z
+3,Charlie,75,1,TRUE,2023-12-31
+4,Dave,,3,false,
+`
+
+func TestReadCSV(t *testing.T) {
+	table, err := ReadCSV(strings.NewReader(ordersCSV))
+	if err != nil {
+		t.Fatalf("ReadCSV() unexpected error = %v", err)
+	}
+
+	wantTypes := []ColumnType{ColumnInt, ColumnString, ColumnFloat, ColumnInt, ColumnBool, ColumnTime}
+	if !reflect.DeepEqual(table.Types, wantTypes) {
+		t.Errorf("ReadCSV() inferred types = %v, want %v", table.Types, wantTypes)
+	}
+
+	tests := []struct {
+		name    string
+		filter  string
+		options FilterOptions
+		wantIDs []string
+	}{
+		{
+			name:    "numeric comparison on a float column",
+			filter:  "amount > 10",
+			wantIDs: []string{"2", "3"},
+		},
+		{
+			name:    "numeric equality ignores formatting",
+			filter:  "amount = 120",
+			wantIDs: []string{"2"},
+		},
+		{
+			name:    "bool column accepts any ParseBool spelling",
+			filter:  "paid = true",
+			wantIDs: []string{"1", "3"},
+		},
+		{
+			name:    "time comparison is chronological",
+			filter:  "created >= '2024-01-01'",
+			wantIDs: []string{"1", "2"},
+		},
+		{
+			name:    "time equality",
+			filter:  "created = '2024-02-01T10:30:00Z'",
+			wantIDs: []string{"2"},
+		},
+		{
+			name:    "empty cells behave like missing fields",
+			filter:  "amount < 100",
+			wantIDs: []string{"1", "3"},
+		},
+		{
+			name:    "case-insensitive header names",
+			filter:  "Customer LIKE '%e'",
+			wantIDs: []string{"1", "3", "4"},
+		},
+		{
+			name:    "sorting uses typed values",
+			filter:  "quantity > 0",
+			options: FilterOptions{Sort: []string{"-quantity"}, Limit: 2},
+			wantIDs: []string{"2", "4"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ApplyFilter(tt.filter, table.Rows, tt.options)
+			if err != nil {
+				t.Fatalf("ApplyFilter() unexpected error = %v", err)
+			}
+
+			gotIDs := make([]string, 0, len(result.Items))
+			for _, row := range result.Items {
+				gotIDs = append(gotIDs, row.Values[0])
+			}
+
+			if !reflect.DeepEqual(gotIDs, tt.wantIDs) {
+				t.Errorf("ApplyFilter() got IDs = %v, want %v", gotIDs, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func TestReadCSVDeclaredTypes(t *testing.T) {
+	input := "code,score\n007,9\n10,10\n"
+
+	// Inferred as integers, so 007 and 10 compare numerically
+	table, err := ReadCSV(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ReadCSV() unexpected error = %v", err)
+	}
+	result, err := ApplyFilter("code = 7", table.Rows)
+	if err != nil {
+		t.Fatalf("ApplyFilter() unexpected error = %v", err)
+	}
+	if result.Count != 1 {
+		t.Errorf("ApplyFilter() on inferred column Count = %d, want 1", result.Count)
+	}
+
+	// Declared as a string, so the leading zeros are significant
+	table, err = ReadCSV(strings.NewReader(input), CSVOptions{Types: map[string]ColumnType{"CODE": ColumnString}})
+	if err != nil {
+		t.Fatalf("ReadCSV() unexpected error = %v", err)
+	}
+	result, err = ApplyFilter("code = '007'", table.Rows)
+	if err != nil {
+		t.Fatalf("ApplyFilter() unexpected error = %v", err)
+	}
+	if result.Count != 1 || result.Items[0].Map()["code"] != "007" {
+		t.Errorf("ApplyFilter() on declared column = %v, want the 007 row", result.Items)
+	}
+
+	// Disabling inference keeps every undeclared column as a string
+	table, err = ReadCSV(strings.NewReader(input), CSVOptions{DisableInference: true})
+	if err != nil {
+		t.Fatalf("ReadCSV() unexpected error = %v", err)
+	}
+	if !reflect.DeepEqual(table.Types, []ColumnType{ColumnString, ColumnString}) {
+		t.Errorf("ReadCSV() types = %v, want all strings", table.Types)
+	}
+
+	// Declared types are enforced
+	_, err = ReadCSV(strings.NewReader(input), CSVOptions{Types: map[string]ColumnType{"score": ColumnBool}})
+	if err == nil {
+		t.Errorf("ReadCSV() expected an error for a non-boolean value in a bool column")
+	}
+}
+
+func TestCSVRowMaps(t *testing.T) {
+	table, err := ReadCSV(strings.NewReader("name;age\nAlice;30\n"), CSVOptions{Comma: ';'})
+	if err != nil {
+		t.Fatalf("ReadCSV() unexpected error = %v", err)
+	}
+
+	row := table.Rows[0]
+	if got, want := row.Map(), map[string]string{"name": "Alice", "age": "30"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("CSVRow.Map() = %v, want %v", got, want)
+	}
+	if got, want := row.TypedMap(), map[string]any{"name": "Alice", "age": int64(30)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("CSVRow.TypedMap() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,326 @@
+// Copyright 2023 Daniel Valdivia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rql
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+var (
+	differentialSeed  = flag.Uint64("differential.seed", 1, "seed for the filters generated by TestDifferential")
+	differentialCount = flag.Int("differential.count", 1000, "number of filters generated by TestDifferential")
+)
+
+// differentialColumns maps the TestPerson fields to the columns of the people table
+var differentialColumns = map[string]string{
+	"ID":                  "id",
+	"Name":                "name",
+	"Email":               "email",
+	"Age":                 "age",
+	"Active":              "active",
+	"Department.Name":     "department_name",
+	"Department.Location": "department_location",
+	"Tags":                "tags",
+	"Skills":              "skills",
+	"Scores":              "scores",
+}
+
+// differentialFilters are hand-written filters checked before the generated ones
+var differentialFilters = []string{
+	"",
+	"Name = 'Alice'",
+	"Age >= 30 AND Active = true",
+	"Age = 30.0 OR Age != 45",
+	"Email LIKE '%@example.com' AND NOT Name LIKE 'B%'",
+	"Name ILIKE 'a%' OR Name ILIKE '%E'",
+	"Name LIKE 'a%' OR Name LIKE '%E' OR Email LIKE 'BOB@%'",
+	"Name NOT LIKE 'a%' AND Email NOT LIKE '%.COM'",
+	"Email LIKE '_o_@%' OR Name NOT ILIKE '%\\L_%'",
+	"Email NOT LIKE '%!_%' ESCAPE '!' AND Name LIKE 'D_ve'",
+	"Department.Name = 'Engineering' OR (Age > 40 AND Department.Location >= 'Building E')",
+	"ID IN (1, 3, 5) AND Name NOT IN ('Charlie')",
+	"ANY(Tags) = 'go' OR ANY(Skills) != 'coding'",
+	"ANY(Tags) = ANY('react', 'content') AND ANY(Scores) != ANY(99, 80)",
+	"'python' = ANY(Tags) OR 85 <> ALL(Scores)",
+	"ANY(Scores) = 90 AND NOT (ANY(Tags) = 'react')",
+	"has(Email) AND true AND NOT false",
+	"substring(Name, 0, 2) = 'A' OR substring(Name, -3) = 'Charlie' OR substring(Name, -1, 3) = 'B'",
+	"substring(Email, ID, 3) = 'ice' OR lower(substring(Name, 0, 4)) = 'dav'",
+}
+
+// TestDifferential loads the TestPerson fixtures into an embedded SQLite database and checks
+// that ApplyFilter and the SQL translation select the same people, for hand-written filters
+// and for randomly generated ones
+func TestDifferential(t *testing.T) {
+	db := openDifferentialDB(t, testPeople)
+
+	filters := slices.Clone(differentialFilters)
+	r := rand.New(rand.NewPCG(*differentialSeed, 0))
+	for range *differentialCount {
+		filters = append(filters, generateFilter(r, 3))
+	}
+
+	for _, filter := range filters {
+		memoryIDs, sqlIDs, where, args, err := runDifferential(db, filter)
+		if err != nil {
+			t.Fatalf("filter %q (seed %d): %v", filter, *differentialSeed, err)
+		}
+		if !slices.Equal(memoryIDs, sqlIDs) {
+			t.Errorf("filter %q (seed %d) diverges:\n  in memory: %v\n  SQL:       %v\n  WHERE %s %v",
+				filter, *differentialSeed, memoryIDs, sqlIDs, where, args)
+		}
+	}
+}
+
+// openDifferentialDB creates an in-memory SQLite database holding the people, with array
+// fields stored as JSON
+func openDifferentialDB(t *testing.T, people []TestPerson) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	// Every connection to :memory: is a separate database
+	db.SetMaxOpenConns(1)
+
+	// The connection keeps SQLite's defaults, including the LIKE that ignores ASCII case,
+	// which the translation has to account for
+	statements := []string{
+		`CREATE TABLE people (
+			id INTEGER PRIMARY KEY,
+			name TEXT,
+			email TEXT,
+			age INTEGER,
+			active BOOLEAN,
+			department_name TEXT,
+			department_location TEXT,
+			tags TEXT,
+			skills TEXT,
+			scores TEXT
+		)`,
+	}
+	for _, statement := range statements {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, p := range people {
+		_, err := db.Exec("INSERT INTO people VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			p.ID, p.Name, p.Email, p.Age, p.Active, p.Department.Name, p.Department.Location,
+			mustJSON(t, p.Tags), mustJSON(t, p.Skills), mustJSON(t, p.Scores))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return db
+}
+
+// mustJSON encodes a value as JSON text
+func mustJSON(t *testing.T, value any) string {
+	t.Helper()
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(encoded)
+}
+
+// runDifferential applies a filter in memory and through SQLite, returning the IDs each selects
+func runDifferential(db *sql.DB, filter string) (memoryIDs, sqlIDs []int, where string, args []any, err error) {
+	result, err := ApplyFilter(filter, testPeople)
+	if err != nil {
+		return nil, nil, "", nil, fmt.Errorf("ApplyFilter: %w", err)
+	}
+	for _, person := range result.Items {
+		memoryIDs = append(memoryIDs, person.ID)
+	}
+
+	query, err := Compile(filter)
+	if err != nil {
+		return nil, nil, "", nil, fmt.Errorf("Compile: %w", err)
+	}
+	where, args, err = query.ToSQL(SQLOptions{Columns: differentialColumns, Dialect: SQLite})
+	if err != nil {
+		return nil, nil, "", nil, fmt.Errorf("ToSQL: %w", err)
+	}
+
+	rows, err := db.Query("SELECT id FROM people WHERE "+where+" ORDER BY id", args...)
+	if err != nil {
+		return nil, nil, where, args, fmt.Errorf("query WHERE %s: %w", where, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, nil, where, args, err
+		}
+		sqlIDs = append(sqlIDs, id)
+	}
+
+	return memoryIDs, sqlIDs, where, args, rows.Err()
+}
+
+// generatedField describes a TestPerson field the filter generator can use, with the
+// literals it is compared against written as they would appear in a filter
+type generatedField struct {
+	name   string
+	kind   LiteralKind
+	array  bool
+	values []string
+}
+
+// generatedFields mixes values present in the fixtures with values that match nothing
+var generatedFields = []generatedField{
+	{name: "ID", kind: NumberLiteral, values: []string{"1", "2", "3", "4", "5", "6", "2.5", "3.0"}},
+	{name: "Name", kind: StringLiteral, values: []string{"Alice", "Bob", "Charlie", "Dave", "Eve", "Zed", "B", "alice"}},
+	{name: "Email", kind: StringLiteral, values: []string{"alice@example.com", "dave@alternative.com", "eve@example.com", "example.com"}},
+	{name: "Age", kind: NumberLiteral, values: []string{"25", "30", "35", "40", "45", "33", "30.5", "40.0", "-1"}},
+	{name: "Active", kind: BoolLiteral, values: []string{"true", "false"}},
+	{name: "Department.Name", kind: StringLiteral, values: []string{"Engineering", "Marketing", "Sales", "HR", "Legal"}},
+	{name: "Department.Location", kind: StringLiteral, values: []string{"Building A", "Building C", "Building E", "Building"}},
+	{name: "Tags", kind: StringLiteral, array: true, values: []string{"go", "react", "python", "content", "training", "rust"}},
+	{name: "Skills", kind: StringLiteral, array: true, values: []string{"coding", "design", "writing", "policy", "cooking"}},
+	{name: "Scores", kind: NumberLiteral, array: true, values: []string{"80", "85", "88", "90", "99", "100"}},
+}
+
+// generateFilter returns a random filter nesting boolean operators up to the given depth
+func generateFilter(r *rand.Rand, depth int) string {
+	if depth == 0 || r.IntN(3) == 0 {
+		return generatePredicate(r)
+	}
+
+	switch r.IntN(5) {
+	case 0:
+		return "NOT (" + generateFilter(r, depth-1) + ")"
+	case 1, 2:
+		return "(" + generateFilter(r, depth-1) + ") AND (" + generateFilter(r, depth-1) + ")"
+	default:
+		return "(" + generateFilter(r, depth-1) + ") OR (" + generateFilter(r, depth-1) + ")"
+	}
+}
+
+// generatePredicate returns a random condition on a single field
+func generatePredicate(r *rand.Rand) string {
+	field := generatedFields[r.IntN(len(generatedFields))]
+	value := generateLiteral(r, field)
+
+	if field.array {
+		switch r.IntN(6) {
+		case 0:
+			return fmt.Sprintf("ANY(%s) = %s", field.name, value)
+		case 1:
+			return fmt.Sprintf("ANY(%s) != %s", field.name, value)
+		case 2:
+			return fmt.Sprintf("ANY(%s) = ANY(%s)", field.name, generateLiteralList(r, field))
+		case 3:
+			return fmt.Sprintf("ANY(%s) != ANY(%s)", field.name, generateLiteralList(r, field))
+		case 4:
+			return fmt.Sprintf("%s = ANY(%s)", value, field.name)
+		default:
+			return fmt.Sprintf("%s <> ALL(%s)", value, field.name)
+		}
+	}
+
+	operators := []string{"=", "!=", "<>", "IN", "NOT IN"}
+	switch field.kind {
+	case NumberLiteral:
+		operators = append(operators, ">", ">=", "<", "<=")
+	case StringLiteral:
+		operators = append(operators, ">", ">=", "<", "<=", "LIKE", "ILIKE", "NOT LIKE", "NOT ILIKE")
+	}
+
+	switch operator := operators[r.IntN(len(operators))]; operator {
+	case "IN", "NOT IN":
+		return fmt.Sprintf("%s %s (%s)", field.name, operator, generateLiteralList(r, field))
+	case "LIKE", "ILIKE", "NOT LIKE", "NOT ILIKE":
+		return fmt.Sprintf("%s %s %s", field.name, operator, quoteString(generatePattern(r, field, strings.HasSuffix(operator, "ILIKE"))))
+	default:
+		return fmt.Sprintf("%s %s %s", field.name, operator, value)
+	}
+}
+
+// generateLiteral returns one of the field's values, quoting strings
+func generateLiteral(r *rand.Rand, field generatedField) string {
+	value := field.values[r.IntN(len(field.values))]
+	if field.kind == StringLiteral {
+		return quoteString(value)
+	}
+	return value
+}
+
+// generateLiteralList returns a comma-separated list of one to three of the field's values
+func generateLiteralList(r *rand.Rand, field generatedField) string {
+	values := make([]string, 1+r.IntN(3))
+	for i := range values {
+		values[i] = generateLiteral(r, field)
+	}
+	return strings.Join(values, ", ")
+}
+
+// generatePattern returns a LIKE pattern built from a random substring of one of the
+// field's values, with some characters replaced by _ or escaped, optionally anchored by %
+// on either side
+func generatePattern(r *rand.Rand, field generatedField, caseInsensitive bool) string {
+	value := field.values[r.IntN(len(field.values))]
+	start := r.IntN(len(value))
+	end := start + 1 + r.IntN(len(value)-start)
+
+	var b strings.Builder
+	for _, c := range value[start:end] {
+		switch r.IntN(8) {
+		case 0:
+			b.WriteByte('_')
+		case 1:
+			b.WriteByte('\\')
+			b.WriteRune(c)
+		default:
+			b.WriteRune(c)
+		}
+	}
+	pattern := b.String()
+
+	if caseInsensitive && r.IntN(2) == 0 {
+		pattern = strings.ToUpper(pattern)
+	}
+	if start > 0 || r.IntN(4) == 0 {
+		pattern = "%" + pattern
+	}
+	if end < len(value) || r.IntN(4) == 0 {
+		pattern += "%"
+	}
+	if r.IntN(10) == 0 {
+		pattern = "%"
+	}
+	return pattern
+}
+
+// quoteString quotes a string literal for a filter
+func quoteString(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
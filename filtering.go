@@ -15,25 +15,29 @@
 package rql
 
 import (
-	"bufio"
+	"cmp"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
-	"net/url"
+	"math"
 	"reflect"
 	"regexp"
 	"slices"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/auxten/postgresql-parser/pkg/sql/parser"
 	"github.com/auxten/postgresql-parser/pkg/sql/sem/tree"
-	"github.com/auxten/postgresql-parser/pkg/walk"
 )
 
 // FilterOptions contains configuration options for filtering and pagination
 type FilterOptions struct {
-	Limit  int // Maximum number of results to return, 0 means no limit
-	Offset int // Number of results to skip before returning, 0 means start from beginning
+	Limit  int      // Maximum number of results to return, 0 means no limit
+	Offset int      // Number of results to skip before returning, 0 means start from beginning
+	Sort   []string // Fields to order results by before pagination, prefix with "-" for descending
 }
 
 // Result contains the filtered items and count information
@@ -53,136 +57,78 @@ func DefaultFilterOptions() FilterOptions {
 // ApplyFilter filters a slice of generic structs based on SQL-like filter conditions
 // with optional pagination using limit and offset
 func ApplyFilter[T any](rawFilters string, items []T, options ...FilterOptions) (Result[T], error) {
-	// Set default options if none provided
-	opts := DefaultFilterOptions()
-	if len(options) > 0 {
-		opts = options[0]
-	}
-
-	// Empty filter returns all items (possibly with pagination)
-	if rawFilters == "" {
-		return Result[T]{
-			Items: applyPagination(items, opts),
-			Count: len(items),
-		}, nil
-	}
-
-	// Pre-process ANY syntax before passing to the SQL parser
-	processedFilter, anyConditions, err := preprocessAnyOperator(rawFilters)
+	query, err := Compile(rawFilters)
 	if err != nil {
-		log.Println(err)
-		return Result[T]{}, err
-	}
-
-	rawFilters = url.QueryEscape(processedFilter)
-	rawFilters, err = url.QueryUnescape(rawFilters)
-	if err != nil {
-		log.Println(err)
 		return Result[T]{}, err
 	}
+	return ApplyQuery(query, items, options...), nil
+}
 
-	pseudoQuery := fmt.Sprintf("SELECT * FROM t WHERE %s", rawFilters)
-	queryTree, err := parser.Parse(pseudoQuery)
-	if err != nil {
-		// log.Println(err)
-		return Result[T]{}, err
+// applySort orders items in place by the fields listed in the sort options
+func applySort[T any](items []T, options FilterOptions) []T {
+	if len(options.Sort) == 0 {
+		return items
 	}
 
-	// Create a generic filter visitor
-	gfv := &GenericFilterVisitor{}
-
-	whereFinder := &walk.AstWalker{
-		Fn: func(ctx any, node any) (stop bool) {
-			if whereNode, ok := node.(*tree.Where); ok {
-				// Process the where clause
-				gfv.processWhereNode(whereNode)
-				return true
+	slices.SortStableFunc(items, func(a, b T) int {
+		for _, sortField := range options.Sort {
+			fieldPath, descending := strings.CutPrefix(sortField, "-")
+			if result := compareFieldValues(a, b, fieldPath, descending); result != 0 {
+				return result
 			}
-			return false
-		},
-	}
-
-	_, _ = whereFinder.Walk(queryTree, nil)
-
-	// We'll handle the ANY conditions separately later
+		}
+		return 0
+	})
 
-	// Apply filter to items
-	filteredItems := make([]T, 0)
+	return items
+}
 
-	// If we replaced the entire filter with placeholders, just use ANY conditions
-	if (rawFilters == "1=1" || rawFilters == "true") && len(anyConditions) > 0 {
-		gfv.rootCondition = nil
+// compareFieldValues compares a field of two items, numerically when both values are numbers.
+// Items missing the field sort after items that have it regardless of direction
+func compareFieldValues(a, b any, fieldPath string, descending bool) int {
+	aValue, aFound := getFieldValue(a, fieldPath)
+	bValue, bFound := getFieldValue(b, fieldPath)
+	if !aFound || !bFound {
+		switch {
+		case aFound:
+			return -1
+		case bFound:
+			return 1
+		default:
+			return 0
+		}
 	}
 
-	// Create a final filter condition combining SQL parser output and ANY conditions
-	// Handle complex case: filter with both regular conditions and ANY conditions
-	var finalCondition Condition
+	result, ok := compareNumbers(aValue, bValue)
+	if !ok {
+		result = strings.Compare(aValue, bValue)
+	}
 
-	// If the query only contains ANY operators (replaced with true placeholders)
-	if rawFilters == "true" || rawFilters == "1=1" || isAlwaysTrueCondition(gfv.rootCondition) {
-		// Use only the ANY conditions
-		if len(anyConditions) == 1 {
-			finalCondition = anyConditions[0]
-		} else if len(anyConditions) > 1 {
-			// Combine multiple ANY conditions with AND
-			root := anyConditions[0]
-			for i := 1; i < len(anyConditions); i++ {
-				root = &AndCondition{left: root, right: anyConditions[i]}
-			}
-			finalCondition = root
-		} else {
-			// No conditions
-			finalCondition = &alwaysTrueCondition{}
-		}
-	} else if len(anyConditions) > 0 {
-		// Complex query with both regular SQL conditions and ANY conditions
-		// log.Println("Combining SQL conditions with ANY conditions")
-
-		// Prepare the SQL conditions
-		sqlCondition := gfv.rootCondition
-
-		// Prepare the ANY conditions
-		var anyCondition Condition
-		if len(anyConditions) == 1 {
-			anyCondition = anyConditions[0]
-		} else {
-			// Combine multiple ANY conditions with AND
-			anyCondition = anyConditions[0]
-			for i := 1; i < len(anyConditions); i++ {
-				anyCondition = &AndCondition{left: anyCondition, right: anyConditions[i]}
-			}
-		}
+	if descending {
+		return -result
+	}
+	return result
+}
 
-		// For a complex filter like "ANY(Skills) = 'coding' AND Active = true"
-		// We need to combine the ANY condition with the regular SQL condition
-		// The SQL part will have been parsed with a placeholder in place of the ANY expression
-		if strings.Contains(rawFilters, " AND ") {
-			finalCondition = &AndCondition{left: anyCondition, right: sqlCondition}
-		} else if strings.Contains(rawFilters, " OR ") {
-			finalCondition = &OrCondition{left: anyCondition, right: sqlCondition}
-		} else {
-			// Default to using only the ANY condition if we can't determine the structure
-			finalCondition = anyCondition
+// compareNumbers compares two values written as numbers, exactly when both are integers so
+// values beyond 2^53 don't collapse into the same float64
+func compareNumbers(a, b string) (int, bool) {
+	if aInt, err := strconv.ParseInt(a, 10, 64); err == nil {
+		if bInt, err := strconv.ParseInt(b, 10, 64); err == nil {
+			return cmp.Compare(aInt, bInt), true
 		}
-	} else {
-		// Only regular SQL conditions, no ANY conditions
-		finalCondition = gfv.rootCondition
 	}
-
-	for _, item := range items {
-		if finalCondition == nil || finalCondition.evaluate(item) {
-			filteredItems = append(filteredItems, item)
+	if aUint, err := strconv.ParseUint(a, 10, 64); err == nil {
+		if bUint, err := strconv.ParseUint(b, 10, 64); err == nil {
+			return cmp.Compare(aUint, bUint), true
 		}
 	}
-
-	// Save the total count of matched items before pagination
-	totalCount := len(filteredItems)
-
-	// Apply pagination to filtered results
-	return Result[T]{
-		Items: applyPagination(filteredItems, opts),
-		Count: totalCount,
-	}, nil
+	aNum, aErr := strconv.ParseFloat(a, 64)
+	bNum, bErr := strconv.ParseFloat(b, 64)
+	if aErr != nil || bErr != nil {
+		return 0, false
+	}
+	return cmp.Compare(aNum, bNum), true
 }
 
 // applyPagination applies limit and offset pagination to a slice of items
@@ -205,14 +151,66 @@ func applyPagination[T any](items []T, options FilterOptions) []T {
 	return items
 }
 
+// parseFilter parses a SQL-like filter into a condition tree
+func parseFilter(rawFilters string) (Condition, error) {
+	if !utf8.ValidString(rawFilters) {
+		return nil, errors.New("filter must be valid UTF-8")
+	}
+
+	// ANY and ALL are keywords the SQL parser only accepts on the right side of a
+	// comparison, so rewrite our left-side and quantifier forms into function calls
+	pseudoQuery := fmt.Sprintf("SELECT * FROM t WHERE %s", rewriteAnyOperators(rawFilters))
+	statements, err := parser.Parse(pseudoQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	where, err := whereClause(statements)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create a generic filter visitor
+	gfv := &GenericFilterVisitor{}
+	if err := gfv.processWhereNode(where); err != nil {
+		return nil, err
+	}
+	return gfv.rootCondition, nil
+}
+
+// whereClause extracts the WHERE clause from the parsed pseudo-query, rejecting filters
+// that smuggle in other statements or clauses such as ORDER BY or UNION
+func whereClause(statements parser.Statements) (*tree.Where, error) {
+	if len(statements) != 1 {
+		return nil, errors.New("filter must be a single expression")
+	}
+
+	selectStmt, ok := statements[0].AST.(*tree.Select)
+	if !ok || selectStmt.With != nil || len(selectStmt.OrderBy) > 0 || selectStmt.Limit != nil || selectStmt.Locking != nil {
+		return nil, errors.New("filter must be a single expression")
+	}
+
+	clause, ok := selectStmt.Select.(*tree.SelectClause)
+	if !ok || clause.Where == nil || len(clause.GroupBy) > 0 || clause.Having != nil || len(clause.Window) > 0 {
+		return nil, errors.New("filter must be a single expression")
+	}
+
+	return clause.Where, nil
+}
+
 // GenericFilterVisitor handles filtering of generic structs
 type GenericFilterVisitor struct {
 	rootCondition Condition
 }
 
 // processWhereNode processes a where clause
-func (v *GenericFilterVisitor) processWhereNode(whereNode *tree.Where) {
-	v.rootCondition = v.processExpr(whereNode.Expr)
+func (v *GenericFilterVisitor) processWhereNode(whereNode *tree.Where) error {
+	condition, err := v.processExpr(whereNode.Expr)
+	if err != nil {
+		return err
+	}
+	v.rootCondition = condition
+	return nil
 }
 
 // evaluate evaluates if an item meets the filter conditions
@@ -224,122 +222,425 @@ func (v *GenericFilterVisitor) evaluate(item any) bool {
 }
 
 // processExpr processes a tree.Expr node and returns a Condition
-func (v *GenericFilterVisitor) processExpr(expr tree.Expr) Condition {
+func (v *GenericFilterVisitor) processExpr(expr tree.Expr) (Condition, error) {
 	switch node := expr.(type) {
 	case *tree.ComparisonExpr:
 		return v.processComparisonExpr(node)
 	case *tree.AndExpr:
-		return &AndCondition{
-			left:  v.processExpr(node.Left),
-			right: v.processExpr(node.Right),
+		left, right, err := v.processOperands(node.Left, node.Right)
+		if err != nil {
+			return nil, err
 		}
+		return &AndCondition{left: left, right: right}, nil
 	case *tree.OrExpr:
-		return &OrCondition{
-			left:  v.processExpr(node.Left),
-			right: v.processExpr(node.Right),
+		left, right, err := v.processOperands(node.Left, node.Right)
+		if err != nil {
+			return nil, err
+		}
+		return &OrCondition{left: left, right: right}, nil
+	case *tree.NotExpr:
+		condition, err := v.processExpr(node.Expr)
+		if err != nil {
+			return nil, err
+		}
+		return &NotCondition{condition: condition}, nil
+	case *tree.ParenExpr:
+		return v.processExpr(node.Expr)
+	case *tree.FuncExpr:
+		return v.processFuncExpr(node)
+	case *tree.DBool:
+		return &ConstantCondition{value: bool(*node)}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported expression: %s", expr)
+}
+
+// processOperands processes both operands of a boolean operator
+func (v *GenericFilterVisitor) processOperands(leftExpr, rightExpr tree.Expr) (Condition, Condition, error) {
+	left, err := v.processExpr(leftExpr)
+	if err != nil {
+		return nil, nil, err
+	}
+	right, err := v.processExpr(rightExpr)
+	if err != nil {
+		return nil, nil, err
+	}
+	return left, right, nil
+}
+
+// processFuncExpr processes a function call used as a condition, such as has(Labels.env)
+func (v *GenericFilterVisitor) processFuncExpr(node *tree.FuncExpr) (Condition, error) {
+	switch name := functionName(node); name {
+	case "has":
+		if len(node.Exprs) == 1 {
+			if fieldName, ok := fieldPathFromExpr(node.Exprs[0]); ok {
+				return &HasCondition{field: fieldName}, nil
+			}
+			// Whether a function's result isn't null, e.g. has(coalesce(Nickname, Name))
+			if function, ok, err := functionFromExpr(node.Exprs[0]); ok {
+				if err != nil {
+					return nil, err
+				}
+				return withFunction(function, &HasCondition{})
+			}
+		}
+		return nil, fmt.Errorf("has() expects a single field: %s", node)
+	case "any", "all":
+		// Quantifiers rewritten by rewriteAnyOperators, e.g. ANY(Orders, Status = 'paid')
+		if len(node.Exprs) == 2 {
+			if fieldName, ok := fieldPathFromExpr(node.Exprs[0]); ok {
+				condition, err := v.processExpr(node.Exprs[1])
+				if err != nil {
+					return nil, err
+				}
+				return &QuantifierCondition{field: fieldName, condition: condition, all: name == "all"}, nil
+			}
+		}
+		return nil, fmt.Errorf("%s() expects a field and a condition: %s", strings.ToUpper(name), node)
+	case "like_escape", "ilike_escape", "not_like_escape", "not_ilike_escape":
+		// LIKE with an ESCAPE clause, e.g. Name LIKE '100!%' ESCAPE '!'. The parser produces
+		// the same call when these names are typed, so the arguments are checked
+		if len(node.Exprs) != 3 {
+			return nil, fmt.Errorf("%s() expects a field, a pattern and an escape character: %s", name, node)
+		}
+		function, isFunction, err := functionFromExpr(node.Exprs[0])
+		if err != nil {
+			return nil, err
+		}
+		fieldName, ok := fieldPathFromExpr(node.Exprs[0])
+		if !ok && !isFunction {
+			return nil, fmt.Errorf("expected a field on the left side of %s", node)
 		}
+		pattern, err := literalValue(node.Exprs[1])
+		if err != nil {
+			return nil, err
+		}
+		escape, err := literalValue(node.Exprs[2])
+		if err != nil {
+			return nil, err
+		}
+		text, err := likeEscape(pattern.text, escape.text)
+		if err != nil {
+			return nil, err
+		}
+		condition, err := likeComparison(fieldName, Literal{text: text, kind: pattern.kind}, strings.Contains(name, "ilike"), strings.HasPrefix(name, "not_"))
+		if err != nil || !isFunction {
+			return condition, err
+		}
+		return withFunction(function, condition)
 	}
 
-	// Default to always true for unhandled expressions
-	return &alwaysTrueCondition{}
+	return nil, fmt.Errorf("unsupported function: %s", node)
 }
 
 // processComparisonExpr processes a comparison expression
-func (v *GenericFilterVisitor) processComparisonExpr(node *tree.ComparisonExpr) Condition {
-	// Check if we're dealing with ANY operator (left side)
-	leftStr := node.Left.String()
-	isAnyField := false
-	var fieldName string
-
-	// Parse ANY(field) syntax
-	if strings.HasPrefix(leftStr, "ANY(") && strings.HasSuffix(leftStr, ")") {
-		isAnyField = true
-		fieldName = leftStr[4 : len(leftStr)-1] // Extract field name from ANY(field)
-	} else {
-		fieldName = leftStr
-	}
-
-	// Process the right side
-	var value string
-	var values []string
-	isAnyValue := false
-
-	// Check if right side is ANY('x', 'y') format
-	rightStr := node.Right.String()
-	if strings.HasPrefix(rightStr, "ANY(") && strings.HasSuffix(rightStr, ")") {
-		isAnyValue = true
-		// Extract values from ANY('x', 'y', 'z') format
-		valuesStr := rightStr[4 : len(rightStr)-1]
-
-		// Parse the comma-separated values, handling quoted strings
-		values = parseAnyValues(valuesStr)
-	} else {
-		// Regular single value
-		if valStr, ok := node.Right.(*tree.StrVal); ok {
-			value = valStr.RawString()
-		} else {
-			// For other types, use the string representation
-			value = node.Right.String()
-		}
-	}
-
-	// Special handling for true/false literals (used as replacements for ANY operators)
-	if fieldName == "true" || fieldName == "false" {
-		// log.Printf("Detected placeholder condition: %s %s %s", fieldName, node.Operator, rightStr)
-		if fieldName == "true" && (rightStr == "true" || rightStr == "1=1") {
-			return &alwaysTrueCondition{}
-		}
-		// For complex conditions, we need to make sure the ANY parts are properly evaluated
-		// and not the placeholders
-		return &alwaysTrueCondition{}
+func (v *GenericFilterVisitor) processComparisonExpr(node *tree.ComparisonExpr) (Condition, error) {
+	// A function such as lower(Email) on the left side is applied to field values first.
+	// Its result is compared as if it were the item itself, named "", and each comparison
+	// built for it is then applied to the result instead
+	if function, ok, err := functionFromExpr(node.Left); ok {
+		if err != nil {
+			return nil, err
+		}
+		result := *node
+		result.Left = &tree.UnresolvedName{NumParts: 1, Parts: tree.NameParts{""}}
+		condition, err := v.processComparisonExpr(&result)
+		if err != nil {
+			return nil, err
+		}
+		return withFunction(function, condition)
+	}
+
+	switch node.Operator {
+	case tree.Any, tree.Some, tree.All:
+		return v.processSubqueryComparison(node)
+	case tree.In, tree.NotIn:
+		return v.processInComparison(node)
+	}
+
+	// ANY(field) = 'x' compares each element of an array field, see rewriteAnyOperators
+	if fieldName, ok := anyFieldFromExpr(node.Left); ok {
+		value, err := literalValue(node.Right)
+		if err != nil {
+			return nil, err
+		}
+		switch node.Operator {
+		case tree.EQ:
+			return &AnyArrayContainsCondition{field: fieldName, value: value}, nil
+		case tree.NE:
+			return &AnyArrayNotContainsCondition{field: fieldName, value: value}, nil
+		}
+		return nil, fmt.Errorf("unsupported operator %s for ANY(%s)", node.Operator, fieldName)
+	}
+
+	fieldName, ok := fieldPathFromExpr(node.Left)
+	if !ok {
+		return nil, fmt.Errorf("expected a field on the left side of %s", node)
+	}
+	value, err := literalValue(node.Right)
+	if err != nil {
+		return nil, err
 	}
 
 	// Now create conditions based on different operator combinations
 	switch node.Operator {
 	case tree.EQ:
-		if isAnyField {
-			if isAnyValue {
-				// ANY(field) = ANY('x', 'y')
-				return &AnyArrayContainsAnyCondition{field: fieldName, values: values}
-			} else {
-				// ANY(field) = 'x'
-				return &AnyArrayContainsCondition{field: fieldName, value: value}
-			}
-		} else {
-			return &EqualCondition{field: fieldName, value: value}
-		}
+		return &EqualCondition{field: fieldName, value: value}, nil
 	case tree.NE:
-		if isAnyField {
-			if isAnyValue {
-				// ANY(field) != ANY('x', 'y')
-				return &AnyArrayNotContainsAnyCondition{field: fieldName, values: values}
-			} else {
-				// ANY(field) != 'x'
-				return &AnyArrayNotContainsCondition{field: fieldName, value: value}
-			}
-		} else {
-			return &NotEqualCondition{field: fieldName, value: value}
-		}
-	case tree.Like:
-		return &LikeCondition{field: fieldName, value: value, caseInsensitive: false}
-	case tree.ILike:
-		return &LikeCondition{field: fieldName, value: value, caseInsensitive: true}
+		return &NotEqualCondition{field: fieldName, value: value}, nil
+	case tree.Like, tree.ILike, tree.NotLike, tree.NotILike:
+		caseInsensitive := node.Operator == tree.ILike || node.Operator == tree.NotILike
+		return likeComparison(fieldName, value, caseInsensitive, node.Operator == tree.NotLike || node.Operator == tree.NotILike)
+	case tree.RegMatch, tree.RegIMatch, tree.NotRegMatch, tree.NotRegIMatch:
+		caseInsensitive := node.Operator == tree.RegIMatch || node.Operator == tree.NotRegIMatch
+		return regexComparison(fieldName, value, caseInsensitive, node.Operator == tree.NotRegMatch || node.Operator == tree.NotRegIMatch)
 	case tree.GE: // Greater than or equal
-		return &ComparisonCondition{field: fieldName, value: value, operator: ">="}
+		return &ComparisonCondition{field: fieldName, value: value, operator: ">="}, nil
 	case tree.GT: // Greater than
-		return &ComparisonCondition{field: fieldName, value: value, operator: ">"}
+		return &ComparisonCondition{field: fieldName, value: value, operator: ">"}, nil
 	case tree.LT: // Less than
-		return &ComparisonCondition{field: fieldName, value: value, operator: "<"}
+		return &ComparisonCondition{field: fieldName, value: value, operator: "<"}, nil
 	case tree.LE: // Less than or equal
-		return &ComparisonCondition{field: fieldName, value: value, operator: "<="}
-	// Add more operators as needed
+		return &ComparisonCondition{field: fieldName, value: value, operator: "<="}, nil
+	case tree.JSONExists: // Key existence, e.g. Labels ? 'env'
+		return &HasCondition{field: formatFieldPath(append(splitFieldPath(fieldName), value.text))}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported operator: %s", node.Operator)
+}
+
+// processSubqueryComparison processes comparisons against ANY(...) or ALL(...) on the right side:
+//
+//	ANY(Tags) = ANY('a', 'b')   any element of Tags equals any of the values
+//	ANY(Tags) != ANY('a', 'b')  no element of Tags equals any of the values
+//	'a' = ANY(Tags)             any element of Tags equals 'a' (native SQL form)
+//	'a' != ALL(Tags)            no element of Tags equals 'a' (native SQL form)
+//	Name = ANY(ARRAY['a', 'b']) Name equals any of the values
+func (v *GenericFilterVisitor) processSubqueryComparison(node *tree.ComparisonExpr) (Condition, error) {
+	isAny := node.Operator == tree.Any || node.Operator == tree.Some
+
+	if fieldName, ok := anyFieldFromExpr(node.Left); ok && isAny {
+		values, err := literalList(node.Right)
+		if err != nil {
+			return nil, err
+		}
+		switch node.SubOperator {
+		case tree.EQ:
+			return &AnyArrayContainsAnyCondition{field: fieldName, values: values}, nil
+		case tree.NE:
+			return &AnyArrayNotContainsAnyCondition{field: fieldName, values: values}, nil
+		}
+		return nil, fmt.Errorf("unsupported operator %s for ANY(%s)", node.SubOperator, fieldName)
+	}
+
+	if value, err := literalValue(node.Left); err == nil {
+		if fieldName, ok := fieldPathFromExpr(node.Right); ok {
+			switch {
+			case isAny && node.SubOperator == tree.EQ:
+				return &AnyArrayContainsCondition{field: fieldName, value: value}, nil
+			case !isAny && node.SubOperator == tree.NE:
+				return &AnyArrayNotContainsCondition{field: fieldName, value: value}, nil
+			}
+			return nil, fmt.Errorf("unsupported array comparison: %s", node)
+		}
+	}
+
+	if fieldName, ok := fieldPathFromExpr(node.Left); ok && isAny && node.SubOperator == tree.EQ {
+		values, err := literalList(node.Right)
+		if err != nil {
+			return nil, err
+		}
+		return inCondition(fieldName, values, false), nil
+	}
+
+	return nil, fmt.Errorf("unsupported array comparison: %s", node)
+}
+
+// processInComparison processes Name IN ('a', 'b') and Name NOT IN ('a', 'b')
+func (v *GenericFilterVisitor) processInComparison(node *tree.ComparisonExpr) (Condition, error) {
+	fieldName, ok := fieldPathFromExpr(node.Left)
+	if !ok {
+		return nil, fmt.Errorf("expected a field on the left side of %s", node)
+	}
+	values, err := literalList(node.Right)
+	if err != nil {
+		return nil, err
+	}
+	return inCondition(fieldName, values, node.Operator == tree.NotIn), nil
+}
+
+// inCondition builds a condition matching a field against a list of values, as a chain of
+// equalities joined by OR, or of inequalities joined by AND when negated
+func inCondition(fieldName string, values []Literal, negate bool) Condition {
+	var condition Condition
+	for _, value := range values {
+		var next Condition = &EqualCondition{field: fieldName, value: value}
+		if negate {
+			next = &NotEqualCondition{field: fieldName, value: value}
+		}
+
+		switch {
+		case condition == nil:
+			condition = next
+		case negate:
+			condition = &AndCondition{left: condition, right: next}
+		default:
+			condition = &OrCondition{left: condition, right: next}
+		}
+	}
+	return condition
+}
+
+// anyFieldFromExpr returns the field of an ANY(field) expression on the left side of a
+// comparison, which rewriteAnyOperators turned into a call to the "any" function
+func anyFieldFromExpr(expr tree.Expr) (string, bool) {
+	fn, ok := expr.(*tree.FuncExpr)
+	if !ok || functionName(fn) != "any" || len(fn.Exprs) != 1 {
+		return "", false
+	}
+	return fieldPathFromExpr(fn.Exprs[0])
+}
+
+// LiteralKind is the type of a literal value as it was written in the filter
+type LiteralKind int
+
+const (
+	StringLiteral LiteralKind = iota // A quoted string or an unquoted word
+	NumberLiteral                    // An integer or decimal number
+	BoolLiteral                      // true or false
+)
+
+// String returns the name of the kind
+func (k LiteralKind) String() string {
+	switch k {
+	case NumberLiteral:
+		return "number"
+	case BoolLiteral:
+		return "bool"
+	default:
+		return "string"
+	}
+}
+
+// Literal is a value from the filter. Conditions compare its text, while translators use
+// its kind to pass it to a database with the right type
+type Literal struct {
+	text string
+	kind LiteralKind
+}
+
+// Text returns the literal as written in the filter, without quotes
+func (l Literal) Text() string {
+	return l.text
+}
+
+// Kind returns the type of the literal
+func (l Literal) Kind() LiteralKind {
+	return l.kind
+}
+
+// String returns the literal as it would be written in a SQL-like filter
+func (l Literal) String() string {
+	if l.kind == StringLiteral {
+		return "'" + strings.ReplaceAll(l.text, "'", "''") + "'"
+	}
+	return l.text
+}
+
+// Value converts the literal into a value of the matching Go type: int64 or float64 for
+// numbers, bool for booleans and string otherwise
+func (l Literal) Value() any {
+	switch l.kind {
+	case NumberLiteral:
+		if n, err := strconv.ParseInt(l.text, 10, 64); err == nil {
+			return n
+		}
+		if f, err := strconv.ParseFloat(l.text, 64); err == nil {
+			return f
+		}
+	case BoolLiteral:
+		if b, err := strconv.ParseBool(l.text); err == nil {
+			return b
+		}
+	}
+	return l.text
+}
+
+// literalValue returns the value of a literal in the parsed filter. Unquoted words are
+// accepted as values too, e.g. Status = active
+func literalValue(expr tree.Expr) (Literal, error) {
+	switch node := expr.(type) {
+	case *tree.StrVal:
+		return Literal{text: node.RawString(), kind: StringLiteral}, nil
+	case *tree.NumVal:
+		return Literal{text: node.String(), kind: NumberLiteral}, nil
+	case *tree.DBool:
+		return Literal{text: node.String(), kind: BoolLiteral}, nil
+	case *tree.UnresolvedName:
+		if name, ok := fieldPathFromExpr(node); ok {
+			return Literal{text: name, kind: StringLiteral}, nil
+		}
+	case *tree.ParenExpr:
+		return literalValue(node.Expr)
+	}
+	return Literal{}, fmt.Errorf("unsupported value: %s", expr)
+}
+
+// literalList returns the values of a list of literals such as ('a', 'b') or ARRAY['a', 'b']
+func literalList(expr tree.Expr) ([]Literal, error) {
+	var exprs tree.Exprs
+	switch node := expr.(type) {
+	case *tree.Tuple:
+		exprs = node.Exprs
+	case *tree.Array:
+		exprs = node.Exprs
+	case *tree.ParenExpr:
+		if _, ok := node.Expr.(*tree.Array); ok {
+			return literalList(node.Expr)
+		}
+		exprs = tree.Exprs{node.Expr}
 	default:
-		// Default to equality
-		return &EqualCondition{field: fieldName, value: value}
+		return nil, fmt.Errorf("expected a list of values: %s", expr)
+	}
+
+	values := make([]Literal, 0, len(exprs))
+	for _, e := range exprs {
+		value, err := literalValue(e)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("expected a list of values: %s", expr)
 	}
+	return values, nil
 }
 
-// Condition interface for filter conditions
+// Condition is a node of the syntax tree of a compiled filter. Conditions can't be
+// modified, but their accessors expose what the filter asked for, so that applications can
+// audit the fields a filter references, enforce their own policies or write their own
+// translators by switching on the condition types:
+//
+//	AndCondition, OrCondition        both or either operand match
+//	NotCondition                     the operand does not match
+//	ConstantCondition                true or false
+//	EqualCondition                   field = value
+//	NotEqualCondition                field != value
+//	ComparisonCondition              field > value, and >=, < and <=
+//	LikeCondition                    field LIKE pattern, or ILIKE
+//	RegexCondition                   field ~ pattern, or ~*
+//	HasCondition                     the field or map key is present
+//	AnyArrayContainsCondition        ANY(field) = value
+//	AnyArrayNotContainsCondition     ANY(field) != value
+//	AnyArrayContainsAnyCondition     ANY(field) = ANY(values)
+//	AnyArrayNotContainsAnyCondition  ANY(field) != ANY(values)
+//	QuantifierCondition              ANY(field, predicate) or ALL(field, predicate)
+//	FunctionCondition                a comparison of a function's result, e.g. lower(field) = value
+//
+// IN and NOT IN lists compile to chains of OR and AND. Fields are paths as accepted in
+// filters, e.g. Department.Name, which SplitFieldPath splits into their parts. Fields inside
+// the predicate of a quantifier are relative to the elements of the quantified field
 type Condition interface {
 	evaluate(item any) bool
 }
@@ -370,220 +671,563 @@ func (c *OrCondition) evaluate(item any) bool {
 // EqualCondition checks if a field equals a value
 type EqualCondition struct {
 	field string
-	value string
+	value Literal
 }
 
 func (c *EqualCondition) evaluate(item any) bool {
-	fieldValue, found := getFieldValue(item, c.field)
-	if !found {
-		return false
-	}
-	return fieldValue == c.value
+	equal, found := fieldEquals(item, c.field, c.value.text)
+	return found && equal
 }
 
 // NotEqualCondition checks if a field is not equal to a value
 type NotEqualCondition struct {
 	field string
-	value string
+	value Literal
 }
 
 func (c *NotEqualCondition) evaluate(item any) bool {
-	fieldValue, found := getFieldValue(item, c.field)
+	equal, found := fieldEquals(item, c.field, c.value.text)
+	return found && !equal
+}
+
+// fieldEquals compares a field with a value, comparing instants when the field holds a time
+func fieldEquals(item any, fieldPath string, value string) (equal bool, found bool) {
+	field, found := resolveField(item, fieldPath)
 	if !found {
-		return false
+		return false, false
+	}
+
+	if fieldTime, ok := timeFieldValue(field); ok {
+		if valueTime, ok := parseTime(value); ok {
+			return fieldTime.Equal(valueTime), true
+		}
 	}
-	return fieldValue != c.value
+
+	// Numeric fields compare by value, so 40 equals 40.0
+	if result, ok := compareNumber(field, value); ok {
+		return result == 0, true
+	}
+
+	return fmt.Sprintf("%v", field.Interface()) == value, true
+}
+
+// compareNumber compares a numeric field with a number, exactly when both are integers so
+// values beyond 2^53 don't collapse into the same float64
+func compareNumber(field reflect.Value, value string) (int, bool) {
+	if valueInt, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if fieldInt, ok := integerFieldValue(field); ok {
+			return cmp.Compare(fieldInt, valueInt), true
+		}
+	}
+	fieldNum, ok := numericFieldValue(field)
+	if !ok {
+		return 0, false
+	}
+	valueNum, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, false
+	}
+	return cmp.Compare(fieldNum, valueNum), true
+}
+
+// integerFieldValue returns the value of a field holding a signed or unsigned integer that fits
+// an int64, or a json.Number holding one
+func integerFieldValue(field reflect.Value) (int64, bool) {
+	field = indirect(field)
+	if number, ok := jsonNumberValue(field); ok {
+		i, err := number.Int64()
+		return i, err == nil
+	}
+	switch field.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return field.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if u := field.Uint(); u <= math.MaxInt64 {
+			return int64(u), true
+		}
+	}
+	return 0, false
+}
+
+// jsonNumberValue returns the number held by a field decoded with json.Decoder.UseNumber
+func jsonNumberValue(field reflect.Value) (json.Number, bool) {
+	if !field.IsValid() || field.Type() != reflect.TypeFor[json.Number]() {
+		return "", false
+	}
+	return json.Number(field.String()), true
+}
+
+// numericFieldValue returns the value of a field holding any integer or float type, or a json.Number
+func numericFieldValue(field reflect.Value) (float64, bool) {
+	field = indirect(field)
+	if number, ok := jsonNumberValue(field); ok {
+		f, err := number.Float64()
+		return f, err == nil
+	}
+	switch field.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(field.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(field.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return field.Float(), true
+	}
+	return 0, false
 }
 
 // LikeCondition checks if a field matches a pattern
 type LikeCondition struct {
 	field           string
-	value           string
+	value           Literal
+	caseInsensitive bool
+	pattern         likePattern // value compiled by newLikeCondition
+}
+
+// RegexCondition checks if a field matches a regular expression
+type RegexCondition struct {
+	field           string
+	value           Literal
 	caseInsensitive bool
+	regexp          *regexp.Regexp // value compiled by newRegexCondition
 }
 
 // ComparisonCondition handles numeric comparisons (>, <, >=, <=)
 type ComparisonCondition struct {
 	field    string
-	value    string
+	value    Literal
 	operator string // ">", "<", ">=", "<="
 }
 
 func (c *ComparisonCondition) evaluate(item any) bool {
-	fieldValue, found := getFieldValue(item, c.field)
+	field, found := resolveField(item, c.field)
 	if !found {
 		return false
 	}
 
+	// Times are compared chronologically when the value parses as a time
+	if fieldTime, ok := timeFieldValue(field); ok {
+		if valueTime, ok := parseTime(c.value.text); ok {
+			return compareResult(fieldTime.Compare(valueTime), c.operator)
+		}
+	}
+
+	if result, ok := compareNumber(field, c.value.text); ok {
+		return compareResult(result, c.operator)
+	}
+
+	fieldValue := fmt.Sprintf("%v", field.Interface())
+
 	// Try to convert both values to numbers for numeric comparison
 	fieldNum, fieldErr := strconv.ParseFloat(fieldValue, 64)
-	valueNum, valueErr := strconv.ParseFloat(c.value, 64)
+	valueNum, valueErr := strconv.ParseFloat(c.value.text, 64)
 
-	// If either value is not a number, fall back to string comparison
+	// If either value is not a number, fall back to string comparison (lexicographical)
 	if fieldErr != nil || valueErr != nil {
-		// String comparison (lexicographical)
-		switch c.operator {
-		case ">":
-			return fieldValue > c.value
-		case ">=":
-			return fieldValue >= c.value
-		case "<":
-			return fieldValue < c.value
-		case "<=":
-			return fieldValue <= c.value
-		default:
-			return false
-		}
+		return compareResult(strings.Compare(fieldValue, c.value.text), c.operator)
 	}
 
 	// Numeric comparison
-	switch c.operator {
+	return compareResult(cmp.Compare(fieldNum, valueNum), c.operator)
+}
+
+// compareResult applies a comparison operator to the result of a three-way comparison
+func compareResult(result int, operator string) bool {
+	switch operator {
 	case ">":
-		return fieldNum > valueNum
+		return result > 0
 	case ">=":
-		return fieldNum >= valueNum
+		return result >= 0
 	case "<":
-		return fieldNum < valueNum
+		return result < 0
 	case "<=":
-		return fieldNum <= valueNum
+		return result <= 0
 	default:
 		return false
 	}
 }
 
+// timeLayouts are the layouts accepted for time values in filters, tried in order
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// parseTime parses a filter value as a time using any of the accepted layouts
+func parseTime(value string) (time.Time, bool) {
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// timeFieldValue returns the time held by a field, if the field is a time.Time or a pointer to one
+func timeFieldValue(field reflect.Value) (time.Time, bool) {
+	field = indirect(field)
+	if !field.IsValid() || !field.CanInterface() {
+		return time.Time{}, false
+	}
+	t, ok := field.Interface().(time.Time)
+	return t, ok
+}
+
 func (c *LikeCondition) evaluate(item any) bool {
 	fieldValue, found := getFieldValue(item, c.field)
 	if !found {
 		return false
 	}
+	return c.pattern.match(fieldValue)
+}
+
+func (c *RegexCondition) evaluate(item any) bool {
+	fieldValue, found := getFieldValue(item, c.field)
+	if !found {
+		return false
+	}
+	return c.regexp.MatchString(fieldValue)
+}
 
-	// Handle case sensitivity
-	str := fieldValue
-	pattern := c.value
-	if c.caseInsensitive {
-		str = strings.ToLower(str)
-		pattern = strings.ToLower(pattern)
+// HasCondition checks if a field or map key is present and not nil
+type HasCondition struct {
+	field string
+}
+
+func (c *HasCondition) evaluate(item any) bool {
+	field, found := resolveField(item, c.field)
+	if !found {
+		return false
 	}
+	return indirect(field).IsValid()
+}
+
+// QuantifierCondition checks if any or all elements of an array field match a nested condition.
+// The nested condition's fields are resolved against each element
+type QuantifierCondition struct {
+	field     string
+	condition Condition
+	all       bool // true for ALL, false for ANY
+}
 
-	// Simple approach for %X% patterns - exact substring match
-	if strings.HasPrefix(pattern, "%") && strings.HasSuffix(pattern, "%") {
-		search := strings.Trim(pattern, "%")
-		return strings.Contains(str, search)
+func (c *QuantifierCondition) evaluate(item any) bool {
+	field, found := resolveField(item, c.field)
+	if !found {
+		return false
 	}
 
-	// Handle SQL-style patterns directly without full regex
-	if pattern == "%" {
-		// Just a wildcard matches everything
-		return true
+	// A nil pointer to a slice is treated as an empty slice
+	field = indirect(field)
+	if !field.IsValid() {
+		return c.all
+	}
+	if field.Kind() != reflect.Array && field.Kind() != reflect.Slice {
+		return false
 	}
 
-	// Handle exact prefix matches
-	if !strings.HasPrefix(pattern, "%") && strings.HasSuffix(pattern, "%") {
-		// Pattern like "value%", check prefix
-		prefix := strings.TrimSuffix(pattern, "%")
-		return strings.HasPrefix(str, prefix)
+	// ANY is false and ALL is vacuously true for empty and nil slices
+	for i := range field.Len() {
+		if c.condition.evaluate(field.Index(i).Interface()) != c.all {
+			return !c.all
+		}
 	}
+	return c.all
+}
+
+// FunctionCondition applies a comparison to the result of a function, such as lower(Email),
+// rather than to a field. The comparison's field is "", which refers to the result
+type FunctionCondition struct {
+	function  *Function
+	condition Condition
+}
+
+func (c *FunctionCondition) evaluate(item any) bool {
+	return c.condition.evaluate(c.function.evaluate(item))
+}
+
+// NotCondition negates a condition
+type NotCondition struct {
+	condition Condition
+}
+
+func (c *NotCondition) evaluate(item any) bool {
+	return !c.condition.evaluate(item)
+}
 
-	// Handle exact suffix matches
-	if strings.HasPrefix(pattern, "%") && !strings.HasSuffix(pattern, "%") {
-		// Pattern like "%value", check suffix
-		suffix := strings.TrimPrefix(pattern, "%")
-		return strings.HasSuffix(str, suffix)
+// ConstantCondition is a condition that always evaluates to the same value, e.g. a bare true
+type ConstantCondition struct {
+	value bool
+}
+
+func (c *ConstantCondition) evaluate(item any) bool {
+	return c.value
+}
+
+// fieldPathFromExpr converts a field reference in the parsed filter into a field path.
+// String subscripts such as Labels['app.kubernetes.io/name'] become map keys
+func fieldPathFromExpr(expr tree.Expr) (string, bool) {
+	switch node := expr.(type) {
+	case *tree.UnresolvedName:
+		if node.Star {
+			return "", false
+		}
+		// A zero-length name such as "" refers to the item itself, e.g. to the elements of
+		// a slice of strings in ANY(Tags, "" LIKE 'go%')
+		if node.NumParts == 1 && node.Parts[0] == "" {
+			return "", true
+		}
+		// Name parts are stored in reverse order
+		parts := make([]string, node.NumParts)
+		for i := range node.NumParts {
+			parts[i] = node.Parts[node.NumParts-1-i]
+		}
+		return formatFieldPath(parts), true
+	case *tree.IndirectionExpr:
+		base, ok := fieldPathFromExpr(node.Expr)
+		if !ok {
+			return "", false
+		}
+		parts := SplitFieldPath(base)
+		for _, subscript := range node.Indirection {
+			key, ok := subscript.Begin.(*tree.StrVal)
+			if !ok || subscript.Slice {
+				return "", false
+			}
+			parts = append(parts, key.RawString())
+		}
+		return formatFieldPath(parts), true
+	case *tree.ParenExpr:
+		return fieldPathFromExpr(node.Expr)
 	}
+	return "", false
+}
 
-	// Handle exact matches with no wildcards
-	if !strings.Contains(pattern, "%") {
-		// No wildcards, exact match
-		return str == pattern
+// functionName returns the lower-cased name of the function called by a function expression
+func functionName(node *tree.FuncExpr) string {
+	switch fn := node.Func.FunctionReference.(type) {
+	case *tree.UnresolvedName:
+		return strings.ToLower(fn.Parts[0])
+	case *tree.FunctionDefinition:
+		return strings.ToLower(fn.Name)
 	}
+	return strings.ToLower(node.Func.String())
+}
 
-	// For more complex patterns, use the proper regex approach
-	// First escape regex special characters
-	regexSpecial := []string{".", "^", "$", "*", "+", "?", "(", ")", "[", "]", "{", "}", "|"}
-	regexPattern := pattern
-	for _, char := range regexSpecial {
-		regexPattern = strings.ReplaceAll(regexPattern, char, "\\"+char)
+// splitFieldPath splits a field path into its parts. Parts are separated by dots, and
+// bracketed keys such as Labels['app.kubernetes.io/name'] may contain any character,
+// with quotes inside the key doubled
+func splitFieldPath(fieldPath string) []string {
+	var parts []string
+	var current strings.Builder
+	pending := false // Whether current holds a part that hasn't been added yet
+
+	for i := 0; i < len(fieldPath); i++ {
+		switch ch := fieldPath[i]; ch {
+		case '.':
+			if pending {
+				parts = append(parts, current.String())
+			}
+			current.Reset()
+			pending = true
+		case '[':
+			if pending && current.Len() > 0 {
+				parts = append(parts, current.String())
+			}
+			current.Reset()
+
+			key, end, ok := scanBracketKey(fieldPath, i)
+			if !ok {
+				// Unbalanced bracket, keep the remainder as a literal part
+				current.WriteString(fieldPath[i:])
+				i = len(fieldPath)
+				pending = true
+				continue
+			}
+			parts = append(parts, key)
+			i = end
+			pending = false
+		default:
+			current.WriteByte(ch)
+			pending = true
+		}
+	}
+	if pending || len(parts) == 0 {
+		parts = append(parts, current.String())
 	}
 
-	// Convert SQL wildcards to regex
-	regexPattern = strings.ReplaceAll(regexPattern, "%", ".*")
-	regexPattern = strings.ReplaceAll(regexPattern, "_", ".")
+	return parts
+}
 
-	// Create anchored regex
-	regexPattern = "^" + regexPattern + "$"
+// scanBracketKey reads a bracketed key starting at the '[' at position start, returning
+// the key and the position of the closing ']'
+func scanBracketKey(fieldPath string, start int) (string, int, bool) {
+	pos := start + 1
+	if pos >= len(fieldPath) {
+		return "", 0, false
+	}
 
-	// Use regexp to match
-	match, err := regexp.MatchString(regexPattern, str)
-	if err != nil {
-		// Fallback to simple contains
-		return strings.Contains(str, strings.Trim(pattern, "%"))
+	quote := fieldPath[pos]
+	if quote != '\'' && quote != '"' {
+		// Unquoted key, read up to the closing bracket
+		end := strings.IndexByte(fieldPath[pos:], ']')
+		if end < 0 {
+			return "", 0, false
+		}
+		return fieldPath[pos : pos+end], pos + end, true
 	}
 
-	return match
-}
+	var key strings.Builder
+	for pos++; pos < len(fieldPath); pos++ {
+		if fieldPath[pos] != quote {
+			key.WriteByte(fieldPath[pos])
+			continue
+		}
+		// A doubled quote is an escaped quote
+		if pos+1 < len(fieldPath) && fieldPath[pos+1] == quote {
+			key.WriteByte(quote)
+			pos++
+			continue
+		}
+		if pos+1 < len(fieldPath) && fieldPath[pos+1] == ']' {
+			return key.String(), pos + 1, true
+		}
+		return "", 0, false
+	}
 
-// alwaysTrueCondition is a condition that always evaluates to true
-type alwaysTrueCondition struct{}
+	return "", 0, false
+}
 
-func (c *alwaysTrueCondition) evaluate(item any) bool {
-	return true
+// formatFieldPath joins field path parts with dots, bracket-quoting the parts that
+// can't be written as plain identifiers
+func formatFieldPath(parts []string) string {
+	var b strings.Builder
+	for i, part := range parts {
+		if isPlainFieldName(part) {
+			if i > 0 {
+				b.WriteByte('.')
+			}
+			b.WriteString(part)
+			continue
+		}
+		b.WriteString("['")
+		b.WriteString(strings.ReplaceAll(part, "'", "''"))
+		b.WriteString("']")
+	}
+	return b.String()
 }
 
-// isAlwaysTrueCondition safely checks if a condition is an alwaysTrueCondition
-func isAlwaysTrueCondition(cond Condition) bool {
-	// Type assertion with comma-ok idiom to safely check the type
-	_, ok := cond.(*alwaysTrueCondition)
-	return ok
+// isPlainFieldName reports whether a field path part can be written without brackets
+func isPlainFieldName(part string) bool {
+	if part == "" {
+		return false
+	}
+	for _, r := range part {
+		if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
 }
 
 // getFieldValue gets a field value from an item using reflection
-// Supports nested fields with dot notation (e.g., "user.name")
+// Supports nested fields with dot notation (e.g., "user.name") and bracketed
+// map keys (e.g., "labels['app.kubernetes.io/name']")
 func getFieldValue(item any, fieldPath string) (string, bool) {
+	field, found := resolveField(item, fieldPath)
+	if !found {
+		return "", false
+	}
+	return fmt.Sprintf("%v", field.Interface()), true
+}
+
+// resolveField walks a dotted field path through structs and string-keyed maps,
+// dereferencing pointers and interfaces along the way
+func resolveField(item any, fieldPath string) (reflect.Value, bool) {
 	value := reflect.ValueOf(item)
 
-	// If item is a pointer, dereference it
-	if value.Kind() == reflect.Ptr {
-		value = value.Elem()
+	// An empty path is the item itself, such as an element of a slice inside a quantifier
+	if fieldPath == "" {
+		return value, value.IsValid()
 	}
 
-	// If not struct, we can't get field values
-	if value.Kind() != reflect.Struct {
-		return "", false
-	}
+	fieldParts := splitFieldPath(fieldPath)
 
-	// Handle nested fields with dot notation
-	fieldParts := strings.Split(fieldPath, ".")
+	// Items that know their own fields, such as CSV rows, resolve the first part themselves
+	if source, ok := item.(fieldSource); ok {
+		fieldValue, found := source.fieldValue(fieldParts[0])
+		if !found || fieldValue == nil {
+			return reflect.Value{}, false
+		}
+		value = reflect.ValueOf(fieldValue)
+		fieldParts = fieldParts[1:]
+	}
 
-	for i, fieldName := range fieldParts {
-		// Get field by name (case-sensitive)
-		field := value.FieldByName(fieldName)
+	for _, fieldName := range fieldParts {
+		value = indirect(value)
 
-		// If field not found, try case-insensitive match
-		if !field.IsValid() {
-			field = findFieldCaseInsensitive(value, fieldName)
+		switch value.Kind() {
+		case reflect.Struct:
+			// Get field by name (case-sensitive), then fall back to a case-insensitive match
+			field := value.FieldByName(fieldName)
 			if !field.IsValid() {
-				return "", false
+				field = findFieldCaseInsensitive(value, fieldName)
 			}
+			value = field
+		case reflect.Map:
+			value = findMapKey(value, fieldName)
+		default:
+			// Neither a struct nor a map, we can't get field values
+			return reflect.Value{}, false
 		}
 
-		// If this is the last part, convert to string and return
-		if i == len(fieldParts)-1 {
-			return fmt.Sprintf("%v", field.Interface()), true
+		if !value.IsValid() {
+			return reflect.Value{}, false
 		}
+	}
 
-		// Otherwise, continue traversing
-		if field.Kind() == reflect.Ptr {
-			field = field.Elem()
-		}
+	return value, true
+}
 
-		if field.Kind() != reflect.Struct {
-			return "", false
+// fieldSource is implemented by items that resolve top-level field names without reflection
+type fieldSource interface {
+	fieldValue(name string) (any, bool)
+}
+
+// indirect dereferences pointers and interfaces until it reaches a concrete value
+func indirect(value reflect.Value) reflect.Value {
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return reflect.Value{}
 		}
+		value = value.Elem()
+	}
+	return value
+}
+
+// findMapKey looks up a key in a string-keyed map, falling back to a case-insensitive match.
+// When several keys match case-insensitively, e.g. "ENV" and "Env", the smallest one wins so
+// the result doesn't depend on the map's iteration order
+func findMapKey(value reflect.Value, key string) reflect.Value {
+	if value.Type().Key().Kind() != reflect.String {
+		return reflect.Value{}
+	}
 
-		value = field
+	if elem := value.MapIndex(reflect.ValueOf(key).Convert(value.Type().Key())); elem.IsValid() {
+		return elem
 	}
 
-	return "", false // Should not reach here
+	var match reflect.Value
+	iter := value.MapRange()
+	for iter.Next() {
+		if strings.EqualFold(iter.Key().String(), key) && (!match.IsValid() || iter.Key().String() < match.String()) {
+			match = iter.Key()
+		}
+	}
+	if !match.IsValid() {
+		return reflect.Value{} // Not found
+	}
+	return value.MapIndex(match)
 }
 
 // findFieldCaseInsensitive finds a field by name (case-insensitive)
@@ -603,71 +1247,18 @@ func findFieldCaseInsensitive(value reflect.Value, fieldName string) reflect.Val
 // AnyArrayContainsCondition checks if any element in an array field equals a value
 type AnyArrayContainsCondition struct {
 	field string
-	value string
+	value Literal
 }
 
 // getArrayFieldValues gets an array/slice field's values as strings
 func getArrayFieldValues(item any, fieldPath string) ([]string, bool) {
-	value := reflect.ValueOf(item)
-
-	// If item is a pointer, dereference it
-	if value.Kind() == reflect.Ptr {
-		value = value.Elem()
-	}
-
-	// If not struct, we can't get field values
-	if value.Kind() != reflect.Struct {
+	arrayField, found := resolveField(item, fieldPath)
+	if !found {
 		return nil, false
 	}
 
-	// Handle nested fields with dot notation
-	fieldParts := strings.Split(fieldPath, ".")
-	for _, fieldName := range fieldParts[:len(fieldParts)-1] {
-		// Get field by name (case-sensitive)
-		field := value.FieldByName(fieldName)
-
-		// If field not found, try case-insensitive match
-		if !field.IsValid() {
-			field = findFieldCaseInsensitive(value, fieldName)
-			if !field.IsValid() {
-				return nil, false
-			}
-		}
-
-		// Dereference pointer if needed
-		if field.Kind() == reflect.Ptr {
-			if field.IsNil() {
-				return nil, false
-			}
-			field = field.Elem()
-		}
-
-		if field.Kind() != reflect.Struct {
-			return nil, false
-		}
-
-		value = field
-	}
-
-	// Get the array/slice field
-	lastField := fieldParts[len(fieldParts)-1]
-	arrayField := value.FieldByName(lastField)
-
-	// If field not found, try case-insensitive match
-	if !arrayField.IsValid() {
-		arrayField = findFieldCaseInsensitive(value, lastField)
-		if !arrayField.IsValid() {
-			return nil, false
-		}
-	}
-
 	// Dereference pointer if needed
-	if arrayField.Kind() == reflect.Ptr {
-		if arrayField.IsNil() {
-			return nil, false
-		}
-		arrayField = arrayField.Elem()
-	}
+	arrayField = indirect(arrayField)
 
 	// Check if it's an array or slice
 	if arrayField.Kind() != reflect.Array && arrayField.Kind() != reflect.Slice {
@@ -702,7 +1293,7 @@ func getArrayFieldValues(item any, fieldPath string) ([]string, bool) {
 // AnyArrayNotContainsCondition checks if no element in an array field equals a value
 type AnyArrayNotContainsCondition struct {
 	field string
-	value string
+	value Literal
 }
 
 func (c *AnyArrayNotContainsCondition) evaluate(item any) bool {
@@ -715,13 +1306,13 @@ func (c *AnyArrayNotContainsCondition) evaluate(item any) bool {
 		return true // Empty array doesn't contain anything
 	}
 
-	return !slices.Contains(values, c.value) // No matches found
+	return !slices.Contains(values, c.value.text) // No matches found
 }
 
 // AnyArrayContainsAnyCondition checks if any element in an array field equals any of the provided values
 type AnyArrayContainsAnyCondition struct {
 	field  string
-	values []string
+	values []Literal
 }
 
 func (c *AnyArrayContainsAnyCondition) evaluate(item any) bool {
@@ -735,17 +1326,22 @@ func (c *AnyArrayContainsAnyCondition) evaluate(item any) bool {
 	}
 
 	for _, fieldVal := range fieldValues {
-		if slices.Contains(c.values, fieldVal) {
+		if containsLiteral(c.values, fieldVal) {
 			return true
 		}
 	}
 	return false
 }
 
+// containsLiteral reports whether any of the literals has the given text
+func containsLiteral(values []Literal, text string) bool {
+	return slices.ContainsFunc(values, func(value Literal) bool { return value.text == text })
+}
+
 // AnyArrayNotContainsAnyCondition checks if no element in an array field equals any of the provided values
 type AnyArrayNotContainsAnyCondition struct {
 	field  string
-	values []string
+	values []Literal
 }
 
 func (c *AnyArrayNotContainsAnyCondition) evaluate(item any) bool {
@@ -759,86 +1355,137 @@ func (c *AnyArrayNotContainsAnyCondition) evaluate(item any) bool {
 	}
 
 	for _, fieldVal := range fieldValues {
-		if slices.Contains(c.values, fieldVal) {
+		if containsLiteral(c.values, fieldVal) {
 			return false // Found a match, so the NOT condition fails
 		}
 	}
 	return true // No matches found
 }
 
-// preprocessAnyOperator processes filter strings containing ANY operators before sending to SQL parser
-// It returns a modified filter string with placeholders for ANY operations, and a list of ANY conditions
-func preprocessAnyOperator(filter string) (string, []Condition, error) {
-	// Regular expressions to identify ANY patterns
-	// Note: In Go, we need to use double backslashes in regex for literal backslash
-	// Original patterns had issues with capture groups - fixed to work with our test cases
-	anyFieldRegex := regexp.MustCompile(`ANY\(([\w\.]+)\)\s*(=|!=)\s*('[^']+'|\w+)`)
-	anyFieldAnyValueRegex := regexp.MustCompile(`ANY\(([\w\.]+)\)\s*(=|!=)\s*ANY\(([^)]*)\)`)
-
-	var anyConditions []Condition
-
-	// Replace ANY(field) = ANY(...) pattern first, as it's more specific
-	anyFieldAnyValueMatches := anyFieldAnyValueRegex.FindAllStringSubmatch(filter, -1)
-	for _, match := range anyFieldAnyValueMatches {
-		if len(match) >= 4 { // We need at least 4 elements
-			fullMatch := match[0] // Complete match
-			fieldName := match[1] // The field name
-			operator := match[2]  // = or !=
-			valuesStr := match[3] // The ANY(...) values
-
-			// Parse the values inside ANY(...)
-			values := parseAnyValues(valuesStr)
-
-			// Create appropriate condition based on operator
-			var condition Condition
-			if operator == "=" {
-				condition = &AnyArrayContainsAnyCondition{field: fieldName, values: values}
-			} else { // !=
-				condition = &AnyArrayNotContainsAnyCondition{field: fieldName, values: values}
-			}
+// rewriteAnyOperators rewrites ANY(field) on the left side of a comparison and the
+// ANY(field, predicate) and ALL(field, predicate) quantifiers into calls to the quoted "any"
+// and "all" functions, which the SQL parser accepts because quoted names aren't treated as
+// keywords. The visitor then sees them as regular nodes of the parsed expression, so they
+// nest under AND, OR and NOT like any other condition. Quoted strings and identifiers are
+// left untouched, as are ANY(...) and ALL(...) on the right side of a comparison
+func rewriteAnyOperators(filter string) string {
+	var b strings.Builder
+	var previous byte // Last significant character outside quotes
+
+	for i := 0; i < len(filter); i++ {
+		ch := filter[i]
+
+		// Copy quoted strings and identifiers verbatim
+		if ch == '\'' || ch == '"' {
+			end := skipQuoted(filter, i)
+			b.WriteString(filter[i:end])
+			i = end - 1
+			previous = ch
+			continue
+		}
 
-			// Add the condition to our list
-			anyConditions = append(anyConditions, condition)
+		if keyword, ok := anyOperatorAt(filter, i, previous); ok {
+			b.WriteString(`"` + keyword + `"`)
+			i += len(keyword) - 1
+			previous = '"'
+			continue
+		}
 
-			// Replace the ANY expression with a placeholder in the filter string
-			// This allows the SQL parser to work while we handle ANY conditions separately
-			// For a complex filter like "ANY(Skills) = 'coding' AND Active = true", we need to ensure
-			// that we don't break the structure by replacing parts of it
-			filter = strings.Replace(filter, fullMatch, "true", 1)
+		b.WriteByte(ch)
+		if ch != ' ' && ch != '\t' && ch != '\n' && ch != '\r' {
+			previous = ch
 		}
 	}
 
-	// Then replace the simpler ANY(field) = 'value' pattern
-	anyFieldMatches := anyFieldRegex.FindAllStringSubmatch(filter, -1)
-	for _, match := range anyFieldMatches {
-		if len(match) >= 4 { // We need at least 4 elements
-			fullMatch := match[0] // Complete match
-			fieldName := match[1] // The field name
-			operator := match[2]  // = or !=
-			valueStr := match[3]  // The value to match
+	return b.String()
+}
 
-			// Remove quotes if present
-			if strings.HasPrefix(valueStr, "'") && strings.HasSuffix(valueStr, "'") {
-				valueStr = valueStr[1 : len(valueStr)-1]
-			}
+// anyOperatorAt reports whether the ANY or ALL keyword at position i should be rewritten,
+// that is, it is not on the right side of a comparison and is followed by a field path and
+// then a comma (a quantifier) or, for ANY only, a closing parenthesis (ANY(field) = ...)
+func anyOperatorAt(filter string, i int, previous byte) (string, bool) {
+	if i+3 > len(filter) || (i > 0 && isIdentChar(filter[i-1])) {
+		return "", false
+	}
+	keyword := strings.ToLower(filter[i : i+3])
+	if keyword != "any" && keyword != "all" {
+		return "", false
+	}
+	if strings.ContainsRune("=<>!", rune(previous)) {
+		return "", false
+	}
+
+	pos := skipSpaces(filter, i+3)
+	if pos >= len(filter) || filter[pos] != '(' {
+		return "", false
+	}
 
-			// Create appropriate condition based on operator
-			var condition Condition
-			if operator == "=" {
-				condition = &AnyArrayContainsCondition{field: fieldName, value: valueStr}
-			} else { // !=
-				condition = &AnyArrayNotContainsCondition{field: fieldName, value: valueStr}
+	// The first argument must be a field path
+	pos = skipSpaces(filter, pos+1)
+	start := pos
+	for pos < len(filter) && (isIdentChar(filter[pos]) || filter[pos] == '.' || filter[pos] == '"' || filter[pos] == '[') {
+		switch filter[pos] {
+		case '"':
+			pos = skipQuoted(filter, pos)
+		case '[':
+			end := strings.IndexByte(filter[pos:], ']')
+			if end < 0 {
+				return "", false
 			}
+			// Skip over quoted keys, which may contain brackets
+			if pos+1 < len(filter) && filter[pos+1] == '\'' {
+				pos = skipQuoted(filter, pos+1)
+				if pos >= len(filter) || filter[pos] != ']' {
+					return "", false
+				}
+				pos++
+			} else {
+				pos += end + 1
+			}
+		default:
+			pos++
+		}
+	}
+	if pos == start || !(filter[start] == '_' || filter[start] == '"' || unicode.IsLetter(rune(filter[start]))) {
+		return "", false
+	}
 
-			// Add the condition to our list
-			anyConditions = append(anyConditions, condition)
+	pos = skipSpaces(filter, pos)
+	if pos >= len(filter) || (filter[pos] != ',' && (filter[pos] != ')' || keyword != "any")) {
+		return "", false
+	}
+
+	return keyword, true
+}
 
-			// Replace the ANY expression with a placeholder in the filter string
-			filter = strings.Replace(filter, fullMatch, "true", 1)
+// skipQuoted returns the position just past the quoted string or identifier starting at
+// position start. Doubled quotes inside are treated as escaped quotes
+func skipQuoted(filter string, start int) int {
+	quote := filter[start]
+	for pos := start + 1; pos < len(filter); pos++ {
+		if filter[pos] != quote {
+			continue
 		}
+		if pos+1 < len(filter) && filter[pos+1] == quote {
+			pos++
+			continue
+		}
+		return pos + 1
 	}
+	return len(filter)
+}
 
-	return filter, anyConditions, nil
+// skipSpaces returns the position of the first non-whitespace character at or after pos
+func skipSpaces(filter string, pos int) int {
+	for pos < len(filter) && (filter[pos] == ' ' || filter[pos] == '\t' || filter[pos] == '\n' || filter[pos] == '\r') {
+		pos++
+	}
+	return pos
+}
+
+// isIdentChar reports whether a character can be part of an unquoted identifier
+func isIdentChar(ch byte) bool {
+	return ch == '_' || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' || ch >= 0x80
 }
 
 // Implementation of the evaluate method for AnyArrayContainsCondition
@@ -855,80 +1502,5 @@ func (c *AnyArrayContainsCondition) evaluate(item any) bool {
 		return false
 	}
 
-	for _, v := range values {
-		log.Printf("Comparing '%s' with '%s'", v, c.value)
-		if v == c.value {
-			log.Printf("Match found!")
-			return true
-		}
-	}
-	return false
-}
-
-// parseAnyValues parses values from an ANY(...) expression
-func parseAnyValues(valuesStr string) []string {
-	var values []string
-
-	// Split by commas, but handle quoted strings properly
-	tokenizer := strings.NewReader(valuesStr)
-	scanner := bufio.NewScanner(tokenizer)
-	scanner.Split(func(data []byte, atEOF bool) (advance int, token []byte, err error) {
-		if atEOF && len(data) == 0 {
-			return 0, nil, nil
-		}
-
-		// Skip whitespace and commas
-		start := 0
-		for ; start < len(data) && (data[start] == ' ' || data[start] == '\t' || data[start] == ','); start++ {
-		}
-
-		if start >= len(data) {
-			return len(data), nil, nil
-		}
-
-		// Handle quoted string
-		if data[start] == '\'' {
-			start++ // Skip opening quote
-			pos := start
-			for ; pos < len(data) && data[pos] != '\''; pos++ {
-			}
-			if pos >= len(data) {
-				if atEOF {
-					return len(data), data[start:pos], nil
-				}
-				return 0, nil, nil // Need more data
-			}
-			return pos + 1, data[start:pos], nil // +1 to consume the closing quote
-		}
-
-		// Non-quoted value
-		pos := start
-		for ; pos < len(data) && data[pos] != ','; pos++ {
-		}
-		return pos, data[start:pos], nil
-	})
-
-	for scanner.Scan() {
-		val := scanner.Text()
-		if val = strings.TrimSpace(val); val != "" {
-			values = append(values, val)
-		}
-	}
-
-	if len(values) == 0 {
-		// Fallback method if the scanner didn't work
-		rawValues := strings.SplitSeq(valuesStr, ",")
-		for v := range rawValues {
-			v = strings.TrimSpace(v)
-			// Remove surrounding quotes if present
-			if strings.HasPrefix(v, "'") && strings.HasSuffix(v, "'") {
-				v = v[1 : len(v)-1]
-			}
-			if v != "" {
-				values = append(values, v)
-			}
-		}
-	}
-
-	return values
+	return slices.Contains(values, c.value.text)
 }
@@ -15,8 +15,10 @@
 package rql
 
 import (
+	"encoding/json"
 	"log"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -32,9 +34,9 @@ type TestPerson struct {
 	Age        int
 	Active     bool
 	Department *Department
-	Tags       []string   // Array field for ANY operator tests
-	Skills     []string   // Another array field
-	Scores     []int      // Array of integers
+	Tags       []string      // Array field for ANY operator tests
+	Skills     []string      // Another array field
+	Scores     []int         // Array of integers
 	Metadata   *TestMetadata // Nested object with array
 }
 
@@ -47,40 +49,43 @@ type TestMetadata struct {
 	Categories []string
 }
 
+// testPeople are the fixtures shared by the filtering tests
+var testPeople = []TestPerson{
+	{ID: 1, Name: "Alice", Email: "alice@example.com", Age: 25, Active: true,
+		Department: &Department{Name: "Engineering", Location: "Building A"},
+		Tags:       []string{"frontend", "javascript", "react"},
+		Skills:     []string{"coding", "design"},
+		Scores:     []int{90, 85, 88},
+		Metadata:   &TestMetadata{Categories: []string{"developer", "ui"}}},
+	{ID: 2, Name: "Bob", Email: "bob@example.com", Age: 30, Active: true,
+		Department: &Department{Name: "Engineering", Location: "Building B"},
+		Tags:       []string{"backend", "python", "go"},
+		Skills:     []string{"coding", "architecture"},
+		Scores:     []int{95, 92, 99},
+		Metadata:   &TestMetadata{Categories: []string{"developer", "backend"}}},
+	{ID: 3, Name: "Charlie", Email: "charlie@example.com", Age: 35, Active: false,
+		Department: &Department{Name: "Marketing", Location: "Building C"},
+		Tags:       []string{"content", "strategy"},
+		Skills:     []string{"writing", "analysis"},
+		Scores:     []int{85, 80, 90},
+		Metadata:   &TestMetadata{Categories: []string{"marketing", "content"}}},
+	{ID: 4, Name: "Dave", Email: "dave@alternative.com", Age: 40, Active: true,
+		Department: &Department{Name: "Sales", Location: "Building D"},
+		Tags:       []string{"enterprise", "relationships"},
+		Skills:     []string{"negotiation", "presentation"},
+		Scores:     []int{88, 92, 87},
+		Metadata:   &TestMetadata{Categories: []string{"sales", "account-management"}}},
+	{ID: 5, Name: "Eve", Email: "eve@example.com", Age: 45, Active: false,
+		Department: &Department{Name: "HR", Location: "Building E"},
+		Tags:       []string{"recruiting", "training"},
+		Skills:     []string{"interviewing", "policy"},
+		Scores:     []int{91, 85, 89},
+		Metadata:   &TestMetadata{Categories: []string{"hr", "people"}}},
+}
+
 func TestApplyFilter(t *testing.T) {
 	// Test data
-	people := []TestPerson{
-		{ID: 1, Name: "Alice", Email: "alice@example.com", Age: 25, Active: true, 
-			Department: &Department{Name: "Engineering", Location: "Building A"}, 
-			Tags: []string{"frontend", "javascript", "react"}, 
-			Skills: []string{"coding", "design"}, 
-			Scores: []int{90, 85, 88},
-			Metadata: &TestMetadata{Categories: []string{"developer", "ui"}}},
-		{ID: 2, Name: "Bob", Email: "bob@example.com", Age: 30, Active: true, 
-			Department: &Department{Name: "Engineering", Location: "Building B"}, 
-			Tags: []string{"backend", "python", "go"}, 
-			Skills: []string{"coding", "architecture"}, 
-			Scores: []int{95, 92, 99},
-			Metadata: &TestMetadata{Categories: []string{"developer", "backend"}}},
-		{ID: 3, Name: "Charlie", Email: "charlie@example.com", Age: 35, Active: false, 
-			Department: &Department{Name: "Marketing", Location: "Building C"}, 
-			Tags: []string{"content", "strategy"}, 
-			Skills: []string{"writing", "analysis"}, 
-			Scores: []int{85, 80, 90},
-			Metadata: &TestMetadata{Categories: []string{"marketing", "content"}}},
-		{ID: 4, Name: "Dave", Email: "dave@alternative.com", Age: 40, Active: true, 
-			Department: &Department{Name: "Sales", Location: "Building D"}, 
-			Tags: []string{"enterprise", "relationships"}, 
-			Skills: []string{"negotiation", "presentation"}, 
-			Scores: []int{88, 92, 87},
-			Metadata: &TestMetadata{Categories: []string{"sales", "account-management"}}},
-		{ID: 5, Name: "Eve", Email: "eve@example.com", Age: 45, Active: false, 
-			Department: &Department{Name: "HR", Location: "Building E"}, 
-			Tags: []string{"recruiting", "training"}, 
-			Skills: []string{"interviewing", "policy"}, 
-			Scores: []int{91, 85, 89},
-			Metadata: &TestMetadata{Categories: []string{"hr", "people"}}},
-	}
+	people := testPeople
 
 	tests := []struct {
 		name      string
@@ -110,6 +115,13 @@ func TestApplyFilter(t *testing.T) {
 			wantIDs:   []int{2, 3, 4, 5},
 			wantErr:   false,
 		},
+		{
+			name:      "numeric equality ignores formatting",
+			filter:    "Age = 30.0 OR Age = '4.5e1'",
+			wantCount: 2,
+			wantIDs:   []int{2, 5},
+			wantErr:   false,
+		},
 		{
 			name:      "filter by active status",
 			filter:    "Active=true",
@@ -594,3 +606,577 @@ func TestMsgPack(t *testing.T) {
 		})
 	}
 }
+
+// TestSortOptions tests ordering results with FilterOptions.Sort before pagination
+func TestSortOptions(t *testing.T) {
+	people := []TestPerson{
+		{ID: 1, Name: "Alice", Age: 30, Department: &Department{Name: "Engineering"}},
+		{ID: 2, Name: "Bob", Age: 9},
+		{ID: 3, Name: "Charlie", Age: 30, Department: &Department{Name: "Marketing"}},
+		{ID: 4, Name: "Dave", Age: 100, Department: &Department{Name: "Sales"}},
+	}
+
+	tests := []struct {
+		name    string
+		filter  string
+		options FilterOptions
+		wantIDs []int
+	}{
+		{
+			name:    "numeric ascending",
+			options: FilterOptions{Sort: []string{"Age"}},
+			wantIDs: []int{2, 1, 3, 4},
+		},
+		{
+			name:    "descending with tie breaker",
+			options: FilterOptions{Sort: []string{"-age", "-name"}},
+			wantIDs: []int{4, 3, 1, 2},
+		},
+		{
+			name:    "missing nested values sort last",
+			options: FilterOptions{Sort: []string{"-Department.Name"}},
+			wantIDs: []int{4, 3, 1, 2},
+		},
+		{
+			name:    "sort before pagination",
+			filter:  "Age >= 30",
+			options: FilterOptions{Sort: []string{"-Age"}, Limit: 2},
+			wantIDs: []int{4, 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ApplyFilter(tt.filter, people, tt.options)
+			if err != nil {
+				t.Fatalf("ApplyFilter() unexpected error = %v", err)
+			}
+
+			gotIDs := make([]int, 0, len(result.Items))
+			for _, person := range result.Items {
+				gotIDs = append(gotIDs, person.ID)
+			}
+
+			if !reflect.DeepEqual(gotIDs, tt.wantIDs) {
+				t.Errorf("ApplyFilter() got IDs = %v, want %v", gotIDs, tt.wantIDs)
+			}
+		})
+	}
+
+	// Sorting must not reorder the caller's slice
+	if people[0].ID != 1 || people[1].ID != 2 {
+		t.Errorf("ApplyFilter() reordered the input slice")
+	}
+}
+
+// TestMapItems tests filtering items that are maps, such as decoded JSON documents
+func TestMapItems(t *testing.T) {
+	items := []map[string]any{
+		{"id": 1, "name": "Alice", "profile": map[string]any{"city": "Lima"}, "tags": []any{"a", "b"}},
+		{"id": 2, "name": "Bob", "profile": map[string]any{"city": "Quito"}, "tags": []any{"c"}},
+		{"id": 3, "Name": "Carol"},
+	}
+
+	tests := []struct {
+		filter  string
+		wantIDs []int
+	}{
+		{filter: "name = 'Alice'", wantIDs: []int{1}},
+		{filter: "Name = 'Carol'", wantIDs: []int{3}},
+		{filter: "Profile.City = 'Quito'", wantIDs: []int{2}},
+		{filter: "ANY(tags) = 'b'", wantIDs: []int{1}},
+		{filter: "id >= 2", wantIDs: []int{2, 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			result, err := ApplyFilter(tt.filter, items)
+			if err != nil {
+				t.Fatalf("ApplyFilter() unexpected error = %v", err)
+			}
+
+			gotIDs := make([]int, 0, len(result.Items))
+			for _, item := range result.Items {
+				gotIDs = append(gotIDs, item["id"].(int))
+			}
+
+			if !reflect.DeepEqual(gotIDs, tt.wantIDs) {
+				t.Errorf("ApplyFilter() got IDs = %v, want %v", gotIDs, tt.wantIDs)
+			}
+		})
+	}
+}
+
+// TestNumericEquality tests that numeric fields compare by value with number and quoted number
+// literals, while string fields and non-numeric literals compare as text
+func TestNumericEquality(t *testing.T) {
+	type reading struct {
+		ID    int
+		Code  string
+		Age   int
+		Count uint8
+		Ratio float64
+	}
+	items := []reading{
+		{ID: 1, Code: "007", Age: 30, Count: 3, Ratio: 0.5},
+		{ID: 2, Code: "7", Age: 45, Count: 0, Ratio: 1.25},
+	}
+
+	tests := []struct {
+		filter  string
+		wantIDs []int
+	}{
+		{filter: "Age = 30", wantIDs: []int{1}},
+		{filter: "Age = 30.0", wantIDs: []int{1}},
+		{filter: "Age = '30'", wantIDs: []int{1}},
+		{filter: "Age = '30.00'", wantIDs: []int{1}},
+		{filter: "Age = '4.5e1'", wantIDs: []int{2}},
+		{filter: "Age != '3e1'", wantIDs: []int{2}},
+		{filter: "Age = 30.5", wantIDs: []int{}},
+		{filter: "Age = 'thirty'", wantIDs: []int{}},
+		{filter: "Count = '3.0'", wantIDs: []int{1}},
+		{filter: "Ratio = 0.50", wantIDs: []int{1}},
+		{filter: "Ratio = '125e-2'", wantIDs: []int{2}},
+		{filter: "Code = 7", wantIDs: []int{2}},
+		{filter: "Code = '007'", wantIDs: []int{1}},
+		{filter: "Code = 7.0", wantIDs: []int{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			result, err := ApplyFilter(tt.filter, items)
+			if err != nil {
+				t.Fatalf("ApplyFilter() unexpected error = %v", err)
+			}
+
+			gotIDs := make([]int, 0, len(result.Items))
+			for _, item := range result.Items {
+				gotIDs = append(gotIDs, item.ID)
+			}
+
+			if !reflect.DeepEqual(gotIDs, tt.wantIDs) {
+				t.Errorf("ApplyFilter() got IDs = %v, want %v", gotIDs, tt.wantIDs)
+			}
+		})
+	}
+}
+
+// TestJSONNumbers tests filtering and sorting numbers decoded with json.Decoder.UseNumber,
+// including integers above 2^53 that a float64 can't tell apart
+func TestJSONNumbers(t *testing.T) {
+	decoder := json.NewDecoder(strings.NewReader(`[
+		{"id": 9007199254740992, "score": 40},
+		{"id": 9007199254740993, "score": 40.5},
+		{"id": 9007199254740994, "score": 39}
+	]`))
+	decoder.UseNumber()
+	var items []map[string]any
+	if err := decoder.Decode(&items); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		filter  string
+		sort    []string
+		wantIDs []string
+	}{
+		{filter: "id = 9007199254740993", wantIDs: []string{"9007199254740993"}},
+		{filter: "id != 9007199254740993", wantIDs: []string{"9007199254740992", "9007199254740994"}},
+		{filter: "id > 9007199254740992", wantIDs: []string{"9007199254740993", "9007199254740994"}},
+		{filter: "id <= 9007199254740993", wantIDs: []string{"9007199254740992", "9007199254740993"}},
+		{filter: "score = 40.0", wantIDs: []string{"9007199254740992"}},
+		{filter: "score > 40", wantIDs: []string{"9007199254740993"}},
+		{sort: []string{"-id"}, wantIDs: []string{"9007199254740994", "9007199254740993", "9007199254740992"}},
+		{filter: "id < 9007199254740994", sort: []string{"-id"}, wantIDs: []string{"9007199254740993", "9007199254740992"}},
+		{sort: []string{"score"}, wantIDs: []string{"9007199254740994", "9007199254740992", "9007199254740993"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.TrimSpace(tt.filter+" "+strings.Join(tt.sort, ",")), func(t *testing.T) {
+			result, err := ApplyFilter(tt.filter, items, FilterOptions{Sort: tt.sort})
+			if err != nil {
+				t.Fatalf("ApplyFilter() unexpected error = %v", err)
+			}
+
+			gotIDs := make([]string, 0, len(result.Items))
+			for _, item := range result.Items {
+				gotIDs = append(gotIDs, item["id"].(json.Number).String())
+			}
+
+			if !reflect.DeepEqual(gotIDs, tt.wantIDs) {
+				t.Errorf("ApplyFilter() got IDs = %v, want %v", gotIDs, tt.wantIDs)
+			}
+		})
+	}
+}
+
+type TestResource struct {
+	Name        string
+	Labels      map[string]string
+	Annotations map[string]any
+}
+
+// TestMapFields tests map key access with dot and bracket syntax, and key existence checks
+func TestMapFields(t *testing.T) {
+	resources := []TestResource{
+		{Name: "api", Labels: map[string]string{"env": "prod", "app.kubernetes.io/name": "api"},
+			Annotations: map[string]any{"owner": map[string]any{"team": "platform"}, "replicas": 3}},
+		{Name: "web", Labels: map[string]string{"env": "staging", "app.kubernetes.io/name": "web", "it's": "quoted"},
+			Annotations: map[string]any{"owner": nil}},
+		{Name: "batch"},
+	}
+
+	tests := []struct {
+		name      string
+		filter    string
+		wantNames []string
+	}{
+		{name: "dot syntax", filter: "Labels.env = 'prod'", wantNames: []string{"api"}},
+		{name: "bracket syntax with dots in key", filter: "Labels['app.kubernetes.io/name'] = 'web'", wantNames: []string{"web"}},
+		{name: "bracket syntax with quote in key", filter: "Labels['it''s'] = 'quoted'", wantNames: []string{"web"}},
+		{name: "nested maps", filter: "Annotations.owner.team = 'platform'", wantNames: []string{"api"}},
+		{name: "mixed syntax", filter: "Annotations['owner']['team'] LIKE 'plat%'", wantNames: []string{"api"}},
+		{name: "numeric map values", filter: "Annotations.replicas > 2", wantNames: []string{"api"}},
+		{name: "missing key", filter: "Labels.tier = 'x'", wantNames: []string{}},
+		{name: "key existence operator", filter: "Labels ? 'env'", wantNames: []string{"api", "web"}},
+		{name: "key existence with dots", filter: "Labels ? 'app.kubernetes.io/name' AND Name != 'api'", wantNames: []string{"web"}},
+		{name: "has function", filter: "has(Labels.env)", wantNames: []string{"api", "web"}},
+		{name: "has function with brackets", filter: "has(Labels['it''s'])", wantNames: []string{"web"}},
+		{name: "has is false for nil values", filter: "has(Annotations.owner)", wantNames: []string{"api"}},
+		{name: "has on nested key", filter: "has(Annotations.owner.team) OR Name = 'batch'", wantNames: []string{"api", "batch"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ApplyFilter(tt.filter, resources)
+			if err != nil {
+				t.Fatalf("ApplyFilter() unexpected error = %v", err)
+			}
+
+			gotNames := make([]string, 0, len(result.Items))
+			for _, resource := range result.Items {
+				gotNames = append(gotNames, resource.Name)
+			}
+
+			if !reflect.DeepEqual(gotNames, tt.wantNames) {
+				t.Errorf("ApplyFilter() got names = %v, want %v", gotNames, tt.wantNames)
+			}
+		})
+	}
+}
+
+// TestMapKeyCase tests that map keys differing only in case resolve deterministically: an
+// exact match first, then the smallest key matching case-insensitively
+func TestMapKeyCase(t *testing.T) {
+	items := []map[string]any{
+		{"id": 1, "labels": map[string]string{"tIER": "c", "Tier": "b", "TIER": "a", "TiEr": "d"}},
+	}
+
+	tests := []struct {
+		filter    string
+		wantCount int
+	}{
+		{filter: "labels.TIER = 'a'", wantCount: 1},
+		{filter: `labels."Tier" = 'b'`, wantCount: 1},
+		{filter: "labels['tIER'] = 'c'", wantCount: 1},
+		{filter: "labels['tier'] = 'a'", wantCount: 1},
+		{filter: "labels['tier'] != 'a'", wantCount: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			// Map iteration order is random, so a nondeterministic lookup would fail some runs
+			for range 20 {
+				result, err := ApplyFilter(tt.filter, items)
+				if err != nil {
+					t.Fatalf("ApplyFilter() unexpected error = %v", err)
+				}
+				if result.Count != tt.wantCount {
+					t.Fatalf("ApplyFilter() count = %d, want %d", result.Count, tt.wantCount)
+				}
+			}
+		})
+	}
+}
+
+func TestSplitFieldPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{path: "Name", want: []string{"Name"}},
+		{path: "Department.Name", want: []string{"Department", "Name"}},
+		{path: "Labels['app.kubernetes.io/name']", want: []string{"Labels", "app.kubernetes.io/name"}},
+		{path: "Labels[\"env\"].value", want: []string{"Labels", "env", "value"}},
+		{path: "a['it''s']['x']", want: []string{"a", "it's", "x"}},
+		{path: "Labels[env]", want: []string{"Labels", "env"}},
+		{path: "Labels['open", want: []string{"Labels", "['open"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got := splitFieldPath(tt.path)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitFieldPath() = %q, want %q", got, tt.want)
+			}
+			if tt.path != "Labels['open" && !reflect.DeepEqual(splitFieldPath(formatFieldPath(got)), got) {
+				t.Errorf("formatFieldPath() = %q does not round-trip", formatFieldPath(got))
+			}
+		})
+	}
+}
+
+type TestCustomer struct {
+	ID     int
+	Orders []TestOrder
+	Notes  *[]string
+}
+
+type TestOrder struct {
+	Status  string
+	Total   float64
+	Shipped bool
+	Items   []TestOrderItem
+}
+
+type TestOrderItem struct {
+	SKU      string
+	Quantity int
+}
+
+// TestQuantifiers tests ANY and ALL quantifiers with nested predicates over slices of structs
+func TestQuantifiers(t *testing.T) {
+	customers := []TestCustomer{
+		{ID: 1, Orders: []TestOrder{
+			{Status: "paid", Total: 150, Shipped: true, Items: []TestOrderItem{{SKU: "A", Quantity: 1}}},
+			{Status: "pending", Total: 20, Shipped: false},
+		}},
+		{ID: 2, Orders: []TestOrder{
+			{Status: "paid", Total: 50, Shipped: true, Items: []TestOrderItem{{SKU: "B", Quantity: 5}}},
+			{Status: "paid", Total: 200, Shipped: true, Items: []TestOrderItem{{SKU: "A", Quantity: 3}}},
+		}},
+		{ID: 3, Orders: []TestOrder{}},
+		{ID: 4}, // nil slice
+	}
+
+	tests := []struct {
+		name    string
+		filter  string
+		wantIDs []int
+	}{
+		{
+			name:    "ANY with compound predicate",
+			filter:  "ANY(Orders, Status = 'paid' AND Total > 100)",
+			wantIDs: []int{1, 2},
+		},
+		{
+			name:    "ANY predicate must hold for a single element",
+			filter:  "ANY(Orders, Status = 'pending' AND Shipped = true)",
+			wantIDs: []int{},
+		},
+		{
+			name:    "ALL is vacuously true for empty and nil slices",
+			filter:  "ALL(Orders, Shipped = true)",
+			wantIDs: []int{2, 3, 4},
+		},
+		{
+			name:    "ANY is false for empty and nil slices",
+			filter:  "ANY(Orders, Total >= 0)",
+			wantIDs: []int{1, 2},
+		},
+		{
+			name:    "lower-case keywords combined with other conditions",
+			filter:  "all(Orders, Status = 'paid') AND any(Orders, Total > 100)",
+			wantIDs: []int{2},
+		},
+		{
+			name:    "quantifier inside OR",
+			filter:  "ID = 3 OR ANY(Orders, Status = 'pending')",
+			wantIDs: []int{1, 3},
+		},
+		{
+			name:    "nested quantifiers",
+			filter:  "ANY(Orders, ANY(Items, SKU = 'A' AND Quantity > 2))",
+			wantIDs: []int{2},
+		},
+		{
+			name:    "quoted strings are not rewritten",
+			filter:  "ANY(Orders, Status = 'ANY(Orders, x)')",
+			wantIDs: []int{},
+		},
+		{
+			name:    "nil pointer to a slice",
+			filter:  "ALL(Notes, Status = 'x')",
+			wantIDs: []int{1, 2, 3, 4},
+		},
+		{
+			name:    "non-slice field",
+			filter:  "ANY(ID, ID = 1)",
+			wantIDs: []int{},
+		},
+		{
+			name:    "missing field",
+			filter:  "ALL(Missing, Status = 'paid')",
+			wantIDs: []int{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ApplyFilter(tt.filter, customers)
+			if err != nil {
+				t.Fatalf("ApplyFilter() unexpected error = %v", err)
+			}
+
+			gotIDs := make([]int, 0, len(result.Items))
+			for _, customer := range result.Items {
+				gotIDs = append(gotIDs, customer.ID)
+			}
+
+			if !reflect.DeepEqual(gotIDs, tt.wantIDs) {
+				t.Errorf("ApplyFilter() got IDs = %v, want %v", gotIDs, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func TestRewriteAnyOperators(t *testing.T) {
+	tests := []struct {
+		filter string
+		want   string
+	}{
+		{filter: "ANY(Orders, Total > 1)", want: `"any"(Orders, Total > 1)`},
+		{filter: "all ( Orders , Shipped = true)", want: `"all" ( Orders , Shipped = true)`},
+		{filter: "ANY(Labels['a,b'], x = 1)", want: `"any"(Labels['a,b'], x = 1)`},
+		{filter: "ANY(Tags) = 'go'", want: `"any"(Tags) = 'go'`},
+		{filter: "ANY(Tags) = ANY(python, content)", want: `"any"(Tags) = ANY(python, content)`},
+		{filter: "ANY(Tags) = ANY('python', 'content')", want: `"any"(Tags) = ANY('python', 'content')`},
+		{filter: "'go' = ANY(Tags) AND 'x' <> ALL(Tags)", want: "'go' = ANY(Tags) AND 'x' <> ALL(Tags)"},
+		{filter: "ALL(Tags) = 'go'", want: "ALL(Tags) = 'go'"},
+		{filter: "Name = 'ANY(Orders, x)'", want: "Name = 'ANY(Orders, x)'"},
+		{filter: "company(a, b)", want: "company(a, b)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			if got := rewriteAnyOperators(tt.filter); got != tt.want {
+				t.Errorf("rewriteAnyOperators() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestAnyOperatorGrammar tests that ANY conditions keep the boolean structure of the filter
+// when mixed with AND, OR, NOT and parentheses
+func TestAnyOperatorGrammar(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  string
+		wantIDs []int
+		wantErr bool
+	}{
+		{
+			name:    "ANY under OR with nested AND",
+			filter:  "ANY(Tags)='react' OR (Age > 30 AND ANY(Skills)='writing')",
+			wantIDs: []int{1, 3},
+		},
+		{
+			name:    "ANY as the right operand of OR",
+			filter:  "Age > 40 OR ANY(Tags) = 'go'",
+			wantIDs: []int{2, 5},
+		},
+		{
+			name:    "NOT ANY",
+			filter:  "NOT ANY(Tags) = 'go'",
+			wantIDs: []int{1, 3, 4, 5},
+		},
+		{
+			name:    "NOT over a parenthesized OR",
+			filter:  "NOT (ANY(Tags) = 'go' OR Active = false)",
+			wantIDs: []int{1, 4},
+		},
+		{
+			name:    "ANY value list with AND",
+			filter:  "ANY(Tags) = ANY('python', 'content') AND Active = false",
+			wantIDs: []int{3},
+		},
+		{
+			name:    "parentheses are evaluated",
+			filter:  "(Age='25' OR Age='40') AND Department.Name='Engineering'",
+			wantIDs: []int{1},
+		},
+		{
+			name:    "values containing parentheses and quotes",
+			filter:  "ANY(Tags) = 'a)b' OR Name = 'it''s' OR ANY(Tags) = ANY('x)', 'y''z')",
+			wantIDs: []int{},
+		},
+		{
+			name:    "string true is not a placeholder",
+			filter:  "Name = 'true' OR ANY(Tags) = 'go'",
+			wantIDs: []int{2},
+		},
+		{
+			name:    "ANY comparisons combined with AND NOT",
+			filter:  "ANY(Metadata.Categories) = 'developer' AND NOT ANY(Scores) = '90'",
+			wantIDs: []int{2},
+		},
+		{
+			name:    "native ANY form",
+			filter:  "'go' = ANY(Tags)",
+			wantIDs: []int{2},
+		},
+		{
+			name:    "native ALL form",
+			filter:  "'go' <> ALL(Tags)",
+			wantIDs: []int{1, 3, 4, 5},
+		},
+		{
+			name:    "field equals any array element",
+			filter:  "Name = ANY(ARRAY['Alice', 'Eve'])",
+			wantIDs: []int{1, 5},
+		},
+		{
+			name:    "IN list",
+			filter:  "Name IN ('Alice', 'Bob')",
+			wantIDs: []int{1, 2},
+		},
+		{
+			name:    "NOT IN list",
+			filter:  "Name NOT IN ('Alice', 'Bob')",
+			wantIDs: []int{3, 4, 5},
+		},
+		{
+			name:    "boolean literals",
+			filter:  "false OR (true AND Age = 25)",
+			wantIDs: []int{1},
+		},
+		{name: "multiple statements", filter: "Age = 25; DROP TABLE t", wantErr: true},
+		{name: "extra clauses", filter: "Age = 25 ORDER BY Name", wantErr: true},
+		{name: "unions", filter: "Age = 25 UNION SELECT * FROM u", wantErr: true},
+		{name: "expressions as values", filter: "Age = Scores + 1", wantErr: true},
+		{name: "unknown functions", filter: "sleep(10) = 1", wantErr: true},
+		{name: "unsupported operators on ANY", filter: "ANY(Tags) > 'a'", wantErr: true},
+		{name: "ALL without a predicate", filter: "ALL(Tags) = 'go'", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ApplyFilter(tt.filter, testPeople)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ApplyFilter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			gotIDs := make([]int, 0, len(result.Items))
+			for _, person := range result.Items {
+				gotIDs = append(gotIDs, person.ID)
+			}
+
+			if !reflect.DeepEqual(gotIDs, tt.wantIDs) {
+				t.Errorf("ApplyFilter() got IDs = %v, want %v", gotIDs, tt.wantIDs)
+			}
+		})
+	}
+}
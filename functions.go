@@ -0,0 +1,398 @@
+// Copyright 2023 Daniel Valdivia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rql
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/auxten/postgresql-parser/pkg/sql/sem/tree"
+)
+
+// Function is a call of a built-in scalar function, applied to the values of fields before
+// comparing them, e.g. lower(Email) in lower(Email) LIKE '%@example.com'
+type Function struct {
+	name   string
+	args   []FunctionArg
+	result valueType
+}
+
+// FunctionArg is an argument of a function: a field, a literal value or the result of
+// another function
+type FunctionArg struct {
+	field    string
+	value    *Literal
+	function *Function
+}
+
+// valueType is the type of a value as far as it's known when compiling a filter
+type valueType int
+
+const (
+	anyValue    valueType = iota // The value of a field, only known once it's resolved
+	stringValue                  // A string
+	numberValue                  // An integer or decimal number
+	boolValue                    // true or false
+)
+
+// String names the type for error messages
+func (t valueType) String() string {
+	switch t {
+	case stringValue:
+		return "a string"
+	case numberValue:
+		return "a number"
+	case boolValue:
+		return "a boolean"
+	default:
+		return "any value"
+	}
+}
+
+// literalType returns the type of a literal value
+func literalType(value Literal) valueType {
+	switch value.kind {
+	case NumberLiteral:
+		return numberValue
+	case BoolLiteral:
+		return boolValue
+	default:
+		return stringValue
+	}
+}
+
+// scalarFunction describes a built-in function. Functions return null, which no comparison
+// matches, when any argument is null or a field holds a value of another type than the
+// parameter, except for variadic functions like coalesce. call receives strings for string
+// parameters and float64 values for numeric ones
+type scalarFunction struct {
+	params   []valueType // Types of the required parameters
+	optional []valueType // Types of the optional parameters following them
+	variadic bool        // Takes one or more arguments of a common type, which it returns
+	result   valueType
+	validate func(args []FunctionArg) error // Checks literal arguments further, if set
+	call     func(args []any) any
+}
+
+// scalarFunctions are the functions filters may call, following PostgreSQL
+var scalarFunctions = map[string]scalarFunction{
+	"lower": {params: []valueType{stringValue}, result: stringValue, call: func(args []any) any {
+		return strings.ToLower(args[0].(string))
+	}},
+	"upper": {params: []valueType{stringValue}, result: stringValue, call: func(args []any) any {
+		return strings.ToUpper(args[0].(string))
+	}},
+	"length": {params: []valueType{stringValue}, result: numberValue, call: func(args []any) any {
+		return int64(utf8.RuneCountInString(args[0].(string)))
+	}},
+	"trim": {params: []valueType{stringValue}, optional: []valueType{stringValue}, result: stringValue, call: func(args []any) any {
+		characters := " "
+		if len(args) > 1 {
+			characters = args[1].(string)
+		}
+		return strings.Trim(args[0].(string), characters)
+	}},
+	"substring": {params: []valueType{stringValue, numberValue}, optional: []valueType{numberValue}, result: stringValue, validate: validateSubstring, call: substring},
+	"coalesce":  {variadic: true, call: coalesce},
+}
+
+// substring returns the characters of a string from a 1-based position, up to a number of
+// characters if given. Like in PostgreSQL, positions before the first character count
+// towards the number of characters
+func substring(args []any) any {
+	runes := []rune(args[0].(string))
+	start := args[1].(float64)
+	end := float64(len(runes) + 1)
+	if len(args) > 2 {
+		count := args[2].(float64)
+		if count < 0 {
+			return nil
+		}
+		end = min(end, start+count)
+	}
+
+	start = max(start, 1)
+	if end <= start {
+		return ""
+	}
+	return string(runes[int(start)-1 : int(end)-1])
+}
+
+// validateSubstring rejects a negative number of characters, as PostgreSQL does
+func validateSubstring(args []FunctionArg) error {
+	if len(args) > 2 && args[2].value != nil && strings.HasPrefix(args[2].value.text, "-") {
+		return errors.New("negative substring length not allowed")
+	}
+	return nil
+}
+
+// coalesce returns the first argument that isn't null
+func coalesce(args []any) any {
+	for _, arg := range args {
+		if arg != nil {
+			return arg
+		}
+	}
+	return nil
+}
+
+// convertArg converts the value of an argument to the type of its parameter, returning nil
+// for values of other types, e.g. an array or a number passed to length()
+func convertArg(arg any, param valueType) any {
+	switch param {
+	case stringValue:
+		value := reflect.ValueOf(arg)
+		if value.Kind() != reflect.String || value.Type() == reflect.TypeFor[json.Number]() {
+			return nil
+		}
+		return value.String()
+	case numberValue:
+		number, ok := numericFieldValue(reflect.ValueOf(arg))
+		if !ok || math.IsNaN(number) {
+			return nil
+		}
+		return number
+	}
+	return arg
+}
+
+// functionFromExpr compiles a function call on the left side of a comparison, e.g.
+// lower(Email), reporting false for anything else
+func functionFromExpr(expr tree.Expr) (*Function, bool, error) {
+	switch node := expr.(type) {
+	case *tree.FuncExpr:
+		// ANY(field) = value compares the elements of a field, see rewriteAnyOperators
+		if functionName(node) == "any" {
+			return nil, false, nil
+		}
+		function, err := compileFunction(node, functionName(node), node.Exprs)
+		return function, true, err
+	case *tree.CoalesceExpr:
+		function, err := compileFunction(node, strings.ToLower(node.Name), node.Exprs)
+		return function, true, err
+	case *tree.ParenExpr:
+		return functionFromExpr(node.Expr)
+	}
+	return nil, false, nil
+}
+
+// compileFunction compiles a call of a built-in function, checking the number and types of
+// its arguments
+func compileFunction(node tree.Expr, name string, exprs tree.Exprs) (*Function, error) {
+	// The parser turns trim(value) into btrim(value)
+	if name == "btrim" {
+		name = "trim"
+	}
+	definition, ok := scalarFunctions[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported function: %s", node)
+	}
+
+	function := &Function{name: name, args: make([]FunctionArg, len(exprs)), result: definition.result}
+	for i, expr := range exprs {
+		arg, err := functionArgFromExpr(expr)
+		if err != nil {
+			return nil, err
+		}
+		function.args[i] = arg
+	}
+
+	if definition.variadic {
+		if len(exprs) == 0 {
+			return nil, fmt.Errorf("%s() expects at least one argument", name)
+		}
+		for i, arg := range function.args {
+			switch argType := arg.valueType(); {
+			case argType == anyValue:
+			case function.result == anyValue:
+				function.result = argType
+			case argType != function.result:
+				return nil, fmt.Errorf("argument %d of %s() must be %s, not %s", i+1, name, function.result, argType)
+			}
+		}
+		return function, nil
+	}
+
+	params := slices.Concat(definition.params, definition.optional)
+	if len(exprs) < len(definition.params) || len(exprs) > len(params) {
+		return nil, fmt.Errorf("%s() expects %s, got %d", name, argumentCount(len(definition.params), len(params)), len(exprs))
+	}
+	for i, arg := range function.args {
+		argType := arg.valueType()
+		if argType != anyValue && argType != params[i] {
+			return nil, fmt.Errorf("argument %d of %s() must be %s, not %s", i+1, name, params[i], argType)
+		}
+		// Numeric parameters are positions and counts
+		if params[i] == numberValue && arg.value != nil {
+			if _, ok := arg.value.Value().(int64); !ok {
+				return nil, fmt.Errorf("argument %d of %s() must be an integer, not %s", i+1, name, arg.value)
+			}
+		}
+	}
+	if definition.validate != nil {
+		if err := definition.validate(function.args); err != nil {
+			return nil, err
+		}
+	}
+	return function, nil
+}
+
+// argumentCount describes how many arguments a function expects
+func argumentCount(least, most int) string {
+	switch {
+	case least == most && least == 1:
+		return "1 argument"
+	case least == most:
+		return fmt.Sprintf("%d arguments", least)
+	default:
+		return fmt.Sprintf("%d or %d arguments", least, most)
+	}
+}
+
+// functionArgFromExpr compiles an argument of a function
+func functionArgFromExpr(expr tree.Expr) (FunctionArg, error) {
+	if field, ok := fieldPathFromExpr(expr); ok {
+		return FunctionArg{field: field}, nil
+	}
+	if function, ok, err := functionFromExpr(expr); ok {
+		return FunctionArg{function: function}, err
+	}
+	value, err := literalValue(expr)
+	if err != nil {
+		return FunctionArg{}, err
+	}
+	return FunctionArg{value: &value}, nil
+}
+
+// valueType returns the type of the argument as far as it's known when compiling
+func (a FunctionArg) valueType() valueType {
+	switch {
+	case a.value != nil:
+		return literalType(*a.value)
+	case a.function != nil:
+		return a.function.result
+	}
+	return anyValue
+}
+
+// evaluate returns the value of the argument for an item, or nil for null
+func (a FunctionArg) evaluate(item any) any {
+	switch {
+	case a.value != nil:
+		return a.value.Value()
+	case a.function != nil:
+		return a.function.evaluate(item)
+	}
+
+	field, found := resolveField(item, a.field)
+	if field = indirect(field); !found || !field.IsValid() || !field.CanInterface() {
+		return nil
+	}
+	return field.Interface()
+}
+
+// evaluate returns the result of the function for an item, or nil for null
+func (f *Function) evaluate(item any) any {
+	definition := scalarFunctions[f.name]
+	params := slices.Concat(definition.params, definition.optional)
+	args := make([]any, len(f.args))
+	for i, arg := range f.args {
+		if args[i] = arg.evaluate(item); definition.variadic {
+			continue
+		}
+		if args[i] = convertArg(args[i], params[i]); args[i] == nil {
+			return nil
+		}
+	}
+	return definition.call(args)
+}
+
+// withFunction applies a condition built for the item itself to the result of a function
+// instead, wrapping each comparison in a FunctionCondition once the result's type is
+// checked against the compared value
+func withFunction(function *Function, condition Condition) (Condition, error) {
+	switch c := condition.(type) {
+	case *AndCondition:
+		left, err := withFunction(function, c.left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := withFunction(function, c.right)
+		return &AndCondition{left: left, right: right}, err
+	case *OrCondition:
+		left, err := withFunction(function, c.left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := withFunction(function, c.right)
+		return &OrCondition{left: left, right: right}, err
+	case *NotCondition:
+		operand, err := withFunction(function, c.condition)
+		return &NotCondition{condition: operand}, err
+	case *EqualCondition:
+		if err := function.checkComparison(c.value); err != nil {
+			return nil, err
+		}
+	case *NotEqualCondition:
+		if err := function.checkComparison(c.value); err != nil {
+			return nil, err
+		}
+	case *ComparisonCondition:
+		if err := function.checkComparison(c.value); err != nil {
+			return nil, err
+		}
+	case *LikeCondition, *RegexCondition:
+		if function.result != anyValue && function.result != stringValue {
+			return nil, fmt.Errorf("%s returns %s, which can't be matched against a pattern", formatFunction(function), function.result)
+		}
+	case *HasCondition:
+		if c.field != "" {
+			return nil, fmt.Errorf("unsupported operator for %s", formatFunction(function))
+		}
+	default:
+		return nil, fmt.Errorf("unsupported operator for %s", formatFunction(function))
+	}
+	return &FunctionCondition{function: function, condition: condition}, nil
+}
+
+// checkComparison checks that the result of the function can be compared with a value
+func (f *Function) checkComparison(value Literal) error {
+	if f.result != anyValue && f.result != literalType(value) {
+		return fmt.Errorf("%s returns %s, which can't be compared with %s", formatFunction(f), f.result, value)
+	}
+	return nil
+}
+
+// formatFunction writes a function call as in a SQL-like filter, with fields double-quoted
+func formatFunction(f *Function) string {
+	args := make([]string, len(f.args))
+	for i, arg := range f.args {
+		switch {
+		case arg.value != nil:
+			args[i] = arg.value.String()
+		case arg.function != nil:
+			args[i] = formatFunction(arg.function)
+		default:
+			args[i] = canonicalField(arg.field)
+		}
+	}
+	return f.name + "(" + strings.Join(args, ", ") + ")"
+}
@@ -0,0 +1,167 @@
+// Copyright 2023 Daniel Valdivia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rql
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFunctions(t *testing.T) {
+	// Results as given by PostgreSQL, with v = 'Ñandú ' and n = 3
+	item := map[string]any{"v": "Ñandú ", "n": 3, "empty": "", "missing": nil, "tags": []string{"go"}}
+
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		{filter: `lower(v) = 'ñandú '`, want: true},
+		{filter: `upper(v) = 'ÑANDÚ '`, want: true},
+		{filter: `upper(v) LIKE 'ÑAND%'`, want: true},
+		{filter: `length(v) = 6`, want: true},
+		{filter: `length(v) > 5.5`, want: true},
+		{filter: `length(empty) = 0`, want: true},
+		{filter: `trim(v) = 'Ñandú'`, want: true},
+		{filter: `trim(both 'Ñ ' from v) = 'andú'`, want: true},
+		{filter: `trim(v, 'Ñú ') = 'and'`, want: true},
+		{filter: `substring(v, 2) = 'andú '`, want: true},
+		{filter: `substring(v, 2, 3) = 'and'`, want: true},
+		{filter: `substring(v from 1 for 2) = 'Ña'`, want: true},
+		{filter: `substring(v, 0, 2) = 'Ñ'`, want: true},
+		{filter: `substring(v, -5, 2) = ''`, want: true},
+		{filter: `substring(v, 7) = ''`, want: true},
+		{filter: `substring(v, n) = 'ndú '`, want: true},
+		{filter: `substring(v, 1, 0) = ''`, want: true},
+		{filter: `lower(trim(substring(v, 4))) = 'dú'`, want: true},
+		{filter: `length(lower(v)) >= 6`, want: true},
+		{filter: `coalesce(missing, v) = 'Ñandú '`, want: true},
+		{filter: `coalesce(missing, other, 'none') = 'none'`, want: true},
+		{filter: `coalesce(n, 0) > 2`, want: true},
+		{filter: `COALESCE(missing) = ''`, want: false},
+		{filter: `lower(v) IN ('x', 'ñandú ')`, want: true},
+		{filter: `lower(v) NOT IN ('x', 'ñandú ')`, want: false},
+		{filter: `lower(v) = ANY(ARRAY['ñandú '])`, want: true},
+		{filter: `lower(v) ILIKE 'Ñ%' ESCAPE '!'`, want: true},
+		{filter: `lower(v) ~ '^ña'`, want: true},
+		{filter: `has(trim(v))`, want: true},
+		{filter: `NOT lower(v) != 'ñandú '`, want: true},
+
+		// Functions of null are null, which no comparison matches
+		{filter: `lower(missing) = ''`, want: false},
+		{filter: `lower(missing) != ''`, want: false},
+		{filter: `lower(other) NOT LIKE 'x%'`, want: false},
+		{filter: `length(missing) >= 0`, want: false},
+		{filter: `substring(v, missing) = ''`, want: false},
+		{filter: `has(lower(missing))`, want: false},
+		{filter: `NOT has(lower(missing))`, want: true},
+
+		// So are functions of fields holding a value of another type than the parameter
+		{filter: `length(n) = 1`, want: false},
+		{filter: `lower(n) = '3'`, want: false},
+		{filter: `lower(n) != '3'`, want: false},
+		{filter: `length(tags) = 1`, want: false},
+		{filter: `upper(tags) LIKE '%'`, want: false},
+		{filter: `trim(v, n) = 'Ñandú'`, want: false},
+		{filter: `substring(v, v) = ''`, want: false},
+		{filter: `NOT has(length(tags))`, want: true},
+		{filter: `length(coalesce(n, v)) = 1`, want: false},
+	}
+
+	for _, tt := range tests {
+		query, err := Compile(tt.filter)
+		if err != nil {
+			t.Errorf("Compile(%q) unexpected error = %v", tt.filter, err)
+			continue
+		}
+		if got := query.Match(item); got != tt.want {
+			t.Errorf("%q = %v, want %v", tt.filter, got, tt.want)
+		}
+
+		canonical, err := Compile(query.String())
+		if err != nil {
+			t.Fatalf("Compile(%q) unexpected error = %v", query.String(), err)
+		}
+		if got := canonical.Match(item); got != tt.want {
+			t.Errorf("%q = %v, want %v", query.String(), got, tt.want)
+		}
+		if canonical.String() != query.String() {
+			t.Errorf("canonical form %q compiles to %q", query.String(), canonical.String())
+		}
+	}
+}
+
+func TestFunctionsPeople(t *testing.T) {
+	tests := []struct {
+		filter  string
+		wantIDs []int
+	}{
+		{filter: `lower(Email) LIKE '%@EXAMPLE.COM'`, wantIDs: []int{}},
+		{filter: `upper(Email) LIKE '%@EXAMPLE.COM'`, wantIDs: []int{1, 2, 3, 5}},
+		{filter: `length(Name) > 3`, wantIDs: []int{1, 3, 4}},
+		{filter: `substring(Department.Name, 1, 3) = 'Eng'`, wantIDs: []int{1, 2}},
+		{filter: `ANY(Tags, length("") < 5)`, wantIDs: []int{2}},
+		{filter: `length(Tags) >= 0 OR lower(Age) = '30'`, wantIDs: []int{}},
+		{filter: `lower(Name) IN ('bob', 'eve') AND Active = true`, wantIDs: []int{2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			query, err := Compile(tt.filter)
+			if err != nil {
+				t.Fatalf("Compile() unexpected error = %v", err)
+			}
+			gotIDs := []int{}
+			for _, person := range ApplyQuery(query, testPeople).Items {
+				gotIDs = append(gotIDs, person.ID)
+			}
+			if !reflect.DeepEqual(gotIDs, tt.wantIDs) {
+				t.Errorf("ApplyQuery() got IDs = %v, want %v", gotIDs, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func TestFunctionErrors(t *testing.T) {
+	tests := []struct {
+		filter  string
+		wantErr string
+	}{
+		{filter: `reverse(Name) = 'x'`, wantErr: "unsupported function: reverse(name)"},
+		{filter: `lower(Name, Email) = 'x'`, wantErr: "lower() expects 1 argument, got 2"},
+		{filter: `substring(Name) = 'x'`, wantErr: "substring() expects 2 or 3 arguments, got 1"},
+		{filter: `length(5) = 1`, wantErr: "argument 1 of length() must be a string, not a number"},
+		{filter: `lower(length(Name)) = '1'`, wantErr: "argument 1 of lower() must be a string, not a number"},
+		{filter: `substring(Name, '2') = 'x'`, wantErr: "argument 2 of substring() must be a number, not a string"},
+		{filter: `substring(Name, 1.5) = 'x'`, wantErr: "argument 2 of substring() must be an integer, not 1.5"},
+		{filter: `substring(Name, 1, -1) = 'x'`, wantErr: "negative substring length not allowed"},
+		{filter: `coalesce(length(Name), 'none') = 'x'`, wantErr: "argument 2 of coalesce() must be a number, not a string"},
+		{filter: `length(Name) = 'five'`, wantErr: `length("name") returns a number, which can't be compared with 'five'`},
+		{filter: `lower(Name) > 5`, wantErr: `lower("name") returns a string, which can't be compared with 5`},
+		{filter: `coalesce(Age, 0) = true`, wantErr: `coalesce("age", 0) returns a number, which can't be compared with true`},
+		{filter: `length(Name) LIKE '1%'`, wantErr: `length("name") returns a number, which can't be matched against a pattern`},
+		{filter: `length(Name) NOT ILIKE '1%' ESCAPE '!'`, wantErr: `length("name") returns a number, which can't be matched against a pattern`},
+		{filter: `length(Name) ~ '^1'`, wantErr: `length("name") returns a number, which can't be matched against a pattern`},
+		{filter: `lower(Labels) ? 'env'`, wantErr: `unsupported operator for lower("labels")`},
+		{filter: `lower(Name) = lower(Email)`, wantErr: "unsupported value: lower(email)"},
+		{filter: `has(reverse(Name))`, wantErr: "unsupported function: reverse(name)"},
+	}
+
+	for _, tt := range tests {
+		if _, err := Compile(tt.filter); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("Compile(%q) error = %v, want %s", tt.filter, err, tt.wantErr)
+		}
+	}
+}
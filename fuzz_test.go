@@ -0,0 +1,98 @@
+// Copyright 2023 Daniel Valdivia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rql
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// The seed corpora in testdata/fuzz are drawn from the filters in filtering_test.go, run
+// as regular tests by go test and extended with go test -fuzz
+
+func FuzzCompile(f *testing.F) {
+	f.Fuzz(func(t *testing.T, filter string) {
+		query, err := Compile(filter)
+		if err != nil {
+			return
+		}
+
+		result := ApplyQuery(query, testPeople, FilterOptions{Limit: 2, Offset: 1, Sort: []string{"-Age"}})
+		if result.Count < len(result.Items) || result.Count > len(testPeople) {
+			t.Fatalf("ApplyQuery(%q) Count = %d with %d items", filter, result.Count, len(result.Items))
+		}
+
+		// NOT matches exactly the items the filter doesn't
+		negated, err := Compile("NOT (" + filter + ")")
+		if err == nil {
+			for _, person := range testPeople {
+				if query.Match(person) == negated.Match(person) {
+					t.Fatalf("filter %q and its negation both match %v for person %d", filter, query.Match(person), person.ID)
+				}
+			}
+		}
+
+		// The canonical form compiles to an equivalent query, with the same canonical form
+		canonical, err := Compile(query.String())
+		if err != nil {
+			t.Fatalf("Compile(%q) of the canonical form of %q unexpected error = %v", query.String(), filter, err)
+		}
+		if canonical.String() != query.String() {
+			t.Fatalf("canonical form of %q = %q, then %q", filter, query.String(), canonical.String())
+		}
+		for _, person := range testPeople {
+			if query.Match(person) != canonical.Match(person) {
+				t.Fatalf("filter %q and its canonical form %q disagree on person %d", filter, query.String(), person.ID)
+			}
+		}
+	})
+}
+
+func FuzzLike(f *testing.F) {
+	like := func(value, pattern string, caseInsensitive bool) bool {
+		return newLikeCondition("", Literal{text: pattern, kind: StringLiteral}, caseInsensitive).evaluate(value)
+	}
+
+	f.Fuzz(func(t *testing.T, value, pattern string) {
+		match := like(value, pattern, false)
+
+		// The regular expression the MongoDB translation uses agrees with the matcher
+		for _, caseInsensitive := range []bool{false, true} {
+			flags := "(?s)"
+			if caseInsensitive {
+				flags = "(?is)"
+			}
+			want := regexp.MustCompile(flags + likeRegexp(pattern)).MatchString(value)
+			if got := like(value, pattern, caseInsensitive); utf8.ValidString(value) && got != want {
+				t.Fatalf("%q LIKE %q with case insensitivity %v = %v, want %v", value, pattern, caseInsensitive, got, want)
+			}
+		}
+
+		if !strings.ContainsAny(pattern, `%_\`) && match != (value == pattern) {
+			t.Fatalf("%q LIKE %q = %v without wildcards", value, pattern, match)
+		}
+		if escaped := escapeLike(pattern); !like(pattern, escaped, false) || like(value, escaped, false) != (value == pattern) {
+			t.Fatalf("%q LIKE %q = %v, want a match of %q only", value, escaped, like(value, escaped, false), pattern)
+		}
+		if match && !like(value, pattern, true) {
+			t.Fatalf("%q LIKE %q = true, but ILIKE = false", value, pattern)
+		}
+		if match && validateLike(pattern) == nil && !like(value, "%"+pattern+"%", false) {
+			t.Fatalf("%q LIKE %q = true, but LIKE %q = false", value, pattern, "%"+pattern+"%")
+		}
+	})
+}
@@ -0,0 +1,485 @@
+// Copyright 2023 Daniel Valdivia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rql
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// CompileJSON parses a filter written as a JSON document, for clients that build filters as
+// trees rather than strings. Each node of the document is one of:
+//
+//	{"and": [node, ...]}                               all nodes match, true when empty
+//	{"or": [node, ...]}                                any node matches, false when empty
+//	{"not": node}                                      the node does not match
+//	{"field": "Age", "op": ">=", "value": 30}          a comparison with a value
+//	{"field": "Name", "op": "in", "value": ["a", "b"]} the field equals any of the values
+//	{"has": "Labels.env"}                              the field or map key is present
+//	{"any": "Tags", "op": "=", "value": "go"}          an element of a slice equals a value
+//	{"any": "Orders", "where": node}                   an element of a slice matches the node
+//	{"all": "Orders", "where": node}                   every element of a slice matches the node
+//	true, false                                        constants
+//
+// Field comparisons accept the =, !=, <>, >, >=, <, <=, like, ilike, in and not in operators,
+// and element comparisons the =, !=, in and not in operators. Values are strings, numbers or
+// booleans. Field names are used exactly as written, and fields inside where are resolved
+// against each element. An empty document {} matches every item, while null nodes, keys set
+// to null and data after the document are rejected
+func CompileJSON(data []byte) (*Query, error) {
+	var node jsonNode
+	if err := node.UnmarshalJSON(data); err != nil {
+		return nil, err
+	}
+	if node.empty() {
+		return &Query{}, nil
+	}
+
+	condition, err := node.condition()
+	if err != nil {
+		return nil, err
+	}
+	return &Query{condition: condition}, nil
+}
+
+// MarshalJSON encodes the query as a JSON filter, see CompileJSON for the format. Compiling
+// the result gives back an identical query
+func (q *Query) MarshalJSON() ([]byte, error) {
+	if q.condition == nil {
+		return []byte("{}"), nil
+	}
+
+	node, err := jsonNodeFromCondition(q.condition)
+	if err != nil {
+		return nil, err
+	}
+	return marshalJSON(node)
+}
+
+// UnmarshalJSON decodes a JSON filter into the query, see CompileJSON for the format
+func (q *Query) UnmarshalJSON(data []byte) error {
+	query, err := CompileJSON(data)
+	if err != nil {
+		return err
+	}
+	*q = *query
+	return nil
+}
+
+// jsonNode is a node of a JSON filter. Exactly one of And, Or, Not, Has, Field, Any and All
+// is set, unless the node is a constant
+type jsonNode struct {
+	And   []jsonNode      `json:"and,omitempty"`
+	Or    []jsonNode      `json:"or,omitempty"`
+	Not   *jsonNode       `json:"not,omitempty"`
+	Has   string          `json:"has,omitempty"`
+	Field string          `json:"field,omitempty"`
+	Any   string          `json:"any,omitempty"`
+	All   string          `json:"all,omitempty"`
+	Op    string          `json:"op,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+	Where *jsonNode       `json:"where,omitempty"`
+
+	constant *bool
+}
+
+// UnmarshalJSON decodes a node, rejecting unknown keys, null and data after the node
+func (n *jsonNode) UnmarshalJSON(data []byte) error {
+	// null would decode as false, and keys set to null as if they were missing
+	if bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+		return errors.New("invalid JSON filter: a node cannot be null")
+	}
+	var constant bool
+	if err := json.Unmarshal(data, &constant); err == nil {
+		*n = jsonNode{constant: &constant}
+		return nil
+	}
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(data, &keys); err == nil {
+		for _, key := range slices.Sorted(maps.Keys(keys)) {
+			if bytes.Equal(keys[key], []byte("null")) {
+				return fmt.Errorf("invalid JSON filter: %s cannot be null", key)
+			}
+		}
+	}
+
+	// The alias has no UnmarshalJSON method, so that decoding it does not recurse
+	type node jsonNode
+	var decoded node
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&decoded); err != nil {
+		return fmt.Errorf("invalid JSON filter: %w", err)
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return fmt.Errorf("invalid JSON filter: unexpected data after offset %d", decoder.InputOffset())
+	}
+	*n = jsonNode(decoded)
+	return nil
+}
+
+// MarshalJSON encodes a node, writing constants as booleans
+func (n jsonNode) MarshalJSON() ([]byte, error) {
+	if n.constant != nil {
+		return marshalJSON(*n.constant)
+	}
+	type node jsonNode
+	return marshalJSON(node(n))
+}
+
+// marshalJSON encodes a value without escaping <, > and &, which are common in filters
+func marshalJSON(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// empty reports whether the node has no keys, i.e. it is the document {}
+func (n *jsonNode) empty() bool {
+	return n.constant == nil && n.And == nil && n.Or == nil && n.Not == nil && n.Has == "" &&
+		n.Field == "" && n.Any == "" && n.All == "" && n.Op == "" && n.Value == nil && n.Where == nil
+}
+
+// condition converts a node into a condition
+func (n *jsonNode) condition() (Condition, error) {
+	kinds := 0
+	for _, set := range []bool{n.And != nil, n.Or != nil, n.Not != nil, n.Has != "", n.Field != "", n.Any != "", n.All != ""} {
+		if set {
+			kinds++
+		}
+	}
+	if n.constant == nil && kinds != 1 {
+		return nil, errors.New("each JSON filter node needs exactly one of and, or, not, has, field, any and all")
+	}
+
+	switch {
+	case n.constant != nil:
+		return &ConstantCondition{value: *n.constant}, nil
+	case n.And != nil:
+		return n.logical(n.And, "and", true)
+	case n.Or != nil:
+		return n.logical(n.Or, "or", false)
+	case n.Not != nil:
+		if err := n.only("not"); err != nil {
+			return nil, err
+		}
+		condition, err := n.Not.condition()
+		if err != nil {
+			return nil, err
+		}
+		return &NotCondition{condition: condition}, nil
+	case n.Has != "":
+		if err := n.only("has"); err != nil {
+			return nil, err
+		}
+		return &HasCondition{field: n.Has}, nil
+	case n.Field != "":
+		if n.Where != nil {
+			return nil, fmt.Errorf("where is only allowed with any and all, not with field %s", n.Field)
+		}
+		return n.comparison()
+	case n.Where != nil:
+		return n.quantifier()
+	case n.Any != "":
+		return n.elementComparison()
+	}
+	return nil, fmt.Errorf("all requires a where node, not an operator, for field %s", n.All)
+}
+
+// only checks that a node has no keys besides the given one
+func (n *jsonNode) only(key string) error {
+	if n.Op != "" || n.Value != nil || n.Where != nil {
+		return fmt.Errorf("%s cannot be combined with op, value or where", key)
+	}
+	return nil
+}
+
+// logical joins the conditions of the operands of and or or
+func (n *jsonNode) logical(operands []jsonNode, key string, and bool) (Condition, error) {
+	if err := n.only(key); err != nil {
+		return nil, err
+	}
+	if len(operands) == 0 {
+		return &ConstantCondition{value: and}, nil
+	}
+
+	var condition Condition
+	for i := range operands {
+		operand, err := operands[i].condition()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case condition == nil:
+			condition = operand
+		case and:
+			condition = &AndCondition{left: condition, right: operand}
+		default:
+			condition = &OrCondition{left: condition, right: operand}
+		}
+	}
+	return condition, nil
+}
+
+// comparison converts a field comparison into a condition
+func (n *jsonNode) comparison() (Condition, error) {
+	op := strings.ToLower(n.Op)
+	if op == "in" || op == "not in" {
+		values, err := jsonLiteralList(n.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%s for field %s: %w", op, n.Field, err)
+		}
+		return inCondition(n.Field, values, op == "not in"), nil
+	}
+
+	value, err := jsonLiteral(n.Value)
+	if err != nil {
+		return nil, fmt.Errorf("%s for field %s: %w", op, n.Field, err)
+	}
+
+	switch op {
+	case "=":
+		return &EqualCondition{field: n.Field, value: value}, nil
+	case "!=", "<>":
+		return &NotEqualCondition{field: n.Field, value: value}, nil
+	case ">", ">=", "<", "<=":
+		return &ComparisonCondition{field: n.Field, value: value, operator: op}, nil
+	case "like", "ilike":
+		return likeComparison(n.Field, value, op == "ilike", false)
+	case "~", "~*":
+		return regexComparison(n.Field, value, op == "~*", false)
+	}
+	return nil, fmt.Errorf("unsupported operator %q for field %s", n.Op, n.Field)
+}
+
+// elementComparison converts a comparison of the elements of a slice into a condition
+func (n *jsonNode) elementComparison() (Condition, error) {
+	op := strings.ToLower(n.Op)
+	if op == "in" || op == "not in" {
+		values, err := jsonLiteralList(n.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%s for any %s: %w", op, n.Any, err)
+		}
+		if op == "not in" {
+			return &AnyArrayNotContainsAnyCondition{field: n.Any, values: values}, nil
+		}
+		return &AnyArrayContainsAnyCondition{field: n.Any, values: values}, nil
+	}
+
+	value, err := jsonLiteral(n.Value)
+	if err != nil {
+		return nil, fmt.Errorf("%s for any %s: %w", op, n.Any, err)
+	}
+
+	switch op {
+	case "=":
+		return &AnyArrayContainsCondition{field: n.Any, value: value}, nil
+	case "!=", "<>":
+		return &AnyArrayNotContainsCondition{field: n.Any, value: value}, nil
+	}
+	return nil, fmt.Errorf("unsupported operator %q for any %s", n.Op, n.Any)
+}
+
+// quantifier converts an any or all node with a where node into a condition
+func (n *jsonNode) quantifier() (Condition, error) {
+	if n.Op != "" || n.Value != nil {
+		return nil, errors.New("where cannot be combined with op or value")
+	}
+	condition, err := n.Where.condition()
+	if err != nil {
+		return nil, err
+	}
+	if n.All != "" {
+		return &QuantifierCondition{field: n.All, condition: condition, all: true}, nil
+	}
+	return &QuantifierCondition{field: n.Any, condition: condition}, nil
+}
+
+// jsonLiteral converts a JSON string, number or boolean into a literal
+func jsonLiteral(data json.RawMessage) (Literal, error) {
+	if data == nil {
+		return Literal{}, errors.New("missing value")
+	}
+
+	var value any
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return Literal{}, err
+	}
+
+	switch v := value.(type) {
+	case string:
+		return Literal{text: v, kind: StringLiteral}, nil
+	case json.Number:
+		return Literal{text: v.String(), kind: NumberLiteral}, nil
+	case bool:
+		return Literal{text: strconv.FormatBool(v), kind: BoolLiteral}, nil
+	}
+	return Literal{}, fmt.Errorf("value must be a string, number or boolean, got %s", data)
+}
+
+// jsonLiteralList converts a non-empty JSON array of values into literals
+func jsonLiteralList(data json.RawMessage) ([]Literal, error) {
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil || len(items) == 0 {
+		return nil, errors.New("value must be a non-empty array")
+	}
+
+	values := make([]Literal, len(items))
+	for i, item := range items {
+		value, err := jsonLiteral(item)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// jsonNodeFromCondition converts a condition into a node. Chains of AND or OR become a
+// single node with all their operands, while groups on the right side stay nested so that
+// compiling the node gives back the same condition
+func jsonNodeFromCondition(condition Condition) (jsonNode, error) {
+	switch c := condition.(type) {
+	case *AndCondition:
+		operands, err := jsonNodesFromChain(c, func(c Condition) (Condition, Condition, bool) {
+			and, ok := c.(*AndCondition)
+			if !ok {
+				return nil, nil, false
+			}
+			return and.left, and.right, true
+		})
+		return jsonNode{And: operands}, err
+	case *OrCondition:
+		operands, err := jsonNodesFromChain(c, func(c Condition) (Condition, Condition, bool) {
+			or, ok := c.(*OrCondition)
+			if !ok {
+				return nil, nil, false
+			}
+			return or.left, or.right, true
+		})
+		return jsonNode{Or: operands}, err
+	case *NotCondition:
+		operand, err := jsonNodeFromCondition(c.condition)
+		return jsonNode{Not: &operand}, err
+	case *ConstantCondition:
+		return jsonNode{constant: &c.value}, nil
+	case *HasCondition:
+		return jsonNode{Has: c.field}, nil
+	case *EqualCondition:
+		return jsonComparisonNode(c.field, "=", c.value)
+	case *NotEqualCondition:
+		return jsonComparisonNode(c.field, "!=", c.value)
+	case *ComparisonCondition:
+		return jsonComparisonNode(c.field, c.operator, c.value)
+	case *LikeCondition:
+		op := "like"
+		if c.caseInsensitive {
+			op = "ilike"
+		}
+		return jsonComparisonNode(c.field, op, c.value)
+	case *RegexCondition:
+		op := "~"
+		if c.caseInsensitive {
+			op = "~*"
+		}
+		return jsonComparisonNode(c.field, op, c.value)
+	case *AnyArrayContainsCondition:
+		node, err := jsonComparisonNode("", "=", c.value)
+		node.Any = c.field
+		return node, err
+	case *AnyArrayNotContainsCondition:
+		node, err := jsonComparisonNode("", "!=", c.value)
+		node.Any = c.field
+		return node, err
+	case *AnyArrayContainsAnyCondition:
+		value, err := jsonValues(c.values)
+		return jsonNode{Any: c.field, Op: "in", Value: value}, err
+	case *AnyArrayNotContainsAnyCondition:
+		value, err := jsonValues(c.values)
+		return jsonNode{Any: c.field, Op: "not in", Value: value}, err
+	case *FunctionCondition:
+		return jsonNode{}, fmt.Errorf("function %s cannot be written as a JSON filter", c.function.name)
+	case *QuantifierCondition:
+		where, err := jsonNodeFromCondition(c.condition)
+		if c.all {
+			return jsonNode{All: c.field, Where: &where}, err
+		}
+		return jsonNode{Any: c.field, Where: &where}, err
+	}
+
+	return jsonNode{}, fmt.Errorf("unsupported condition %T", condition)
+}
+
+// jsonNodesFromChain converts the operands along the left side of a chain of the same
+// boolean operator, as split by operands, into nodes
+func jsonNodesFromChain(condition Condition, operands func(Condition) (left, right Condition, ok bool)) ([]jsonNode, error) {
+	left, right, ok := operands(condition)
+	if !ok {
+		node, err := jsonNodeFromCondition(condition)
+		return []jsonNode{node}, err
+	}
+
+	nodes, err := jsonNodesFromChain(left, operands)
+	if err != nil {
+		return nil, err
+	}
+	node, err := jsonNodeFromCondition(right)
+	return append(nodes, node), err
+}
+
+// jsonComparisonNode returns the node comparing a field with a value
+func jsonComparisonNode(field, op string, value Literal) (jsonNode, error) {
+	encoded, err := marshalJSON(jsonValue(value))
+	return jsonNode{Field: field, Op: op, Value: encoded}, err
+}
+
+// jsonValues encodes a list of literals as a JSON array
+func jsonValues(values []Literal) (json.RawMessage, error) {
+	items := make([]any, len(values))
+	for i, value := range values {
+		items[i] = jsonValue(value)
+	}
+	return marshalJSON(items)
+}
+
+// jsonValue returns the value a literal is encoded as, keeping the text of numbers as is
+// when it is valid JSON
+func jsonValue(value Literal) any {
+	switch value.kind {
+	case NumberLiteral:
+		if json.Valid([]byte(value.text)) {
+			return json.Number(value.text)
+		}
+		if number, err := strconv.ParseFloat(value.text, 64); err == nil {
+			return number
+		}
+	case BoolLiteral:
+		return value.text == "true"
+	}
+	return value.text
+}
@@ -0,0 +1,167 @@
+// Copyright 2023 Daniel Valdivia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rql
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestCompileJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  string
+		wantIDs []int
+		wantErr bool
+	}{
+		{name: "empty document", filter: `{}`, wantIDs: []int{1, 2, 3, 4, 5}},
+		{name: "and with any", filter: `{"and":[{"field":"Age","op":">=","value":30},{"any":"Tags","op":"=","value":"go"}]}`, wantIDs: []int{2}},
+		{name: "or", filter: `{"or":[{"field":"Name","op":"=","value":"Alice"},{"field":"Age","op":">","value":40}]}`, wantIDs: []int{1, 5}},
+		{name: "not", filter: `{"not":{"field":"Active","op":"=","value":true}}`, wantIDs: []int{3, 5}},
+		{name: "nested field", filter: `{"field":"Department.Name","op":"!=","value":"Engineering"}`, wantIDs: []int{3, 4, 5}},
+		{name: "in", filter: `{"field":"Age","op":"in","value":[25,45]}`, wantIDs: []int{1, 5}},
+		{name: "not in", filter: `{"field":"Age","op":"NOT IN","value":[25,45]}`, wantIDs: []int{2, 3, 4}},
+		{name: "like", filter: `{"field":"Email","op":"like","value":"%@example.com"}`, wantIDs: []int{1, 2, 3, 5}},
+		{name: "ilike", filter: `{"field":"Name","op":"ilike","value":"a%"}`, wantIDs: []int{1}},
+		{name: "regular expression", filter: `{"field":"Email","op":"~","value":"^[a-c]"}`, wantIDs: []int{1, 2, 3}},
+		{name: "case-insensitive regular expression", filter: `{"field":"Name","op":"~*","value":"^(A|e)"}`, wantIDs: []int{1, 5}},
+		{name: "has", filter: `{"has":"Department.Location"}`, wantIDs: []int{1, 2, 3, 4, 5}},
+		{name: "any not equal", filter: `{"any":"Tags","op":"!=","value":"go"}`, wantIDs: []int{1, 3, 4, 5}},
+		{name: "any in", filter: `{"any":"Scores","op":"in","value":[99,80]}`, wantIDs: []int{2, 3}},
+		{name: "any not in", filter: `{"any":"Scores","op":"not in","value":[99,80]}`, wantIDs: []int{1, 4, 5}},
+		{name: "empty field name", filter: `{"any":"Scores","where":{"field":"","op":">","value":90}}`, wantErr: true},
+		{name: "all where", filter: `{"all":"Metadata.Categories","where":{"not":{"field":"Name","op":"=","value":"x"}}}`, wantIDs: []int{1, 2, 3, 4, 5}},
+		{name: "empty and", filter: `{"and":[]}`, wantIDs: []int{1, 2, 3, 4, 5}},
+		{name: "empty or", filter: `{"or":[]}`, wantIDs: []int{}},
+		{name: "constants", filter: `{"or":[false,{"and":[true]}]}`, wantIDs: []int{1, 2, 3, 4, 5}},
+		{name: "invalid JSON", filter: `{"field":`, wantErr: true},
+		{name: "unknown key", filter: `{"field":"Age","op":">","value":1,"negate":true}`, wantErr: true},
+		{name: "two kinds of node", filter: `{"field":"Age","has":"Age"}`, wantErr: true},
+		{name: "missing value", filter: `{"field":"Age","op":">"}`, wantErr: true},
+		{name: "null value", filter: `{"field":"Age","op":"=","value":null}`, wantErr: true},
+		{name: "object value", filter: `{"field":"Age","op":"=","value":{}}`, wantErr: true},
+		{name: "in without an array", filter: `{"field":"Age","op":"in","value":25}`, wantErr: true},
+		{name: "in with an empty array", filter: `{"field":"Age","op":"in","value":[]}`, wantErr: true},
+		{name: "unknown operator", filter: `{"field":"Age","op":"<=>","value":1}`, wantErr: true},
+		{name: "invalid pattern", filter: `{"field":"Name","op":"like","value":"a\\"}`, wantErr: true},
+		{name: "invalid regular expression", filter: `{"field":"Name","op":"~","value":"("}`, wantErr: true},
+		{name: "unsupported element operator", filter: `{"any":"Scores","op":">","value":1}`, wantErr: true},
+		{name: "all without where", filter: `{"all":"Scores","op":"=","value":1}`, wantErr: true},
+		{name: "where with a comparison", filter: `{"field":"Age","where":true}`, wantErr: true},
+		{name: "not with an operator", filter: `{"not":true,"op":"="}`, wantErr: true},
+		{name: "null", filter: `null`, wantErr: true},
+		{name: "null operand", filter: `{"and":[{"field":"Age","op":">","value":1}, null]}`, wantErr: true},
+		{name: "null not", filter: `{"not":null}`, wantErr: true},
+		{name: "null has", filter: `{"has":null}`, wantErr: true},
+		{name: "null where", filter: `{"any":"Scores","where":null}`, wantErr: true},
+		{name: "trailing data", filter: `{"has":"Name"} garbage`, wantErr: true},
+		{name: "two documents", filter: `{"has":"Name"} {"has":"Age"}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := CompileJSON([]byte(tt.filter))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CompileJSON() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			gotIDs := []int{}
+			for _, person := range ApplyQuery(query, testPeople).Items {
+				gotIDs = append(gotIDs, person.ID)
+			}
+			if !reflect.DeepEqual(gotIDs, tt.wantIDs) {
+				t.Errorf("CompileJSON() got IDs = %v, want %v", gotIDs, tt.wantIDs)
+			}
+		})
+	}
+}
+
+// TestQueryJSONRoundTrip tests that SQL-like filters marshal to the expected JSON filters,
+// and that those compile to the same conditions
+func TestQueryJSONRoundTrip(t *testing.T) {
+	tests := []struct {
+		sql  string
+		json string
+	}{
+		{sql: ``, json: `{}`},
+		{sql: `true`, json: `true`},
+		{sql: `age >= 30 AND ANY(tags) = 'go'`, json: `{"and":[{"field":"age","op":">=","value":30},{"any":"tags","op":"=","value":"go"}]}`},
+		{sql: `a = 1 AND b = 2.5 AND c = true`, json: `{"and":[{"field":"a","op":"=","value":1},{"field":"b","op":"=","value":2.5},{"field":"c","op":"=","value":true}]}`},
+		{sql: `email ~* '^a' AND name ~ 'b$'`, json: `{"and":[{"field":"email","op":"~*","value":"^a"},{"field":"name","op":"~","value":"b$"}]}`},
+		{sql: `a = 1 OR (b = 2 OR c = 3)`, json: `{"or":[{"field":"a","op":"=","value":1},{"or":[{"field":"b","op":"=","value":2},{"field":"c","op":"=","value":3}]}]}`},
+		{sql: `NOT (name != 'Bob' OR "Email" ILIKE '%@x.com')`, json: `{"not":{"or":[{"field":"name","op":"!=","value":"Bob"},{"field":"Email","op":"ilike","value":"%@x.com"}]}}`},
+		{sql: `name IN ('a', 'b')`, json: `{"or":[{"field":"name","op":"=","value":"a"},{"field":"name","op":"=","value":"b"}]}`},
+		{sql: `has(labels.env) AND labels['app.kubernetes.io/name'] LIKE 'api%'`, json: `{"and":[{"has":"labels.env"},{"field":"labels['app.kubernetes.io/name']","op":"like","value":"api%"}]}`},
+		{sql: `ANY(tags) != 'go' OR ANY(tags) = ANY('a', 'b') OR ANY(tags) != ANY('c')`, json: `{"or":[{"any":"tags","op":"!=","value":"go"},{"any":"tags","op":"in","value":["a","b"]},{"any":"tags","op":"not in","value":["c"]}]}`},
+		{sql: `ANY(orders, status = 'paid') AND ALL(orders, ANY(items, qty < 2))`, json: `{"and":[{"any":"orders","where":{"field":"status","op":"=","value":"paid"}},{"all":"orders","where":{"any":"items","where":{"field":"qty","op":"<","value":2}}}]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sql, func(t *testing.T) {
+			query, err := Compile(tt.sql)
+			if err != nil {
+				t.Fatalf("Compile() unexpected error = %v", err)
+			}
+
+			data, err := query.MarshalJSON()
+			if err != nil {
+				t.Fatalf("MarshalJSON() unexpected error = %v", err)
+			}
+			if string(data) != tt.json {
+				t.Errorf("MarshalJSON() = %s, want %s", data, tt.json)
+			}
+
+			var decoded Query
+			if err := json.Unmarshal([]byte(tt.json), &decoded); err != nil {
+				t.Fatalf("json.Unmarshal() unexpected error = %v", err)
+			}
+			if !reflect.DeepEqual(&decoded, query) {
+				t.Errorf("json.Unmarshal() = %#v, want %#v", decoded.condition, query.condition)
+			}
+		})
+	}
+}
+
+func TestQueryJSONFunction(t *testing.T) {
+	query, err := Compile("lower(Email) = 'x'")
+	if err != nil {
+		t.Fatalf("Compile() unexpected error = %v", err)
+	}
+	if _, err := query.MarshalJSON(); err == nil {
+		t.Errorf("MarshalJSON() expected an error for a function")
+	}
+}
+
+func TestQueryJSONField(t *testing.T) {
+	var request struct {
+		Filter *Query `json:"filter"`
+		Limit  int    `json:"limit"`
+	}
+	body := `{"filter":{"field":"Age","op":"<","value":30},"limit":10}`
+	if err := json.Unmarshal([]byte(body), &request); err != nil {
+		t.Fatalf("json.Unmarshal() unexpected error = %v", err)
+	}
+	if result := ApplyQuery(request.Filter, testPeople); result.Count != 1 || result.Items[0].Name != "Alice" {
+		t.Errorf("ApplyQuery() = %v, want Alice", result.Items)
+	}
+
+	if err := json.Unmarshal([]byte(`{"filter":{"field":"Age"}}`), &request); err == nil {
+		t.Errorf("json.Unmarshal() expected an error for an invalid filter")
+	}
+}
@@ -0,0 +1,190 @@
+// Copyright 2023 Daniel Valdivia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rql
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// likePattern is a LIKE pattern compiled once for matching any number of values: % matches
+// any sequence of characters, _ matches a single character, and a backslash matches the
+// next character literally
+type likePattern struct {
+	tokens          []likeToken
+	caseInsensitive bool
+}
+
+// likeToken is a literal character or one of the wildcards of a LIKE pattern
+type likeToken struct {
+	wildcard rune   // '%' or '_' for wildcards, 0 for literal characters
+	r        rune   // The literal character
+	text     string // The literal character as written, which tells invalid UTF-8 bytes apart
+}
+
+// newLikeCondition returns a condition matching a field against a LIKE pattern, compiled
+// once for all items. A backslash ending the pattern matches itself, while the SQL-like
+// syntax rejects such patterns like PostgreSQL does
+func newLikeCondition(field string, pattern Literal, caseInsensitive bool) *LikeCondition {
+	return &LikeCondition{field: field, value: pattern, caseInsensitive: caseInsensitive, pattern: compileLike(pattern.text, caseInsensitive)}
+}
+
+// compileLike compiles a LIKE pattern, merging runs of % into one
+func compileLike(pattern string, caseInsensitive bool) likePattern {
+	compiled := likePattern{caseInsensitive: caseInsensitive}
+	escaped := false
+	for offset := 0; offset < len(pattern); {
+		r, size := utf8.DecodeRuneInString(pattern[offset:])
+		text := pattern[offset : offset+size]
+		offset += size
+
+		switch {
+		case escaped:
+			compiled.tokens = append(compiled.tokens, likeToken{r: r, text: text})
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '%' && len(compiled.tokens) > 0 && compiled.tokens[len(compiled.tokens)-1].wildcard == '%':
+		case r == '%' || r == '_':
+			compiled.tokens = append(compiled.tokens, likeToken{wildcard: r})
+		default:
+			compiled.tokens = append(compiled.tokens, likeToken{r: r, text: text})
+		}
+	}
+	if escaped {
+		compiled.tokens = append(compiled.tokens, likeToken{r: '\\', text: `\`})
+	}
+	return compiled
+}
+
+// match reports whether the whole value matches the pattern. After a mismatch it backtracks
+// to the last %, letting it match one more character, which is enough since a later %
+// can match anything an earlier one could
+func (p likePattern) match(value string) bool {
+	token, offset := 0, 0
+	starToken, starOffset := -1, 0
+	for offset < len(value) {
+		r, size := utf8.DecodeRuneInString(value[offset:])
+		switch {
+		case token < len(p.tokens) && p.tokens[token].wildcard == '%':
+			starToken, starOffset = token, offset
+			token++
+		case token < len(p.tokens) && (p.tokens[token].wildcard == '_' || p.tokens[token].wildcard == 0 && p.equal(p.tokens[token], value[offset:offset+size], r)):
+			token++
+			offset += size
+		case starToken >= 0:
+			_, skipped := utf8.DecodeRuneInString(value[starOffset:])
+			starOffset += skipped
+			token, offset = starToken+1, starOffset
+		default:
+			return false
+		}
+	}
+
+	for token < len(p.tokens) && p.tokens[token].wildcard == '%' {
+		token++
+	}
+	return token == len(p.tokens)
+}
+
+// equal compares a literal character of the pattern with one of the value, using Unicode
+// simple case folding for ILIKE, e.g. so that K matches the Kelvin sign
+func (p likePattern) equal(token likeToken, text string, value rune) bool {
+	if token.text == text {
+		return true
+	}
+	if !p.caseInsensitive || value == utf8.RuneError {
+		return false
+	}
+	for folded := unicode.SimpleFold(token.r); folded != token.r; folded = unicode.SimpleFold(folded) {
+		if folded == value {
+			return true
+		}
+	}
+	return false
+}
+
+// wildcards returns the number of wildcards in the pattern
+func (p likePattern) wildcards() int {
+	count := 0
+	for _, token := range p.tokens {
+		if token.wildcard != 0 {
+			count++
+		}
+	}
+	return count
+}
+
+// likeComparison builds the condition for LIKE or ILIKE, or their negation. Like other
+// negated comparisons, NOT LIKE doesn't match items missing the field
+func likeComparison(field string, pattern Literal, caseInsensitive, negate bool) (Condition, error) {
+	if err := validateLike(pattern.text); err != nil {
+		return nil, err
+	}
+
+	condition := newLikeCondition(field, pattern, caseInsensitive)
+	if negate {
+		return &AndCondition{left: &HasCondition{field: field}, right: &NotCondition{condition: condition}}, nil
+	}
+	return condition, nil
+}
+
+// validateLike rejects patterns ending with an unescaped backslash, like PostgreSQL does
+func validateLike(pattern string) error {
+	escaped := false
+	for _, r := range pattern {
+		escaped = !escaped && r == '\\'
+	}
+	if escaped {
+		return errors.New("LIKE pattern must not end with escape character")
+	}
+	return nil
+}
+
+// likeEscape rewrites a pattern given with an ESCAPE character to use backslashes, the
+// default escape character. An empty escape disables escaping, so backslashes match
+// themselves
+func likeEscape(pattern, escape string) (string, error) {
+	if escape == `\` {
+		return pattern, nil
+	}
+	if utf8.RuneCountInString(escape) > 1 {
+		return "", errors.New("LIKE escape must be empty or a single character")
+	}
+	escapeRune, _ := utf8.DecodeRuneInString(escape)
+
+	var b strings.Builder
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			b.WriteByte('\\')
+			b.WriteRune(r)
+			escaped = false
+		case escape != "" && r == escapeRune:
+			escaped = true
+		case r == '\\':
+			b.WriteString(`\\`)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	if escaped {
+		return "", errors.New("LIKE pattern must not end with escape character")
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2023 Daniel Valdivia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rql
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLikeConformance(t *testing.T) {
+	// Results as given by PostgreSQL for value LIKE pattern
+	tests := []struct {
+		value   string
+		filter  string
+		want    bool
+		wantErr string
+	}{
+		{value: "abc", filter: `v LIKE 'abc'`, want: true},
+		{value: "abc", filter: `v LIKE 'a%'`, want: true},
+		{value: "abc", filter: `v LIKE '_b_'`, want: true},
+		{value: "abc", filter: `v LIKE 'c'`, want: false},
+		{value: "abc", filter: `v LIKE 'ABC'`, want: false},
+		{value: "", filter: `v LIKE ''`, want: true},
+		{value: "", filter: `v LIKE '%'`, want: true},
+		{value: "", filter: `v LIKE '_'`, want: false},
+		{value: "a", filter: `v LIKE ''`, want: false},
+		{value: "abc", filter: `v LIKE '%%%'`, want: true},
+		{value: "abc", filter: `v LIKE '___'`, want: true},
+		{value: "abc", filter: `v LIKE '____'`, want: false},
+		{value: "abc", filter: `v LIKE '%_%_%_%'`, want: true},
+		{value: "xxaybxx", filter: `v LIKE '%a_b%'`, want: true},
+		{value: "xxabxx", filter: `v LIKE '%a_b%'`, want: false},
+		{value: "abcbc", filter: `v LIKE 'a%bc'`, want: true},
+		{value: "abcbd", filter: `v LIKE 'a%bc'`, want: false},
+		{value: "mississippi", filter: `v LIKE '%iss%ipp%'`, want: true},
+		{value: "mississippi", filter: `v LIKE 'm%ss%ss%pi'`, want: true},
+		{value: "mississippi", filter: `v LIKE 'm%ss%ss%ss%'`, want: false},
+		{value: "a.c", filter: `v LIKE 'a.c'`, want: true},
+		{value: "abc", filter: `v LIKE 'a.c'`, want: false},
+		{value: "a*c+[d]", filter: `v LIKE 'a*c+[d]'`, want: true},
+		{value: "a\nb", filter: `v LIKE 'a_b'`, want: true},
+		{value: "a\nb", filter: `v LIKE 'a%'`, want: true},
+		{value: "ñandú", filter: `v LIKE '_and_'`, want: true},
+		{value: "日本語", filter: `v LIKE '_本%'`, want: true},
+
+		// Escapes
+		{value: "a_c", filter: `v LIKE 'a\_c'`, want: true},
+		{value: "abc", filter: `v LIKE 'a\_c'`, want: false},
+		{value: "100%", filter: `v LIKE '100\%'`, want: true},
+		{value: "1000", filter: `v LIKE '100\%'`, want: false},
+		{value: `a\c`, filter: `v LIKE 'a\\c'`, want: true},
+		{value: "ab", filter: `v LIKE 'a\b'`, want: true},
+		{value: `a\b`, filter: `v LIKE 'a\b'`, want: false},
+		{value: "x", filter: `v LIKE 'x\'`, wantErr: "LIKE pattern must not end with escape character"},
+		{value: "100%", filter: `v LIKE '100!%' ESCAPE '!'`, want: true},
+		{value: "1000", filter: `v LIKE '100!%' ESCAPE '!'`, want: false},
+		{value: "a!", filter: `v LIKE 'a!!' ESCAPE '!'`, want: true},
+		{value: `a\b`, filter: `v LIKE 'a\b' ESCAPE '!'`, want: true},
+		{value: `a\_`, filter: `v LIKE 'a\_' ESCAPE ''`, want: true},
+		{value: `a\x`, filter: `v LIKE 'a\_' ESCAPE ''`, want: true},
+		{value: "a_", filter: `v LIKE 'a\_' ESCAPE '\'`, want: true},
+		{value: "a!", filter: `v LIKE 'a!' ESCAPE '!'`, wantErr: "LIKE pattern must not end with escape character"},
+		{value: "a", filter: `v LIKE 'a' ESCAPE '!!'`, wantErr: "LIKE escape must be empty or a single character"},
+		{value: "a", filter: `like_escape(v)`, wantErr: "like_escape() expects a field, a pattern and an escape character"},
+		{value: "a", filter: `like_escape()`, wantErr: "like_escape() expects a field, a pattern and an escape character"},
+		{value: "a", filter: `ilike_escape(v, 'a%')`, wantErr: "ilike_escape() expects a field, a pattern and an escape character"},
+		{value: "a", filter: `not_like_escape(v, 'a%', '!', '!')`, wantErr: "not_like_escape() expects a field, a pattern and an escape character"},
+
+		// ILIKE, with Unicode case folding
+		{value: "abc", filter: `v ILIKE 'ABC'`, want: true},
+		{value: "ÀBC", filter: `v ILIKE 'àb_'`, want: true},
+		{value: "STRASSE", filter: `v ILIKE 'straße'`, want: false},
+		{value: "Σ", filter: `v ILIKE 'σ'`, want: true},
+		{value: "kelvin", filter: "v ILIKE '\u212Aelvin'", want: true},
+		{value: "A_B", filter: `v ILIKE 'a#_b' ESCAPE '#'`, want: true},
+		{value: "AXB", filter: `v ILIKE 'a#_b' ESCAPE '#'`, want: false},
+
+		// Negations, which like other negated comparisons need the field
+		{value: "abc", filter: `v NOT LIKE 'a%'`, want: false},
+		{value: "abc", filter: `v NOT LIKE 'b%'`, want: true},
+		{value: "abc", filter: `v NOT ILIKE 'A%'`, want: false},
+		{value: "abc", filter: `v NOT ILIKE 'B%'`, want: true},
+		{value: "a%", filter: `v NOT LIKE 'a!%' ESCAPE '!'`, want: false},
+		{value: "ab", filter: `v NOT ILIKE 'A!%' ESCAPE '!'`, want: true},
+		{value: "abc", filter: `w NOT LIKE 'b%'`, want: false},
+		{value: "abc", filter: `w LIKE '%'`, want: false},
+	}
+
+	for _, tt := range tests {
+		query, err := Compile(tt.filter)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Compile(%q) error = %v, want %s", tt.filter, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Compile(%q) unexpected error = %v", tt.filter, err)
+			continue
+		}
+
+		if got := query.Match(map[string]any{"v": tt.value}); got != tt.want {
+			t.Errorf("%q with v = %q = %v, want %v", tt.filter, tt.value, got, tt.want)
+		}
+
+		// Patterns with another escape character are compiled to backslash escapes,
+		// which the canonical form keeps
+		canonical, err := Compile(query.String())
+		if err != nil {
+			t.Fatalf("Compile(%q) unexpected error = %v", query.String(), err)
+		}
+		if got := canonical.Match(map[string]any{"v": tt.value}); got != tt.want {
+			t.Errorf("%q with v = %q = %v, want %v", query.String(), tt.value, got, tt.want)
+		}
+	}
+}
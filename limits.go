@@ -0,0 +1,217 @@
+// Copyright 2023 Daniel Valdivia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rql
+
+import (
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// ErrRegexDisabled is returned for filters using regular expressions where Limits disable them
+var ErrRegexDisabled = errors.New("regular expressions are disabled")
+
+// Limits bounds the complexity of the filters accepted from clients, so that huge or
+// deeply nested filters are rejected before they cost much to parse or evaluate. Zero
+// values mean no limit
+type Limits struct {
+	MaxLength        int // Maximum length of a filter in characters
+	MaxDepth         int // Maximum nesting of parentheses, NOT, quantifiers and alternating AND and OR
+	MaxPredicates    int // Maximum number of predicates, counting each value of IN lists
+	MaxValues        int // Maximum number of values in an ANY list
+	MaxLikeWildcards int // Maximum number of unescaped % and _ wildcards in a LIKE pattern
+	MaxRegexLength   int // Maximum length of a regular expression in characters
+
+	// DisableRegex rejects the regular expression operators ~ and ~*, e.g. for untrusted
+	// callers or fields the database can't match efficiently
+	DisableRegex bool
+}
+
+// DefaultLimits returns limits that leave room for any filter written by hand
+func DefaultLimits() Limits {
+	return Limits{
+		MaxLength:        4096,
+		MaxDepth:         32,
+		MaxPredicates:    128,
+		MaxValues:        256,
+		MaxLikeWildcards: 16,
+		MaxRegexLength:   256,
+	}
+}
+
+// LimitError is returned for filters exceeding one of the limits
+type LimitError struct {
+	Limit string // The limit exceeded, e.g. "length" or "depth"
+	Value int
+	Max   int
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("filter %s %d exceeds the limit of %d", e.Limit, e.Value, e.Max)
+}
+
+// CompileWithLimits parses a SQL-like filter into a Query like Compile, rejecting filters
+// exceeding the limits with a *LimitError, or using regular expressions where they're
+// disabled with ErrRegexDisabled. The length and the nesting of parentheses are checked
+// before the filter is parsed
+func CompileWithLimits(filter string, limits Limits) (*Query, error) {
+	if err := limits.checkText(filter); err != nil {
+		return nil, err
+	}
+
+	query, err := Compile(filter)
+	if err != nil {
+		return nil, err
+	}
+	if err := query.CheckLimits(limits); err != nil {
+		return nil, err
+	}
+	return query, nil
+}
+
+// CheckLimits checks a compiled query against the limits, all but the length of the
+// filter it was compiled from. Filters in other syntaxes should have their length checked
+// before they are compiled, while their parsers stop at maxParseDepth
+func (q *Query) CheckLimits(limits Limits) error {
+	if q.condition == nil {
+		return nil
+	}
+
+	predicates := 0
+	depth, err := limits.checkCondition(q.condition, &predicates)
+	if err != nil {
+		return err
+	}
+	if exceeds(predicates, limits.MaxPredicates) {
+		return &LimitError{Limit: "number of predicates", Value: predicates, Max: limits.MaxPredicates}
+	}
+	if exceeds(depth, limits.MaxDepth) {
+		return &LimitError{Limit: "depth", Value: depth, Max: limits.MaxDepth}
+	}
+	return nil
+}
+
+// checkText checks the length of a filter and how deeply its parentheses are nested,
+// skipping those inside quoted strings and names
+func (l Limits) checkText(filter string) error {
+	if length := utf8.RuneCountInString(filter); exceeds(length, l.MaxLength) {
+		return &LimitError{Limit: "length", Value: length, Max: l.MaxLength}
+	}
+
+	nesting, maxNesting := 0, 0
+	var quote rune
+	for _, r := range filter {
+		switch {
+		case quote != 0:
+			// A doubled quote closes and reopens the string, which leaves it open
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '(':
+			nesting++
+			maxNesting = max(maxNesting, nesting)
+		case r == ')':
+			nesting--
+		}
+	}
+	if exceeds(maxNesting, l.MaxDepth) {
+		return &LimitError{Limit: "depth", Value: maxNesting, Max: l.MaxDepth}
+	}
+	return nil
+}
+
+// checkCondition returns the depth of a condition and adds its predicates to the count,
+// checking the limits on the values of each predicate
+func (l Limits) checkCondition(condition Condition, predicates *int) (int, error) {
+	var operands []Condition
+	switch c := condition.(type) {
+	case *AndCondition:
+		operands = flattenAnd(c)
+	case *OrCondition:
+		operands = flattenOr(c)
+	case *NotCondition:
+		operands = []Condition{c.condition}
+	case *QuantifierCondition:
+		*predicates++
+		operands = []Condition{c.condition}
+	case *FunctionCondition:
+		// The comparison of the function's result is the predicate, nested calls are
+		// bounded by the nesting of parentheses
+		return l.checkCondition(c.condition, predicates)
+	case *AnyArrayContainsAnyCondition:
+		*predicates++
+		if exceeds(len(c.values), l.MaxValues) {
+			return 0, &LimitError{Limit: "number of values", Value: len(c.values), Max: l.MaxValues}
+		}
+	case *AnyArrayNotContainsAnyCondition:
+		*predicates++
+		if exceeds(len(c.values), l.MaxValues) {
+			return 0, &LimitError{Limit: "number of values", Value: len(c.values), Max: l.MaxValues}
+		}
+	case *LikeCondition:
+		*predicates++
+		if wildcards := c.pattern.wildcards(); exceeds(wildcards, l.MaxLikeWildcards) {
+			return 0, &LimitError{Limit: "number of LIKE wildcards", Value: wildcards, Max: l.MaxLikeWildcards}
+		}
+	case *RegexCondition:
+		*predicates++
+		if l.DisableRegex {
+			return 0, fmt.Errorf("%w: %s", ErrRegexDisabled, formatCondition(c))
+		}
+		if length := utf8.RuneCountInString(c.value.text); exceeds(length, l.MaxRegexLength) {
+			return 0, &LimitError{Limit: "regular expression length", Value: length, Max: l.MaxRegexLength}
+		}
+	default:
+		*predicates++
+	}
+
+	depth := 0
+	for _, operand := range operands {
+		operandDepth, err := l.checkCondition(operand, predicates)
+		if err != nil {
+			return 0, err
+		}
+		depth = max(depth, operandDepth)
+	}
+	return depth + 1, nil
+}
+
+// maxParseDepth bounds the nesting of parentheses and negations accepted by the parsers of
+// the AIP-160, OData, SCIM and RSQL syntaxes, so that deeply nested filters are rejected
+// before they're compiled and checked against the caller's limits
+const maxParseDepth = 1000
+
+// parseDepth is the nesting depth of a recursive descent parser
+type parseDepth int
+
+// enter increments the depth, failing with a *LimitError beyond maxParseDepth
+func (d *parseDepth) enter() error {
+	if *d++; *d > maxParseDepth {
+		return &LimitError{Limit: "depth", Value: int(*d), Max: maxParseDepth}
+	}
+	return nil
+}
+
+// leave decrements the depth
+func (d *parseDepth) leave() {
+	*d--
+}
+
+// exceeds reports whether a value exceeds a limit, where zero means no limit
+func exceeds(value, limit int) bool {
+	return limit > 0 && value > limit
+}
@@ -0,0 +1,282 @@
+// Copyright 2023 Daniel Valdivia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rql
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompileWithLimits(t *testing.T) {
+	limits := Limits{MaxLength: 100, MaxDepth: 3, MaxPredicates: 4, MaxValues: 3, MaxLikeWildcards: 2, MaxRegexLength: 8}
+
+	tests := []struct {
+		name    string
+		filter  string
+		wantErr *LimitError
+	}{
+		{name: "within the limits", filter: "Age > 30 AND (Name LIKE 'A%' OR ANY(Tags) = ANY('go', 'react', 'python'))"},
+		{name: "long AND chains are not nested", filter: "Age > 20 AND Age > 21 AND Age > 22 AND Age > 23"},
+		{
+			name:    "too long",
+			filter:  "Name = '" + strings.Repeat("é", 100) + "'",
+			wantErr: &LimitError{Limit: "length", Value: 109, Max: 100},
+		},
+		{
+			name:    "nested parentheses",
+			filter:  "((((Age > 30))))",
+			wantErr: &LimitError{Limit: "depth", Value: 4, Max: 3},
+		},
+		{name: "parentheses in strings", filter: "Name = '((((' OR \"((((\" = ''''"},
+		{
+			name:    "alternating AND and OR",
+			filter:  "Age > 30 OR Active = true AND (Name = 'x' OR NOT Email = 'y')",
+			wantErr: &LimitError{Limit: "depth", Value: 5, Max: 3},
+		},
+		{
+			name:    "NOT chains",
+			filter:  "NOT NOT NOT Active = true",
+			wantErr: &LimitError{Limit: "depth", Value: 4, Max: 3},
+		},
+		{
+			name:    "quantifiers",
+			filter:  "ANY(Orders, ALL(Items, NOT Qty > 1))",
+			wantErr: &LimitError{Limit: "depth", Value: 4, Max: 3},
+		},
+		{
+			name:    "too many predicates",
+			filter:  "Age > 20 OR Age > 21 OR Age > 22 OR has(Name) OR true",
+			wantErr: &LimitError{Limit: "number of predicates", Value: 5, Max: 4},
+		},
+		{
+			name:    "IN lists count each value",
+			filter:  "Name IN ('a', 'b', 'c', 'd', 'e')",
+			wantErr: &LimitError{Limit: "number of predicates", Value: 5, Max: 4},
+		},
+		{
+			name:    "too many ANY values",
+			filter:  "ANY(Tags) != ANY('a', 'b', 'c', 'd')",
+			wantErr: &LimitError{Limit: "number of values", Value: 4, Max: 3},
+		},
+		{
+			name:    "too many LIKE wildcards",
+			filter:  "Name ILIKE '%a_%'",
+			wantErr: &LimitError{Limit: "number of LIKE wildcards", Value: 3, Max: 2},
+		},
+		{name: "functions", filter: "lower(Name) IN ('a', 'b') AND length(trim(Email)) > 3"},
+		{
+			name:    "nested functions",
+			filter:  "lower(trim(upper(trim(Name)))) = 'x'",
+			wantErr: &LimitError{Limit: "depth", Value: 4, Max: 3},
+		},
+		{name: "short regular expression", filter: "Email ~* '^[a-c]é$'"},
+		{
+			name:    "too long regular expression",
+			filter:  "Name !~ '^(a|b|c)+$'",
+			wantErr: &LimitError{Limit: "regular expression length", Value: 10, Max: 8},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := CompileWithLimits(tt.filter, limits)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("CompileWithLimits(%q) unexpected error = %v", tt.filter, err)
+				}
+				want, _ := Compile(tt.filter)
+				if !reflect.DeepEqual(query, want) {
+					t.Errorf("CompileWithLimits(%q) = %v, want %v", tt.filter, query, want)
+				}
+				return
+			}
+
+			var limitErr *LimitError
+			if !errors.As(err, &limitErr) {
+				t.Fatalf("CompileWithLimits(%q) error = %v, want a *LimitError", tt.filter, err)
+			}
+			if *limitErr != *tt.wantErr {
+				t.Errorf("CompileWithLimits(%q) error = %+v, want %+v", tt.filter, limitErr, tt.wantErr)
+			}
+		})
+	}
+
+	// Zero limits don't limit anything
+	if _, err := CompileWithLimits("NOT NOT NOT NOT ((((Name LIKE '%%%%'))))", Limits{}); err != nil {
+		t.Errorf("CompileWithLimits() unexpected error = %v without limits", err)
+	}
+
+	// Queries from other syntaxes are checked once compiled
+	query, err := CompileOData("Tags/any(t: t eq 'a' or t eq 'b' or t eq 'c' or t eq 'd')")
+	if err != nil {
+		t.Fatalf("CompileOData() unexpected error = %v", err)
+	}
+	wantErr := &LimitError{Limit: "number of predicates", Value: 5, Max: 4}
+	if err := query.CheckLimits(limits); !reflect.DeepEqual(err, wantErr) {
+		t.Errorf("CheckLimits() error = %v, want %v", err, wantErr)
+	}
+	if got, want := wantErr.Error(), "filter number of predicates 5 exceeds the limit of 4"; got != want {
+		t.Errorf("Error() = %s, want %s", got, want)
+	}
+	if err := (&Query{}).CheckLimits(limits); err != nil {
+		t.Errorf("CheckLimits() unexpected error = %v for an empty query", err)
+	}
+
+	// Regular expressions can be disabled altogether, wherever they appear
+	limits = DefaultLimits()
+	limits.DisableRegex = true
+	for _, filter := range []string{"Name ~ 'a'", "Age > 30 OR NOT Email !~* 'x'", "ANY(Tags, \"\" ~ '^go')"} {
+		if _, err := CompileWithLimits(filter, limits); !errors.Is(err, ErrRegexDisabled) {
+			t.Errorf("CompileWithLimits(%q) error = %v, want ErrRegexDisabled", filter, err)
+		}
+	}
+	if _, err := CompileWithLimits("Name LIKE '%~%'", limits); err != nil {
+		t.Errorf("CompileWithLimits() unexpected error = %v with regular expressions disabled", err)
+	}
+}
+
+func TestCompileWithLimitsAbusiveFilters(t *testing.T) {
+	filters := []string{
+		strings.Repeat("(", 100000) + "Age > 30" + strings.Repeat(")", 100000),
+		strings.Repeat("NOT ", 100000) + "Age > 30",
+		strings.Repeat("Age > 30 OR ", 100000) + "Age > 30",
+		"Name IN (" + strings.Repeat("'a', ", 100000) + "'a')",
+		"ANY(Tags) = ANY(" + strings.Repeat("'a', ", 100000) + "'a')",
+		"Name LIKE '" + strings.Repeat("%a", 100000) + "'",
+	}
+
+	for _, filter := range filters {
+		if _, err := CompileWithLimits(filter, DefaultLimits()); !errors.As(err, new(*LimitError)) {
+			t.Errorf("CompileWithLimits(%.20q...) error = %v, want a *LimitError", filter, err)
+		}
+	}
+
+	// Filters within the default limits are accepted
+	filters = []string{
+		strings.Repeat("(", 32) + "Age > 30" + strings.Repeat(")", 32),
+		strings.Repeat("NOT ", 31) + "Age > 30",
+		strings.Repeat("Age > 30 OR ", 127) + "Age > 30",
+		"Name LIKE '" + strings.Repeat("%a", 8) + "'",
+	}
+	for _, filter := range filters {
+		if _, err := CompileWithLimits(filter, DefaultLimits()); err != nil {
+			t.Errorf("CompileWithLimits(%.20q...) unexpected error = %v", filter, err)
+		}
+	}
+}
+
+// compilers are the front-ends for the filter syntaxes taking a filter as text
+var compilers = map[string]func(filter string) (*Query, error){
+	"Compile": Compile,
+	"CompileWithLimits": func(filter string) (*Query, error) {
+		return CompileWithLimits(filter, DefaultLimits())
+	},
+	"CompileAIP160": func(filter string) (*Query, error) {
+		return CompileAIP160(filter)
+	},
+	"CompileOData": CompileOData,
+	"CompileSCIM": func(filter string) (*Query, error) {
+		return CompileSCIM(filter)
+	},
+	"CompileRSQL": CompileRSQL,
+}
+
+func TestCompileMalformedCalls(t *testing.T) {
+	// Calls of the names the parser and rewriteAnyOperators produce for other syntax, and of
+	// functions, with the wrong arguments
+	filters := []string{
+		"like_escape(Name)",
+		"like_escape()",
+		"ilike_escape(Name, 'a%')",
+		"not_ilike_escape(Name, 'a%', '!', '!')",
+		"any(Name)",
+		`"any"(Name)`,
+		`"all"()`,
+		`"any"(Name, Age > 1, Age < 2)`,
+		"ANY()",
+		"ANY(Tags) = ANY()",
+		"has()",
+		"has(Name, Email)",
+		"lower() = 'a'",
+		"coalesce() = 'a'",
+		"substring(Name) = 'a'",
+		"like_escape(a) eq 1",
+		"any(a)",
+	}
+
+	for name, compile := range compilers {
+		for _, filter := range filters {
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						t.Errorf("%s(%q) panicked: %v", name, filter, r)
+					}
+				}()
+				if query, err := compile(filter); err == nil {
+					query.Match(testPeople[0])
+				}
+			}()
+		}
+	}
+}
+
+func TestCompileDeeplyNested(t *testing.T) {
+	const depth = 100000
+	filters := map[string]string{
+		"CompileAIP160": strings.Repeat("(", depth) + "a = 1" + strings.Repeat(")", depth),
+		"CompileOData":  strings.Repeat("not ", depth) + strings.Repeat("(", depth) + "a eq 1" + strings.Repeat(")", depth),
+		"CompileSCIM":   strings.Repeat("not (", depth) + `a eq 1` + strings.Repeat(")", depth),
+		"CompileRSQL":   strings.Repeat("(", depth) + "a==1" + strings.Repeat(")", depth),
+	}
+
+	for name, filter := range filters {
+		if _, err := compilers[name](filter); !errors.As(err, new(*LimitError)) {
+			t.Errorf("%s(%.20q...) error = %v, want a *LimitError", name, filter, err)
+		}
+	}
+}
+
+func FuzzCompileWithLimits(f *testing.F) {
+	for _, filter := range []string{
+		"Age > 30 AND (Name LIKE 'A%' OR ANY(Tags) = ANY('go', 'react'))",
+		"NOT (Department.Name = 'Sales' OR has(Metadata.Categories))",
+		"ALL(Scores, \"\" >= 85) AND Name IN ('Alice', 'Bob')",
+		"((((Age > 30))))",
+		"Name = '(((' OR Email ILIKE '%@example.com'",
+		"like_escape(a)",
+		"ilike_escape(a, 'b')",
+		"any(a)",
+		`"all"(a)`,
+		"has()",
+	} {
+		f.Add(filter)
+	}
+
+	f.Fuzz(func(t *testing.T, filter string) {
+		query, err := CompileWithLimits(filter, DefaultLimits())
+		if err != nil {
+			return
+		}
+		if err := query.CheckLimits(DefaultLimits()); err != nil {
+			t.Errorf("CheckLimits() error = %v for a query compiled within the limits", err)
+		}
+		for _, person := range testPeople {
+			query.Match(person)
+		}
+		_ = query.String()
+	})
+}
@@ -0,0 +1,249 @@
+// Copyright 2023 Daniel Valdivia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rql
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// MongoOptions contains configuration options for translating a query into a MongoDB filter
+type MongoOptions struct {
+	// Fields maps the field names accepted in filters to document field paths, matching
+	// field names case-insensitively. Fields inside a quantifier are looked up by their full
+	// path, e.g. "Orders.Status", and must map below the quantified field. When nil, field
+	// paths are used as parsed, and like SQL identifiers unquoted names are lowercased, so
+	// names with capitals must be quoted in the filter, e.g. "userName" = 'alice'
+	Fields map[string]string
+}
+
+// ToMongo translates the query into a MongoDB filter document. The result only contains
+// maps, slices and scalar values, so it can be passed to the MongoDB driver directly or
+// converted to a bson.M. An empty query translates to an empty document
+func (q *Query) ToMongo(options MongoOptions) (map[string]any, error) {
+	if q.condition == nil {
+		return map[string]any{}, nil
+	}
+
+	w := &mongoWriter{fields: options.Fields}
+	return w.filter(q.condition)
+}
+
+// mongoWriter translates conditions into MongoDB filter documents
+type mongoWriter struct {
+	fields map[string]string
+
+	// The filter and document paths of the field an enclosing quantifier iterates over
+	scope     string
+	scopePath string
+}
+
+// filter translates a condition into a filter document
+func (w *mongoWriter) filter(condition Condition) (map[string]any, error) {
+	switch c := condition.(type) {
+	case *AndCondition:
+		return w.logical("$and", flattenAnd(c))
+	case *OrCondition:
+		return w.logical("$or", flattenOr(c))
+	case *NotCondition:
+		operand, err := w.filter(c.condition)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"$nor": []any{operand}}, nil
+	case *ConstantCondition:
+		if c.value {
+			return map[string]any{}, nil
+		}
+		return map[string]any{"$expr": false}, nil
+	case *EqualCondition:
+		return w.fieldFilter(c.field, map[string]any{"$eq": c.value.Value()})
+	case *NotEqualCondition:
+		// $ne alone also matches documents without the field
+		return w.fieldFilter(c.field, map[string]any{"$exists": true, "$ne": c.value.Value()})
+	case *ComparisonCondition:
+		operators := map[string]string{">": "$gt", ">=": "$gte", "<": "$lt", "<=": "$lte"}
+		return w.fieldFilter(c.field, map[string]any{operators[c.operator]: c.value.Value()})
+	case *LikeCondition:
+		options := "s"
+		if c.caseInsensitive {
+			options = "is"
+		}
+		return w.fieldFilter(c.field, map[string]any{"$regex": likeRegexp(c.value.text), "$options": options})
+	case *RegexCondition:
+		if c.caseInsensitive {
+			return w.fieldFilter(c.field, map[string]any{"$regex": c.value.text, "$options": "i"})
+		}
+		return w.fieldFilter(c.field, map[string]any{"$regex": c.value.text})
+	case *HasCondition:
+		return w.fieldFilter(c.field, map[string]any{"$exists": true, "$ne": nil})
+	case *AnyArrayContainsCondition:
+		return w.fieldFilter(c.field, map[string]any{"$elemMatch": map[string]any{"$eq": c.value.Value()}})
+	case *AnyArrayNotContainsCondition:
+		return w.fieldFilter(c.field, noElementMatches(map[string]any{"$eq": c.value.Value()}))
+	case *AnyArrayContainsAnyCondition:
+		return w.fieldFilter(c.field, map[string]any{"$elemMatch": map[string]any{"$in": typedValues(c.values)}})
+	case *AnyArrayNotContainsAnyCondition:
+		return w.fieldFilter(c.field, noElementMatches(map[string]any{"$in": typedValues(c.values)}))
+	case *QuantifierCondition:
+		return w.quantifier(c)
+	case *FunctionCondition:
+		// Query operators compare stored values, computing values needs $expr
+		return nil, fmt.Errorf("function %s cannot be translated to MongoDB", c.function.name)
+	}
+
+	return nil, fmt.Errorf("unsupported condition %T", condition)
+}
+
+// logical translates the operands of a chain of AND or OR conditions. Equalities on the same
+// field joined by OR become $in, and inequalities joined by AND become $nin, which is how IN
+// and NOT IN lists arrive here
+func (w *mongoWriter) logical(operator string, operands []Condition) (map[string]any, error) {
+	merged := mergeFieldValues(operands, operator == "$or")
+
+	filters := make([]any, 0, len(merged))
+	for _, operand := range merged {
+		var filter map[string]any
+		var err error
+		switch o := operand.(type) {
+		case fieldValues:
+			if operator == "$or" {
+				filter, err = w.fieldFilter(o.field, map[string]any{"$in": typedValues(o.values)})
+			} else {
+				filter, err = w.fieldFilter(o.field, map[string]any{"$exists": true, "$nin": typedValues(o.values)})
+			}
+		case Condition:
+			filter, err = w.filter(o)
+		}
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+
+	if len(filters) == 1 {
+		return filters[0].(map[string]any), nil
+	}
+	return map[string]any{operator: filters}, nil
+}
+
+// quantifier translates ANY(field, predicate) and ALL(field, predicate) into $elemMatch, with
+// the predicate's fields resolved relative to the array elements
+func (w *mongoWriter) quantifier(c *QuantifierCondition) (map[string]any, error) {
+	path, err := w.path(c.field)
+	if err != nil {
+		return nil, err
+	}
+
+	nested := &mongoWriter{fields: w.fields, scope: w.scopedField(c.field), scopePath: w.scopedPath(path)}
+	predicate, err := nested.filter(c.condition)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.all {
+		// ALL holds when no element fails the predicate, including for empty arrays
+		return map[string]any{path: noElementMatches(map[string]any{"$nor": []any{predicate}})}, nil
+	}
+	return map[string]any{path: map[string]any{"$elemMatch": predicate}}, nil
+}
+
+// fieldFilter returns a filter applying operator expressions to a field
+func (w *mongoWriter) fieldFilter(field string, expression map[string]any) (map[string]any, error) {
+	path, err := w.path(field)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{path: expression}, nil
+}
+
+// path returns the document path for a field. Without a field mapping the path is the field
+// as written, so its parts must be valid MongoDB field names
+func (w *mongoWriter) path(field string) (string, error) {
+	if w.fields == nil {
+		if path, ok := documentPath(field); ok && !strings.HasPrefix(path, "$") && !strings.Contains(path, ".$") {
+			return path, nil
+		}
+		return "", fmt.Errorf("field %s cannot be used in MongoDB filters", field)
+	}
+
+	path, ok := mappedField(w.fields, w.scopedField(field))
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnmappedField, w.scopedField(field))
+	}
+	if w.scopePath != "" {
+		relative, ok := strings.CutPrefix(path, w.scopePath+".")
+		if !ok {
+			return "", fmt.Errorf("field %s is not mapped below %s", w.scopedField(field), w.scopePath)
+		}
+		path = relative
+	}
+	return path, nil
+}
+
+// scopedField returns the full filter path of a field inside the enclosing quantifiers
+func (w *mongoWriter) scopedField(field string) string {
+	if w.scope == "" {
+		return field
+	}
+	return formatFieldPath(append(splitFieldPath(w.scope), splitFieldPath(field)...))
+}
+
+// scopedPath returns the full document path of a path relative to the enclosing quantifiers
+func (w *mongoWriter) scopedPath(path string) string {
+	if w.scopePath == "" {
+		return path
+	}
+	return w.scopePath + "." + path
+}
+
+// noElementMatches returns an expression matching arrays where no element matches the given
+// expression, including empty arrays but not missing fields or scalars
+func noElementMatches(expression map[string]any) map[string]any {
+	return map[string]any{"$type": "array", "$not": map[string]any{"$elemMatch": expression}}
+}
+
+// likeRegexp converts a LIKE pattern into an anchored regular expression: % matches any
+// sequence of characters, _ matches a single character, a backslash escapes the next
+// character and everything else matches literally. The end is anchored with \z, since in
+// PCRE $ also matches before a trailing newline
+func likeRegexp(pattern string) string {
+	var b strings.Builder
+	b.WriteByte('^')
+
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '%':
+			b.WriteString(".*")
+		case r == '_':
+			b.WriteByte('.')
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	if escaped {
+		b.WriteString(`\\`)
+	}
+
+	b.WriteString(`\z`)
+	return b.String()
+}
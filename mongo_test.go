@@ -0,0 +1,250 @@
+// Copyright 2023 Daniel Valdivia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rql
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// M and A shorten the expected MongoDB documents, like bson.M and bson.A
+type (
+	M = map[string]any
+	A = []any
+)
+
+func TestToMongo(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter string
+		want   M
+	}{
+		{
+			name:   "empty filter",
+			filter: "",
+			want:   M{},
+		},
+		{
+			name:   "equality",
+			filter: "Name = 'Alice'",
+			want:   M{"name": M{"$eq": "Alice"}},
+		},
+		{
+			name:   "quoted names keep their case",
+			filter: `"userName" = 'alice'`,
+			want:   M{"userName": M{"$eq": "alice"}},
+		},
+		{
+			name:   "values that look like operators stay values",
+			filter: "Name = '$ne'",
+			want:   M{"name": M{"$eq": "$ne"}},
+		},
+		{
+			name:   "AND chains are flattened",
+			filter: "Age >= 30 AND Age < 40.5 AND Active = true AND Name != 'Bob'",
+			want: M{"$and": A{
+				M{"age": M{"$gte": int64(30)}},
+				M{"age": M{"$lt": 40.5}},
+				M{"active": M{"$eq": true}},
+				M{"name": M{"$exists": true, "$ne": "Bob"}},
+			}},
+		},
+		{
+			name:   "OR nested under AND",
+			filter: "Age > 30 AND (Name = 'Alice' OR Age <= 25)",
+			want: M{"$and": A{
+				M{"age": M{"$gt": int64(30)}},
+				M{"$or": A{M{"name": M{"$eq": "Alice"}}, M{"age": M{"$lte": int64(25)}}}},
+			}},
+		},
+		{
+			name:   "NOT",
+			filter: "NOT (Active = false)",
+			want:   M{"$nor": A{M{"active": M{"$eq": false}}}},
+		},
+		{
+			name:   "IN",
+			filter: "ID IN (1, 2, 3)",
+			want:   M{"id": M{"$in": A{int64(1), int64(2), int64(3)}}},
+		},
+		{
+			name:   "NOT IN",
+			filter: "Name NOT IN ('Bob', 'Eve')",
+			want:   M{"name": M{"$exists": true, "$nin": A{"Bob", "Eve"}}},
+		},
+		{
+			name:   "lists are merged within longer chains",
+			filter: "Age > 30 AND Name NOT IN ('Bob', 'Eve') OR ID = 1 OR Name = 'Zed' OR ID = 2",
+			want: M{"$or": A{
+				M{"$and": A{
+					M{"age": M{"$gt": int64(30)}},
+					M{"name": M{"$exists": true, "$nin": A{"Bob", "Eve"}}},
+				}},
+				M{"id": M{"$in": A{int64(1), int64(2)}}},
+				M{"name": M{"$eq": "Zed"}},
+			}},
+		},
+		{
+			name:   "LIKE",
+			filter: "Email LIKE '%@example.com'",
+			want:   M{"email": M{"$regex": `^.*@example\.com\z`, "$options": "s"}},
+		},
+		{
+			name:   "ILIKE with single character wildcards",
+			filter: "Name ILIKE 'a_i(e)%'",
+			want:   M{"name": M{"$regex": `^a.i\(e\).*\z`, "$options": "is"}},
+		},
+		{
+			name:   "LIKE escapes",
+			filter: `Name LIKE '100\%\_[a-z]+'`,
+			want:   M{"name": M{"$regex": `^100%_\[a-z\]\+\z`, "$options": "s"}},
+		},
+		{
+			name:   "regular expression",
+			filter: `Email ~ '@example\.com$'`,
+			want:   M{"email": M{"$regex": `@example\.com$`}},
+		},
+		{
+			name:   "case-insensitive regular expression",
+			filter: "Name ~* '^(a|e)'",
+			want:   M{"name": M{"$regex": "^(a|e)", "$options": "i"}},
+		},
+		{
+			name:   "presence",
+			filter: "has(Email)",
+			want:   M{"email": M{"$exists": true, "$ne": nil}},
+		},
+		{
+			name:   "map keys",
+			filter: "Labels['env'] = 'prod' AND Labels ? 'tier'",
+			want: M{"$and": A{
+				M{"labels.env": M{"$eq": "prod"}},
+				M{"labels.tier": M{"$exists": true, "$ne": nil}},
+			}},
+		},
+		{
+			name:   "ANY element equals",
+			filter: "ANY(Tags) = 'go'",
+			want:   M{"tags": M{"$elemMatch": M{"$eq": "go"}}},
+		},
+		{
+			name:   "no element equals",
+			filter: "'go' <> ALL(Tags)",
+			want:   M{"tags": M{"$type": "array", "$not": M{"$elemMatch": M{"$eq": "go"}}}},
+		},
+		{
+			name:   "ANY element equals any value",
+			filter: "ANY(Tags) = ANY('go', 'rust')",
+			want:   M{"tags": M{"$elemMatch": M{"$in": A{"go", "rust"}}}},
+		},
+		{
+			name:   "no element equals any value",
+			filter: "ANY(Scores) != ANY(90, 85)",
+			want:   M{"scores": M{"$type": "array", "$not": M{"$elemMatch": M{"$in": A{int64(90), int64(85)}}}}},
+		},
+		{
+			name:   "ANY quantifier",
+			filter: "ANY(Orders, Status = 'paid' AND Total > 100)",
+			want: M{"orders": M{"$elemMatch": M{"$and": A{
+				M{"status": M{"$eq": "paid"}},
+				M{"total": M{"$gt": int64(100)}},
+			}}}},
+		},
+		{
+			name:   "ALL quantifier",
+			filter: "ALL(Orders, Shipped = true)",
+			want:   M{"orders": M{"$type": "array", "$not": M{"$elemMatch": M{"$nor": A{M{"shipped": M{"$eq": true}}}}}}},
+		},
+		{
+			name:   "constants",
+			filter: "true OR false",
+			want:   M{"$or": A{M{}, M{"$expr": false}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := Compile(tt.filter)
+			if err != nil {
+				t.Fatalf("Compile() unexpected error = %v", err)
+			}
+
+			got, err := query.ToMongo(MongoOptions{})
+			if err != nil {
+				t.Fatalf("ToMongo() unexpected error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ToMongo() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToMongoFields(t *testing.T) {
+	options := MongoOptions{Fields: map[string]string{
+		"Name":            "name",
+		"Department.Name": "department.name",
+		"Orders":          "orders",
+		"Orders.Status":   "orders.status",
+		"Orders.Total":    "totals",
+	}}
+
+	query, err := Compile("name = 'Alice' AND Department.Name = 'HR' AND ANY(Orders, Status = 'paid')")
+	if err != nil {
+		t.Fatalf("Compile() unexpected error = %v", err)
+	}
+	got, err := query.ToMongo(options)
+	if err != nil {
+		t.Fatalf("ToMongo() unexpected error = %v", err)
+	}
+	want := M{"$and": A{
+		M{"name": M{"$eq": "Alice"}},
+		M{"department.name": M{"$eq": "HR"}},
+		M{"orders": M{"$elemMatch": M{"status": M{"$eq": "paid"}}}},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ToMongo() = %#v, want %#v", got, want)
+	}
+
+	errorTests := []struct {
+		name         string
+		filter       string
+		options      MongoOptions
+		wantUnmapped bool
+	}{
+		{name: "unmapped field", filter: "Email = 'x'", options: options, wantUnmapped: true},
+		{name: "unmapped field in a quantifier", filter: "ANY(Orders, Shipped = true)", options: options, wantUnmapped: true},
+		{name: "field mapped outside the quantified array", filter: "ANY(Orders, Total > 1)", options: options},
+		{name: "operator as field name", filter: `"$where" = 'sleep(1000)'`},
+		{name: "dotted map key", filter: "Labels['app.kubernetes.io/name'] = 'api'"},
+		{name: "function", filter: "lower(Email) = 'x'"},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := Compile(tt.filter)
+			if err != nil {
+				t.Fatalf("Compile() unexpected error = %v", err)
+			}
+			_, err = query.ToMongo(tt.options)
+			if err == nil {
+				t.Fatalf("ToMongo() expected an error")
+			}
+			if got := errors.Is(err, ErrUnmappedField); got != tt.wantUnmapped {
+				t.Errorf("ToMongo() error = %v, errors.Is(ErrUnmappedField) = %v, want %v", err, got, tt.wantUnmapped)
+			}
+		})
+	}
+}
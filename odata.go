@@ -0,0 +1,466 @@
+// Copyright 2023 Daniel Valdivia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// CompileOData parses an OData v4 $filter expression, e.g.
+//
+//	Age ge 30 and startswith(Name,'A') and Tags/any(t: t eq 'go')
+//
+// It supports the eq, ne, gt, ge, lt, le and in operators, and, or, not and parentheses,
+// the contains, startswith and endswith functions, comparisons with null, and the any and
+// all lambda operators over collections, whose lambda variable refers to each element.
+// Property paths use / as separator, e.g. Department/Name. Arithmetic operators and other
+// functions are not supported
+func CompileOData(filter string) (*Query, error) {
+	tokens, err := lexOData(filter)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 1 {
+		return &Query{}, nil
+	}
+
+	p := &odataParser{tokens: tokens}
+	condition, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != odataEOF {
+		return nil, p.unexpected()
+	}
+	return &Query{condition: condition}, nil
+}
+
+// odataTokenKind is the kind of a token in an OData filter
+type odataTokenKind int
+
+const (
+	odataEOF        odataTokenKind = iota
+	odataIdentifier                // A property, function, operator or keyword
+	odataString                    // A quoted string, already unescaped
+	odataNumber                    // A number or a date and time, which start with a digit
+	odataLeftParen
+	odataRightParen
+	odataComma
+	odataSlash
+	odataColon
+)
+
+// odataToken is a token in an OData filter
+type odataToken struct {
+	kind odataTokenKind
+	text string
+	pos  int
+}
+
+// lexOData splits an OData filter into tokens, ending with an EOF token
+func lexOData(filter string) ([]odataToken, error) {
+	var tokens []odataToken
+	punctuation := map[byte]odataTokenKind{'(': odataLeftParen, ')': odataRightParen, ',': odataComma, '/': odataSlash, ':': odataColon}
+
+	for pos := 0; pos < len(filter); {
+		ch := filter[pos]
+		start := pos
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			pos++
+		case punctuation[ch] != odataEOF:
+			tokens = append(tokens, odataToken{kind: punctuation[ch], text: string(ch), pos: start})
+			pos++
+		case ch == '\'':
+			// Quotes inside strings are doubled
+			var text strings.Builder
+			for pos++; ; pos++ {
+				if pos >= len(filter) {
+					return nil, fmt.Errorf("unterminated string at position %d", start)
+				}
+				if filter[pos] == '\'' {
+					if pos+1 < len(filter) && filter[pos+1] == '\'' {
+						text.WriteByte('\'')
+						pos++
+						continue
+					}
+					break
+				}
+				text.WriteByte(filter[pos])
+			}
+			pos++
+			tokens = append(tokens, odataToken{kind: odataString, text: text.String(), pos: start})
+		case ch >= '0' && ch <= '9' || ch == '-' && pos+1 < len(filter) && filter[pos+1] >= '0' && filter[pos+1] <= '9':
+			// Numbers, dates and timestamps such as 2024-01-15T10:30:00Z
+			for pos++; pos < len(filter) && (isIdentChar(filter[pos]) || strings.IndexByte(".:+-", filter[pos]) >= 0); pos++ {
+			}
+			tokens = append(tokens, odataToken{kind: odataNumber, text: filter[start:pos], pos: start})
+		case isIdentChar(ch):
+			for pos++; pos < len(filter) && isIdentChar(filter[pos]); pos++ {
+			}
+			tokens = append(tokens, odataToken{kind: odataIdentifier, text: filter[start:pos], pos: start})
+		default:
+			return nil, fmt.Errorf("unexpected character %q at position %d", ch, pos)
+		}
+	}
+
+	return append(tokens, odataToken{kind: odataEOF, pos: len(filter)}), nil
+}
+
+// odataParser is a recursive descent parser for OData filters
+type odataParser struct {
+	tokens []odataToken
+	pos    int
+	depth  parseDepth
+
+	// The lambda variable of the innermost any or all operator, whose properties are
+	// resolved against each element of the collection
+	variable string
+}
+
+// peek returns the current token
+func (p *odataParser) peek() odataToken {
+	return p.tokens[p.pos]
+}
+
+// next returns the current token and moves past it
+func (p *odataParser) next() odataToken {
+	token := p.tokens[p.pos]
+	if token.kind != odataEOF {
+		p.pos++
+	}
+	return token
+}
+
+// expect consumes a token of the given kind
+func (p *odataParser) expect(kind odataTokenKind) error {
+	if p.peek().kind != kind {
+		return p.unexpected()
+	}
+	p.next()
+	return nil
+}
+
+// isKeyword reports whether the current token is the given keyword, ignoring case
+func (p *odataParser) isKeyword(keyword string) bool {
+	token := p.peek()
+	return token.kind == odataIdentifier && strings.EqualFold(token.text, keyword)
+}
+
+// unexpected returns an error for the current token
+func (p *odataParser) unexpected() error {
+	token := p.peek()
+	if token.kind == odataEOF {
+		return fmt.Errorf("unexpected end of filter")
+	}
+	return fmt.Errorf("unexpected %q at position %d", token.text, token.pos)
+}
+
+// or parses expressions joined by or
+func (p *odataParser) or() (Condition, error) {
+	if err := p.depth.enter(); err != nil {
+		return nil, err
+	}
+	defer p.depth.leave()
+	condition, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("or") {
+		p.next()
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		condition = &OrCondition{left: condition, right: right}
+	}
+	return condition, nil
+}
+
+// and parses expressions joined by and
+func (p *odataParser) and() (Condition, error) {
+	condition, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("and") {
+		p.next()
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		condition = &AndCondition{left: condition, right: right}
+	}
+	return condition, nil
+}
+
+// unary parses an expression optionally negated with not
+func (p *odataParser) unary() (Condition, error) {
+	if p.isKeyword("not") {
+		if err := p.depth.enter(); err != nil {
+			return nil, err
+		}
+		defer p.depth.leave()
+		p.next()
+		condition, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &NotCondition{condition: condition}, nil
+	}
+	return p.comparison()
+}
+
+// comparison parses a parenthesized expression, a function call, a lambda or a comparison
+func (p *odataParser) comparison() (Condition, error) {
+	token := p.peek()
+	switch {
+	case token.kind == odataLeftParen:
+		p.next()
+		condition, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		return condition, p.expect(odataRightParen)
+	case token.kind != odataIdentifier:
+		return nil, p.unexpected()
+	case p.tokens[p.pos+1].kind == odataLeftParen:
+		return p.function()
+	}
+
+	path, err := p.path()
+	if err != nil {
+		return nil, err
+	}
+
+	// A lambda operator applies a predicate to the elements of a collection
+	if p.peek().kind == odataSlash {
+		return p.lambda(path)
+	}
+
+	operator := p.peek()
+	if operator.kind != odataIdentifier || p.isKeyword("and") || p.isKeyword("or") {
+		// A bare property is a boolean condition
+		return &EqualCondition{field: path, value: Literal{text: "true", kind: BoolLiteral}}, nil
+	}
+	p.next()
+
+	if strings.EqualFold(operator.text, "in") {
+		values, err := p.valueList()
+		if err != nil {
+			return nil, err
+		}
+		return inCondition(path, values, false), nil
+	}
+
+	value, isNull, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(operator.text) {
+	case "eq":
+		if isNull {
+			return &NotCondition{condition: &HasCondition{field: path}}, nil
+		}
+		return &EqualCondition{field: path, value: value}, nil
+	case "ne":
+		if isNull {
+			return &HasCondition{field: path}, nil
+		}
+		return &NotEqualCondition{field: path, value: value}, nil
+	case "gt", "ge", "lt", "le":
+		if isNull {
+			return nil, fmt.Errorf("cannot compare %s with null using %s", path, operator.text)
+		}
+		operators := map[string]string{"gt": ">", "ge": ">=", "lt": "<", "le": "<="}
+		return &ComparisonCondition{field: path, value: value, operator: operators[strings.ToLower(operator.text)]}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported operator %q at position %d", operator.text, operator.pos)
+}
+
+// function parses a call to contains, startswith or endswith
+func (p *odataParser) function() (Condition, error) {
+	name := p.next()
+	p.next() // The opening parenthesis
+
+	function := strings.ToLower(name.text)
+	if function != "contains" && function != "startswith" && function != "endswith" {
+		return nil, fmt.Errorf("unsupported function %s at position %d", name.text, name.pos)
+	}
+
+	if p.peek().kind != odataIdentifier {
+		return nil, fmt.Errorf("%s expects a property as its first argument at position %d", name.text, p.peek().pos)
+	}
+	path, err := p.path()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(odataComma); err != nil {
+		return nil, err
+	}
+	if p.peek().kind != odataString {
+		return nil, fmt.Errorf("%s expects a string as its second argument at position %d", name.text, p.peek().pos)
+	}
+	pattern := escapeLike(p.next().text)
+	if err := p.expect(odataRightParen); err != nil {
+		return nil, err
+	}
+
+	switch function {
+	case "contains":
+		pattern = "%" + pattern + "%"
+	case "startswith":
+		pattern += "%"
+	case "endswith":
+		pattern = "%" + pattern
+	}
+	return newLikeCondition(path, Literal{text: pattern, kind: StringLiteral}, false), nil
+}
+
+// lambda parses /any(v: predicate) or /all(v: predicate) following a collection path
+func (p *odataParser) lambda(path string) (Condition, error) {
+	p.next() // The slash
+	operator := p.next()
+	if operator.kind != odataIdentifier || !strings.EqualFold(operator.text, "any") && !strings.EqualFold(operator.text, "all") {
+		return nil, fmt.Errorf("expected any or all at position %d", operator.pos)
+	}
+	all := strings.EqualFold(operator.text, "all")
+	if err := p.expect(odataLeftParen); err != nil {
+		return nil, err
+	}
+
+	// any() without a predicate checks that the collection is not empty
+	if !all && p.peek().kind == odataRightParen {
+		p.next()
+		return &QuantifierCondition{field: path, condition: &ConstantCondition{value: true}}, nil
+	}
+
+	variable := p.next()
+	if variable.kind != odataIdentifier {
+		return nil, fmt.Errorf("expected a lambda variable at position %d", variable.pos)
+	}
+	if err := p.expect(odataColon); err != nil {
+		return nil, err
+	}
+
+	outer := p.variable
+	p.variable = variable.text
+	predicate, err := p.or()
+	p.variable = outer
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(odataRightParen); err != nil {
+		return nil, err
+	}
+
+	// Comparing the elements themselves with a value is the same as ANY(field) = value
+	if equal, ok := predicate.(*EqualCondition); ok && !all && equal.field == "" {
+		return &AnyArrayContainsCondition{field: path, value: equal.value}, nil
+	}
+	return &QuantifierCondition{field: path, condition: predicate, all: all}, nil
+}
+
+// path parses a property path such as Department/Name. Inside a lambda, paths must start with
+// the lambda variable, and are resolved relative to the element it refers to
+func (p *odataParser) path() (string, error) {
+	first := p.next()
+	parts := []string{first.text}
+	for p.peek().kind == odataSlash && p.tokens[p.pos+1].kind == odataIdentifier &&
+		p.tokens[p.pos+2].kind != odataLeftParen {
+		p.next()
+		parts = append(parts, p.next().text)
+	}
+
+	if p.variable != "" {
+		if parts[0] != p.variable {
+			return "", fmt.Errorf("property %s at position %d must be accessed through the lambda variable %s", first.text, first.pos, p.variable)
+		}
+		parts = parts[1:]
+		if len(parts) == 0 {
+			return "", nil
+		}
+	}
+	return formatFieldPath(parts), nil
+}
+
+// value parses a literal: a string, a number, a date or time, true, false or null
+func (p *odataParser) value() (value Literal, isNull bool, err error) {
+	// The token is only consumed once known to be a value, so that errors name it, or the
+	// end of the filter
+	token := p.peek()
+	switch token.kind {
+	case odataString:
+		value = Literal{text: token.text, kind: StringLiteral}
+	case odataNumber:
+		if _, err := strconv.ParseFloat(token.text, 64); err == nil {
+			value = Literal{text: token.text, kind: NumberLiteral}
+		} else if _, ok := parseTime(token.text); ok {
+			value = Literal{text: token.text, kind: StringLiteral}
+		} else {
+			return Literal{}, false, fmt.Errorf("invalid value %q at position %d", token.text, token.pos)
+		}
+	case odataIdentifier:
+		switch strings.ToLower(token.text) {
+		case "true", "false":
+			value = Literal{text: strings.ToLower(token.text), kind: BoolLiteral}
+		case "null":
+			isNull = true
+		default:
+			return Literal{}, false, p.unexpected()
+		}
+	default:
+		return Literal{}, false, p.unexpected()
+	}
+
+	p.next()
+	return value, isNull, nil
+}
+
+// valueList parses a parenthesized, comma-separated list of values for the in operator
+func (p *odataParser) valueList() ([]Literal, error) {
+	if err := p.expect(odataLeftParen); err != nil {
+		return nil, err
+	}
+
+	var values []Literal
+	for {
+		value, isNull, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		if isNull {
+			return nil, fmt.Errorf("null is not supported in lists")
+		}
+		values = append(values, value)
+
+		if p.peek().kind == odataRightParen {
+			p.next()
+			return values, nil
+		}
+		if err := p.expect(odataComma); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// escapeLike escapes the LIKE wildcards in a string, so that it matches literally
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
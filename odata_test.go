@@ -0,0 +1,209 @@
+// Copyright 2023 Daniel Valdivia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rql
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompileOData(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  string
+		wantIDs []int
+		wantErr bool
+	}{
+		{name: "empty filter", filter: "  ", wantIDs: []int{1, 2, 3, 4, 5}},
+		{name: "equality", filter: `Name eq 'Alice'`, wantIDs: []int{1}},
+		{name: "case-insensitive keywords", filter: `Age GE 30 AND Active EQ TRUE`, wantIDs: []int{2, 4}},
+		{name: "comparisons", filter: `Age gt 25 and Age le 40 and Age ne 35`, wantIDs: []int{2, 4}},
+		{name: "and binds tighter than or", filter: `Age gt 40 or Name eq 'Alice' and Active eq true`, wantIDs: []int{1, 5}},
+		{name: "parentheses", filter: `(Age gt 40 or Name eq 'Alice') and Active eq true`, wantIDs: []int{1}},
+		{name: "not", filter: `not (Active eq true or Age eq 35)`, wantIDs: []int{5}},
+		{name: "not binds tighter than and", filter: `not Active and Age lt 40`, wantIDs: []int{3}},
+		{name: "bare boolean property", filter: `Active`, wantIDs: []int{1, 2, 4}},
+		{name: "nested property", filter: `Department/Name eq 'Engineering'`, wantIDs: []int{1, 2}},
+		{name: "negative and decimal numbers", filter: `Age gt -1 and Age lt 30.5`, wantIDs: []int{1, 2}},
+		{name: "doubled quotes", filter: `Name eq 'O''Brien'`, wantIDs: []int{}},
+		{name: "in", filter: `Name in ('Alice', 'Bob')`, wantIDs: []int{1, 2}},
+		{name: "not in", filter: `not (Age in (25, 45))`, wantIDs: []int{2, 3, 4}},
+		{name: "contains", filter: `contains(Email, 'example')`, wantIDs: []int{1, 2, 3, 5}},
+		{name: "startswith", filter: `startswith(Name, 'A') or startswith(Name, 'E')`, wantIDs: []int{1, 5}},
+		{name: "endswith", filter: `endswith(Email, '@alternative.com')`, wantIDs: []int{4}},
+		{name: "functions are case-insensitive", filter: `StartsWith(Name, 'A')`, wantIDs: []int{1}},
+		{name: "missing fields are null", filter: `Department/Budget eq null`, wantIDs: []int{1, 2, 3, 4, 5}},
+		{name: "present fields are not null", filter: `Department/Name ne null`, wantIDs: []int{1, 2, 3, 4, 5}},
+		{name: "any with element comparison", filter: `Tags/any(t: t eq 'go')`, wantIDs: []int{2}},
+		{name: "any over a nested collection", filter: `Metadata/Categories/any(c: c eq 'developer')`, wantIDs: []int{1, 2}},
+		{name: "any with a compound predicate", filter: `Scores/any(s: s ge 95 or s lt 81)`, wantIDs: []int{2, 3}},
+		{name: "any with a function", filter: `Skills/any(s: startswith(s, 'arch'))`, wantIDs: []int{2}},
+		{name: "all", filter: `Scores/all(s: s ge 85)`, wantIDs: []int{1, 2, 4, 5}},
+		{name: "negated any", filter: `not Tags/any(t: t eq 'go')`, wantIDs: []int{1, 3, 4, 5}},
+		{name: "missing value", filter: `Name eq`, wantErr: true},
+		{name: "missing right operand", filter: `Name eq 'Alice' and`, wantErr: true},
+		{name: "unbalanced parentheses", filter: `(Name eq 'Alice'`, wantErr: true},
+		{name: "unterminated string", filter: `Name eq 'Alice`, wantErr: true},
+		{name: "unknown operator", filter: `Name has 'A'`, wantErr: true},
+		{name: "unsupported function", filter: `tolower(Name) eq 'alice'`, wantErr: true},
+		{name: "function without a string", filter: `contains(Name, 5)`, wantErr: true},
+		{name: "ordering against null", filter: `Age gt null`, wantErr: true},
+		{name: "null in a list", filter: `Name in ('Alice', null)`, wantErr: true},
+		{name: "invalid number", filter: `Age eq 3x`, wantErr: true},
+		{name: "unknown lambda operator", filter: `Tags/some(t: t eq 'go')`, wantErr: true},
+		{name: "property outside the lambda variable", filter: `Tags/any(t: Name eq 'Bob')`, wantErr: true},
+		{name: "unexpected character", filter: `Name eq 'Alice' && Age gt 3`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := CompileOData(tt.filter)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CompileOData() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			gotIDs := []int{}
+			for _, person := range ApplyQuery(query, testPeople).Items {
+				gotIDs = append(gotIDs, person.ID)
+			}
+			if !reflect.DeepEqual(gotIDs, tt.wantIDs) {
+				t.Errorf("CompileOData() got IDs = %v, want %v", gotIDs, tt.wantIDs)
+			}
+		})
+	}
+}
+
+// TestCompileODataErrors tests that errors name the offending token, or the end of the filter
+func TestCompileODataErrors(t *testing.T) {
+	tests := []struct {
+		filter  string
+		wantErr string
+	}{
+		{filter: `Name eq`, wantErr: "unexpected end of filter"},
+		{filter: `Name in ('Alice',`, wantErr: "unexpected end of filter"},
+		{filter: `Name eq and`, wantErr: `unexpected "and" at position 8`},
+		{filter: `Name eq (`, wantErr: `unexpected "(" at position 8`},
+		{filter: `Name in ('Alice', Bob)`, wantErr: `unexpected "Bob" at position 18`},
+		{filter: `Age eq 3x`, wantErr: `invalid value "3x" at position 7`},
+	}
+
+	for _, tt := range tests {
+		if _, err := CompileOData(tt.filter); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("CompileOData(%q) error = %v, want %s", tt.filter, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCompileODataLambdas(t *testing.T) {
+	customers := []TestCustomer{
+		{ID: 1, Notes: &[]string{"vip"}, Orders: []TestOrder{
+			{Status: "paid", Total: 150, Shipped: true, Items: []TestOrderItem{{SKU: "A", Quantity: 1}}},
+			{Status: "pending", Total: 20, Shipped: false},
+		}},
+		{ID: 2, Orders: []TestOrder{
+			{Status: "paid", Total: 50, Shipped: true, Items: []TestOrderItem{{SKU: "B", Quantity: 5}}},
+			{Status: "paid", Total: 200, Shipped: true, Items: []TestOrderItem{{SKU: "A", Quantity: 3}}},
+		}},
+		{ID: 3, Orders: []TestOrder{}},
+	}
+
+	tests := []struct {
+		filter  string
+		wantIDs []int
+	}{
+		{filter: `Orders/any(o: o/Status eq 'paid' and o/Total gt 100)`, wantIDs: []int{1, 2}},
+		{filter: `Orders/all(o: o/Shipped)`, wantIDs: []int{2, 3}},
+		{filter: `Orders/any(o: o/Items/any(i: i/SKU eq 'A' and i/Quantity gt 2))`, wantIDs: []int{2}},
+		{filter: `Orders/any()`, wantIDs: []int{1, 2}},
+		{filter: `Notes eq null`, wantIDs: []int{2, 3}},
+		{filter: `Notes ne null and Notes/any(n: n eq 'vip')`, wantIDs: []int{1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			query, err := CompileOData(tt.filter)
+			if err != nil {
+				t.Fatalf("CompileOData() unexpected error = %v", err)
+			}
+
+			gotIDs := []int{}
+			for _, customer := range ApplyQuery(query, customers).Items {
+				gotIDs = append(gotIDs, customer.ID)
+			}
+			if !reflect.DeepEqual(gotIDs, tt.wantIDs) {
+				t.Errorf("CompileOData() got IDs = %v, want %v", gotIDs, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func TestCompileODataDates(t *testing.T) {
+	type event struct {
+		Name    string
+		Created time.Time
+	}
+	events := []event{
+		{Name: "old", Created: time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{Name: "new", Created: time.Date(2024, 6, 1, 10, 30, 0, 0, time.UTC)},
+	}
+
+	for _, filter := range []string{`Created ge 2024-01-01`, `Created gt 2024-06-01T10:00:00Z`} {
+		query, err := CompileOData(filter)
+		if err != nil {
+			t.Fatalf("CompileOData(%q) unexpected error = %v", filter, err)
+		}
+		if result := ApplyQuery(query, events); result.Count != 1 || result.Items[0].Name != "new" {
+			t.Errorf("CompileOData(%q) matched %v, want the new event", filter, result.Items)
+		}
+	}
+}
+
+// TestCompileODataConditionTree tests that OData filters compile to the same conditions as
+// the equivalent SQL-like filters, so translators work unchanged
+func TestCompileODataConditionTree(t *testing.T) {
+	tests := []struct {
+		odata string
+		sql   string
+	}{
+		{odata: `age ge 30 and (name eq 'Bob' or tags/any(t: t eq 'go'))`, sql: `age >= 30 AND (name = 'Bob' OR ANY(tags) = 'go')`},
+		{odata: `not active eq true and department/name ne 'HR'`, sql: `NOT active = true AND department.name != 'HR'`},
+		{odata: `labels/env ne null`, sql: `has(labels.env)`},
+		{odata: `endswith(email, '@example.com')`, sql: `email LIKE '%@example.com'`},
+		{odata: `name in ('Alice', 'Bob')`, sql: `name IN ('Alice', 'Bob')`},
+		{odata: `orders/any(o: o/status eq 'paid')`, sql: `ANY(orders, status = 'paid')`},
+		{odata: `orders/all(o: o/total gt 4.5)`, sql: `ALL(orders, total > 4.5)`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.odata, func(t *testing.T) {
+			odata, err := CompileOData(tt.odata)
+			if err != nil {
+				t.Fatalf("CompileOData() unexpected error = %v", err)
+			}
+			sql, err := Compile(tt.sql)
+			if err != nil {
+				t.Fatalf("Compile() unexpected error = %v", err)
+			}
+			if !reflect.DeepEqual(odata, sql) {
+				t.Errorf("CompileOData(%q) = %#v, want %#v", tt.odata, odata.condition, sql.condition)
+			}
+		})
+	}
+}
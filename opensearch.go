@@ -0,0 +1,276 @@
+// Copyright 2023 Daniel Valdivia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// OpenSearchOptions contains configuration options for translating a query into the
+// OpenSearch and Elasticsearch Query DSL
+type OpenSearchOptions struct {
+	// Fields maps the field names accepted in filters to index fields, matching field names
+	// case-insensitively, e.g. "Name" to "name.keyword". Fields inside a quantifier are
+	// looked up by their full path, e.g. "Orders.Status". When nil, field paths are used
+	// as parsed, which lowercases unquoted names
+	Fields map[string]string
+}
+
+// ToOpenSearch translates the query into a Query DSL query, to be sent as the "query" of a
+// search request. The result only contains maps, slices and scalar values, so it can be
+// encoded with encoding/json. An empty query translates to match_all.
+//
+// Quantifiers over arrays of objects become nested queries, which requires the array to
+// be mapped with the nested type. Note that ALL(field, predicate) also matches documents
+// without the field
+func (q *Query) ToOpenSearch(options OpenSearchOptions) (map[string]any, error) {
+	if q.condition == nil {
+		return map[string]any{"match_all": map[string]any{}}, nil
+	}
+
+	w := &openSearchWriter{fields: options.Fields}
+	return w.query(q.condition)
+}
+
+// openSearchWriter translates conditions into Query DSL queries
+type openSearchWriter struct {
+	fields map[string]string
+	scope  string // Filter path of the field an enclosing quantifier iterates over
+}
+
+// query translates a condition into a query
+func (w *openSearchWriter) query(condition Condition) (map[string]any, error) {
+	switch c := condition.(type) {
+	case *AndCondition:
+		return w.boolQuery(flattenAnd(c), false)
+	case *OrCondition:
+		return w.boolQuery(flattenOr(c), true)
+	case *NotCondition:
+		operand, err := w.query(c.condition)
+		if err != nil {
+			return nil, err
+		}
+		return boolClauses("must_not", operand), nil
+	case *ConstantCondition:
+		if c.value {
+			return map[string]any{"match_all": map[string]any{}}, nil
+		}
+		return map[string]any{"match_none": map[string]any{}}, nil
+	case *EqualCondition:
+		return w.leaf(c.field, "term", c.value.Value())
+	case *NotEqualCondition:
+		return w.excluding(c.field, "term", c.value.Value())
+	case *ComparisonCondition:
+		operators := map[string]string{">": "gt", ">=": "gte", "<": "lt", "<=": "lte"}
+		return w.leaf(c.field, "range", map[string]any{operators[c.operator]: c.value.Value()})
+	case *LikeCondition:
+		wildcard := map[string]any{"value": likeWildcard(c.value.text)}
+		if c.caseInsensitive {
+			wildcard["case_insensitive"] = true
+		}
+		return w.leaf(c.field, "wildcard", wildcard)
+	case *RegexCondition:
+		// Regexp queries use Lucene's syntax, always anchored and without Perl classes
+		return nil, fmt.Errorf("regular expression on %s cannot be translated to OpenSearch", c.field)
+	case *HasCondition:
+		return w.exists(c.field)
+	case *AnyArrayContainsCondition:
+		// Term queries on array fields match when any element matches
+		return w.leaf(c.field, "term", c.value.Value())
+	case *AnyArrayNotContainsCondition:
+		// Empty arrays contain no element, so unlike != they match without the field
+		excluded, err := w.leaf(c.field, "term", c.value.Value())
+		if err != nil {
+			return nil, err
+		}
+		return boolClauses("must_not", excluded), nil
+	case *AnyArrayContainsAnyCondition:
+		return w.leaf(c.field, "terms", typedValues(c.values))
+	case *AnyArrayNotContainsAnyCondition:
+		excluded, err := w.leaf(c.field, "terms", typedValues(c.values))
+		if err != nil {
+			return nil, err
+		}
+		return boolClauses("must_not", excluded), nil
+	case *QuantifierCondition:
+		return w.nested(c)
+	case *FunctionCondition:
+		// Queries match indexed terms, computing values needs scripts
+		return nil, fmt.Errorf("function %s cannot be translated to OpenSearch", c.function.name)
+	}
+
+	return nil, fmt.Errorf("unsupported condition %T", condition)
+}
+
+// boolQuery combines the operands of a chain of AND conditions with must, or of OR conditions
+// with should. Equalities on the same field joined by OR, and inequalities joined by AND,
+// become a terms query, which is how IN and NOT IN lists arrive here
+func (w *openSearchWriter) boolQuery(operands []Condition, or bool) (map[string]any, error) {
+	merged := mergeFieldValues(operands, or)
+
+	queries := make([]any, 0, len(merged))
+	for _, operand := range merged {
+		var query map[string]any
+		var err error
+		switch o := operand.(type) {
+		case fieldValues:
+			if or {
+				query, err = w.leaf(o.field, "terms", typedValues(o.values))
+			} else {
+				query, err = w.excluding(o.field, "terms", typedValues(o.values))
+			}
+		case Condition:
+			query, err = w.query(o)
+		}
+		if err != nil {
+			return nil, err
+		}
+		queries = append(queries, query)
+	}
+
+	switch {
+	case len(queries) == 1:
+		return queries[0].(map[string]any), nil
+	case or:
+		return map[string]any{"bool": map[string]any{"should": queries, "minimum_should_match": 1}}, nil
+	default:
+		return map[string]any{"bool": map[string]any{"must": queries}}, nil
+	}
+}
+
+// nested translates ANY(field, predicate) and ALL(field, predicate) into nested queries
+func (w *openSearchWriter) nested(c *QuantifierCondition) (map[string]any, error) {
+	path, err := w.path(c.field)
+	if err != nil {
+		return nil, err
+	}
+
+	inner := &openSearchWriter{fields: w.fields, scope: w.scopedField(c.field)}
+	predicate, err := inner.query(c.condition)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.all {
+		// ALL holds when no element fails the predicate
+		predicate = boolClauses("must_not", predicate)
+	}
+	nested := map[string]any{"nested": map[string]any{"path": path, "query": predicate}}
+	if c.all {
+		return boolClauses("must_not", nested), nil
+	}
+	return nested, nil
+}
+
+// leaf returns a query of the given type on a single field, e.g. {"term": {"age": 30}}
+func (w *openSearchWriter) leaf(field, queryType string, value any) (map[string]any, error) {
+	path, err := w.path(field)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{queryType: map[string]any{path: value}}, nil
+}
+
+// excluding matches documents that have the field but do not match a query of the given
+// type on it, as the in-memory evaluator never matches != and NOT IN on missing fields
+func (w *openSearchWriter) excluding(field, queryType string, value any) (map[string]any, error) {
+	exists, err := w.exists(field)
+	if err != nil {
+		return nil, err
+	}
+	excluded, err := w.leaf(field, queryType, value)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"bool": map[string]any{
+		"must":     []any{exists},
+		"must_not": []any{excluded},
+	}}, nil
+}
+
+// exists returns a query matching documents where the field has a value
+func (w *openSearchWriter) exists(field string) (map[string]any, error) {
+	path, err := w.path(field)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"exists": map[string]any{"field": path}}, nil
+}
+
+// path returns the index field for a filter field
+func (w *openSearchWriter) path(field string) (string, error) {
+	field = w.scopedField(field)
+	if w.fields == nil {
+		if path, ok := documentPath(field); ok {
+			return path, nil
+		}
+		return "", fmt.Errorf("field %s cannot be used in OpenSearch queries", field)
+	}
+
+	path, ok := mappedField(w.fields, field)
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnmappedField, field)
+	}
+	return path, nil
+}
+
+// scopedField returns the full filter path of a field inside the enclosing quantifiers, since
+// nested queries reference fields by their full path
+func (w *openSearchWriter) scopedField(field string) string {
+	if w.scope == "" {
+		return field
+	}
+	return formatFieldPath(append(splitFieldPath(w.scope), splitFieldPath(field)...))
+}
+
+// boolClauses returns a bool query with a single clause of the given occurrence type
+func boolClauses(occur string, query map[string]any) map[string]any {
+	return map[string]any{"bool": map[string]any{occur: []any{query}}}
+}
+
+// likeWildcard converts a LIKE pattern into a wildcard query pattern: % becomes *, _ becomes ?
+// and the characters the wildcard query treats specially are escaped
+func likeWildcard(pattern string) string {
+	var b strings.Builder
+
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			if r == '*' || r == '?' || r == '\\' {
+				b.WriteByte('\\')
+			}
+			b.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '%':
+			b.WriteByte('*')
+		case r == '_':
+			b.WriteByte('?')
+		case r == '*' || r == '?':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	if escaped {
+		b.WriteString(`\\`)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,157 @@
+// Copyright 2023 Daniel Valdivia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rql
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// openSearchGoldenFilters are translated and compared with testdata/opensearch/queries.golden
+var openSearchGoldenFilters = []string{
+	"",
+	"Name = 'Alice'",
+	"Age >= 30 AND Age < 40.5 AND Active = true",
+	"Name = 'Alice' OR (Age > 40 AND Name != 'Eve')",
+	"NOT (Department.Name = 'Sales')",
+	"ID IN (1, 2, 3) AND Name NOT IN ('Bob', 'Eve')",
+	"Email LIKE '%@example.com' OR Name ILIKE 'a_i*%'",
+	`Name LIKE '100\%\_?'`,
+	"has(Email) AND Labels ? 'env'",
+	"ANY(Tags) = 'go' AND ANY(Tags) != 'php'",
+	"ANY(Tags) = ANY('go', 'rust') OR ANY(Scores) != ANY(90, 85)",
+	"ANY(Tags) != 'php' AND Name != 'Eve'",
+	"ANY(Orders, Status = 'paid' AND Total > 100)",
+	"ALL(Orders, Shipped = true)",
+	"true AND NOT false",
+}
+
+func TestToOpenSearch(t *testing.T) {
+	var got strings.Builder
+	for _, filter := range openSearchGoldenFilters {
+		query, err := Compile(filter)
+		if err != nil {
+			t.Fatalf("Compile(%q) unexpected error = %v", filter, err)
+		}
+		dsl, err := query.ToOpenSearch(OpenSearchOptions{})
+		if err != nil {
+			t.Fatalf("ToOpenSearch(%q) unexpected error = %v", filter, err)
+		}
+
+		encoded, err := json.MarshalIndent(dsl, "", "  ")
+		if err != nil {
+			t.Fatalf("ToOpenSearch(%q) result is not JSON-serializable: %v", filter, err)
+		}
+		fmt.Fprintf(&got, "%s\n%s\n\n", strings.TrimSpace("-- "+filter), encoded)
+	}
+
+	golden := filepath.Join("testdata", "opensearch", "queries.golden")
+	if *updateGolden {
+		if err := os.MkdirAll(filepath.Dir(golden), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(golden, []byte(got.String()), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("reading golden file: %v (run go test -update to create it)", err)
+	}
+	if got.String() != string(want) {
+		t.Errorf("ToOpenSearch() output differs from %s:\n%s", golden, got.String())
+	}
+}
+
+func TestToOpenSearchEmptyArrays(t *testing.T) {
+	// An empty array contains no element, so ANY(field) != value matches it in memory, and
+	// OpenSearch indexes empty arrays like missing fields, which must_not alone matches
+	for _, filter := range []string{"ANY(Tags) != 'php'", "ANY(Tags) != ANY('php', 'perl')"} {
+		query, err := Compile(filter)
+		if err != nil {
+			t.Fatalf("Compile(%q) unexpected error = %v", filter, err)
+		}
+		for _, tags := range [][]string{nil, {}} {
+			if !query.Match(struct{ Tags []string }{Tags: tags}) {
+				t.Errorf("%q doesn't match Tags = %#v", filter, tags)
+			}
+		}
+
+		dsl, err := query.ToOpenSearch(OpenSearchOptions{})
+		if err != nil {
+			t.Fatalf("ToOpenSearch(%q) unexpected error = %v", filter, err)
+		}
+		got, _ := json.Marshal(dsl)
+		if strings.Contains(string(got), "exists") {
+			t.Errorf("ToOpenSearch(%q) = %s requires the field to exist", filter, got)
+		}
+	}
+}
+
+func TestToOpenSearchFields(t *testing.T) {
+	options := OpenSearchOptions{Fields: map[string]string{
+		"Name":          "name.keyword",
+		"Orders":        "orders",
+		"Orders.Status": "orders.status",
+	}}
+
+	query, err := Compile("name = 'Alice' AND ANY(Orders, Status = 'paid')")
+	if err != nil {
+		t.Fatalf("Compile() unexpected error = %v", err)
+	}
+	dsl, err := query.ToOpenSearch(options)
+	if err != nil {
+		t.Fatalf("ToOpenSearch() unexpected error = %v", err)
+	}
+	got, _ := json.Marshal(dsl)
+	want := `{"bool":{"must":[{"term":{"name.keyword":"Alice"}},{"nested":{"path":"orders","query":{"term":{"orders.status":"paid"}}}}]}}`
+	if string(got) != want {
+		t.Errorf("ToOpenSearch() = %s, want %s", got, want)
+	}
+
+	errorTests := []struct {
+		name         string
+		filter       string
+		options      OpenSearchOptions
+		wantUnmapped bool
+	}{
+		{name: "unmapped field", filter: "Email = 'x'", options: options, wantUnmapped: true},
+		{name: "unmapped field in a quantifier", filter: "ANY(Orders, Total > 1)", options: options, wantUnmapped: true},
+		{name: "dotted map key", filter: "Labels['app.kubernetes.io/name'] = 'api'"},
+		{name: "regular expression", filter: "Name ~ '^A'", options: options},
+		{name: "function", filter: "lower(Name) = 'alice'", options: options},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := Compile(tt.filter)
+			if err != nil {
+				t.Fatalf("Compile() unexpected error = %v", err)
+			}
+			_, err = query.ToOpenSearch(tt.options)
+			if err == nil {
+				t.Fatalf("ToOpenSearch() expected an error")
+			}
+			if got := errors.Is(err, ErrUnmappedField); got != tt.wantUnmapped {
+				t.Errorf("ToOpenSearch() error = %v, errors.Is(ErrUnmappedField) = %v, want %v", err, got, tt.wantUnmapped)
+			}
+		})
+	}
+}
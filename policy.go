@@ -0,0 +1,194 @@
+// Copyright 2023 Daniel Valdivia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rql
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Policy decides whether the caller, whose role or principal the context carries, may
+// filter on a field with an operator. Fields are full paths like those returned by
+// Query.Fields, spelled as in the filter, so policies should compare them
+// case-insensitively like fields are resolved. Operators are =, !=, >, >=, <, <=, LIKE,
+// ILIKE, ~ and ~*, HAS for presence checks, ANY = and ANY != for element comparisons, and
+// ANY and ALL for quantifiers, whose predicates are checked too. IN lists are checked as =,
+// negations like NOT LIKE as HAS and the operator they negate, and the fields passed to a
+// function like lower(Email) with the operator comparing its result
+type Policy func(ctx context.Context, field, operator string) bool
+
+// AuthorizationError is returned for filters using a field or operator the policy denies
+type AuthorizationError struct {
+	Field    string
+	Operator string
+}
+
+func (e *AuthorizationError) Error() string {
+	return fmt.Sprintf("filtering on field %q with operator %s is not allowed", e.Field, e.Operator)
+}
+
+// CompileWithPolicy parses a SQL-like filter into a Query like Compile, and checks every
+// field it references against the policy
+func CompileWithPolicy(ctx context.Context, filter string, policy Policy) (*Query, error) {
+	query, err := Compile(filter)
+	if err != nil {
+		return nil, err
+	}
+	if err := query.Authorize(ctx, policy); err != nil {
+		return nil, err
+	}
+	return query, nil
+}
+
+// Authorize checks every field the query references against the policy, returning an
+// *AuthorizationError for the first one denied. It works for queries from any syntax, and
+// should be called before combining them with queries the caller is not subject to
+func (q *Query) Authorize(ctx context.Context, policy Policy) error {
+	return authorize(ctx, q.condition, nil, policy)
+}
+
+// authorize checks the fields referenced by a condition, relative to the scope of the
+// quantifiers around it
+func authorize(ctx context.Context, condition Condition, scope []string, policy Policy) error {
+	var err error
+	Inspect(condition, func(c Condition) bool {
+		if err != nil {
+			return false
+		}
+
+		// Each field passed to a function is checked with the operator comparing its result
+		if function, ok := c.(*FunctionCondition); ok {
+			operator := conditionOperator(function.condition)
+			for _, field := range function.function.Fields() {
+				parts := append(slices.Clip(scope), SplitFieldPath(field)...)
+				if !policy(ctx, formatFieldPath(parts), operator) {
+					err = &AuthorizationError{Field: formatFieldPath(parts), Operator: operator}
+					break
+				}
+			}
+			return false
+		}
+
+		field, ok := c.(interface{ Field() string })
+		if !ok {
+			return true
+		}
+
+		parts := append(slices.Clip(scope), SplitFieldPath(field.Field())...)
+		operator := conditionOperator(c)
+		if !policy(ctx, formatFieldPath(parts), operator) {
+			err = &AuthorizationError{Field: formatFieldPath(parts), Operator: operator}
+			return false
+		}
+
+		// Fields inside a quantifier are relative to the quantified field
+		if quantifier, ok := c.(*QuantifierCondition); ok {
+			err = authorize(ctx, quantifier.condition, parts, policy)
+			return false
+		}
+		return true
+	})
+	return err
+}
+
+// conditionOperator names the operator of a condition on a field for policies
+func conditionOperator(condition Condition) string {
+	switch c := condition.(type) {
+	case *EqualCondition:
+		return "="
+	case *NotEqualCondition:
+		return "!="
+	case *ComparisonCondition:
+		return c.operator
+	case *LikeCondition:
+		if c.caseInsensitive {
+			return "ILIKE"
+		}
+		return "LIKE"
+	case *RegexCondition:
+		if c.caseInsensitive {
+			return "~*"
+		}
+		return "~"
+	case *HasCondition:
+		return "HAS"
+	case *AnyArrayContainsCondition, *AnyArrayContainsAnyCondition:
+		return "ANY ="
+	case *AnyArrayNotContainsCondition, *AnyArrayNotContainsAnyCondition:
+		return "ANY !="
+	case *QuantifierCondition:
+		if c.all {
+			return "ALL"
+		}
+		return "ANY"
+	}
+	return ""
+}
+
+// DenyFields returns a policy denying filters on the fields and anything nested in them.
+// Values of the structs or maps containing them can't be compared either, since that
+// would compare the fields too, but they can still be checked for presence or quantified
+// over
+func DenyFields(fields ...string) Policy {
+	denied := splitFieldPaths(fields)
+	return func(ctx context.Context, field, operator string) bool {
+		parts := SplitFieldPath(field)
+		for _, deniedParts := range denied {
+			if hasPathPrefix(parts, deniedParts) || (comparesValue(operator) && hasPathPrefix(deniedParts, parts)) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// AllowFields returns a policy allowing filters only on the fields and anything nested in
+// them. The structs or maps containing them can be checked for presence or quantified over,
+// but their values can't be compared
+func AllowFields(fields ...string) Policy {
+	allowed := splitFieldPaths(fields)
+	return func(ctx context.Context, field, operator string) bool {
+		parts := SplitFieldPath(field)
+		for _, allowedParts := range allowed {
+			if hasPathPrefix(parts, allowedParts) || (!comparesValue(operator) && hasPathPrefix(allowedParts, parts)) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// splitFieldPaths splits each of the field paths into its parts
+func splitFieldPaths(fields []string) [][]string {
+	paths := make([][]string, len(fields))
+	for i, field := range fields {
+		paths[i] = SplitFieldPath(field)
+	}
+	return paths
+}
+
+// hasPathPrefix reports whether a field path starts with the parts of another,
+// case-insensitively
+func hasPathPrefix(parts, prefix []string) bool {
+	return len(parts) >= len(prefix) && slices.EqualFunc(parts[:len(prefix)], prefix, strings.EqualFold)
+}
+
+// comparesValue reports whether an operator compares the value of a field, rather than
+// checking its presence or quantifying over its elements
+func comparesValue(operator string) bool {
+	return operator != "HAS" && operator != "ANY" && operator != "ALL"
+}
@@ -0,0 +1,210 @@
+// Copyright 2023 Daniel Valdivia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rql
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type roleKey struct{}
+
+// rolePolicy lets admins filter on everything, and others on everything but emails and
+// locations
+func rolePolicy(ctx context.Context, field, operator string) bool {
+	if ctx.Value(roleKey{}) == "admin" {
+		return true
+	}
+	return DenyFields("Email", "Department.Location")(ctx, field, operator)
+}
+
+func TestCompileWithPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  string
+		role    string
+		wantErr *AuthorizationError
+	}{
+		{
+			name:   "allowed fields",
+			filter: "Name = 'Alice' AND Department.Name = 'Engineering'",
+		},
+		{
+			name:    "denied field",
+			filter:  "Name = 'Alice' OR email LIKE '%@alternative.com'",
+			wantErr: &AuthorizationError{Field: "email", Operator: "LIKE"},
+		},
+		{
+			name:    "denied field matched by a regular expression",
+			filter:  "Name = 'Alice' AND email !~* '@alternative\\.com$'",
+			wantErr: &AuthorizationError{Field: "email", Operator: "HAS"},
+		},
+		{
+			name:    "denied field passed to a function",
+			filter:  "length(Name) > 3 AND substring(coalesce(Department.Name, lower(Email)), 1) = 'x'",
+			wantErr: &AuthorizationError{Field: "email", Operator: "="},
+		},
+		{
+			name:   "admins may filter on any field",
+			filter: "Name = 'Alice' OR email LIKE '%@alternative.com'",
+			role:   "admin",
+		},
+		{
+			name:    "denied field in a value list",
+			filter:  "EMAIL IN ('alice@example.com')",
+			wantErr: &AuthorizationError{Field: "email", Operator: "="},
+		},
+		{
+			name:    "presence of a denied field",
+			filter:  "NOT has(Email)",
+			wantErr: &AuthorizationError{Field: "email", Operator: "HAS"},
+		},
+		{
+			name:    "denied nested field",
+			filter:  `"Department"."Location" = 'Building A'`,
+			wantErr: &AuthorizationError{Field: "Department.Location", Operator: "="},
+		},
+		{
+			name:    "denied nested field in brackets",
+			filter:  "department['location'] >= 'Building A'",
+			wantErr: &AuthorizationError{Field: "department.location", Operator: ">="},
+		},
+		{
+			name:   "presence of the struct containing a denied field",
+			filter: "has(Department)",
+		},
+		{
+			name:    "value of the struct containing a denied field",
+			filter:  "Department LIKE '%Building A%'",
+			wantErr: &AuthorizationError{Field: "department", Operator: "LIKE"},
+		},
+		{
+			name:    "value of the item itself",
+			filter:  `"" LIKE '%alice@%'`,
+			wantErr: &AuthorizationError{Field: "", Operator: "LIKE"},
+		},
+		{
+			name:    "denied field inside a quantifier",
+			filter:  "ANY(Tags, true) AND ALL(Department, Location != '')",
+			wantErr: &AuthorizationError{Field: "department.location", Operator: "!="},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.WithValue(context.Background(), roleKey{}, tt.role)
+			query, err := CompileWithPolicy(ctx, tt.filter, rolePolicy)
+
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("CompileWithPolicy(%q) unexpected error = %v", tt.filter, err)
+				}
+				want, _ := Compile(tt.filter)
+				if !reflect.DeepEqual(query, want) {
+					t.Errorf("CompileWithPolicy(%q) = %v, want %v", tt.filter, query, want)
+				}
+				return
+			}
+
+			var authErr *AuthorizationError
+			if !errors.As(err, &authErr) {
+				t.Fatalf("CompileWithPolicy(%q) error = %v, want an *AuthorizationError", tt.filter, err)
+			}
+			if *authErr != *tt.wantErr {
+				t.Errorf("CompileWithPolicy(%q) error = %+v, want %+v", tt.filter, authErr, tt.wantErr)
+			}
+			if query != nil {
+				t.Errorf("CompileWithPolicy(%q) = %v, want nil", tt.filter, query)
+			}
+		})
+	}
+
+	// Syntax errors are returned as they are
+	if _, err := CompileWithPolicy(context.Background(), "Email =", rolePolicy); err == nil || errors.As(err, new(*AuthorizationError)) {
+		t.Errorf("CompileWithPolicy() error = %v, want a syntax error", err)
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	ctx := context.Background()
+
+	// Queries from other syntaxes are checked the same way, with paths relative to the
+	// quantified field
+	query, err := CompileOData("Scores/all(s: s ge 85) and Department/Name eq 'Engineering'")
+	if err != nil {
+		t.Fatalf("CompileOData() unexpected error = %v", err)
+	}
+	if err := query.Authorize(ctx, AllowFields("Scores", "Department.Name")); err != nil {
+		t.Errorf("Authorize() unexpected error = %v", err)
+	}
+	wantErr := &AuthorizationError{Field: "Scores", Operator: "ALL"}
+	if err := query.Authorize(ctx, DenyFields("scores")); !reflect.DeepEqual(err, wantErr) {
+		t.Errorf("Authorize() error = %v, want %v", err, wantErr)
+	}
+
+	// Operators can be denied too
+	noPatterns := func(ctx context.Context, field, operator string) bool {
+		return operator != "LIKE" && operator != "ILIKE"
+	}
+	query, err = Compile(`ANY(Tags, "" ILIKE 'GO%')`)
+	if err != nil {
+		t.Fatalf("Compile() unexpected error = %v", err)
+	}
+	wantErr = &AuthorizationError{Field: "tags", Operator: "ILIKE"}
+	if err := query.Authorize(ctx, noPatterns); !reflect.DeepEqual(err, wantErr) {
+		t.Errorf("Authorize() error = %v, want %v", err, wantErr)
+	}
+	if got, want := wantErr.Error(), `filtering on field "tags" with operator ILIKE is not allowed`; got != want {
+		t.Errorf("Error() = %s, want %s", got, want)
+	}
+
+	// Server filters combined afterwards aren't subject to the caller's policy
+	user, err := CompileWithPolicy(ctx, "ANY(Tags) = 'go' OR Age > 30", AllowFields("Tags", "Age"))
+	if err != nil {
+		t.Fatalf("CompileWithPolicy() unexpected error = %v", err)
+	}
+	server, _ := Compile("Active = true")
+	if got := ApplyQuery(user.And(server), testPeople).Count; got != 2 {
+		t.Errorf("ApplyQuery() Count = %d, want 2", got)
+	}
+	if err := user.And(server).Authorize(ctx, AllowFields("Tags", "Age")); err == nil {
+		t.Errorf("Authorize() expected an error for the combined query")
+	}
+
+	tests := []struct {
+		policy   Policy
+		field    string
+		operator string
+		want     bool
+	}{
+		{policy: AllowFields("Orders.Status"), field: "orders.status", operator: "=", want: true},
+		{policy: AllowFields("Orders.Status"), field: "Orders", operator: "ANY", want: true},
+		{policy: AllowFields("Orders.Status"), field: "Orders", operator: "HAS", want: true},
+		{policy: AllowFields("Orders.Status"), field: "Orders", operator: "ANY =", want: false},
+		{policy: AllowFields("Orders.Status"), field: "Orders.Total", operator: ">", want: false},
+		{policy: AllowFields("Labels"), field: "Labels['app.kubernetes.io/name']", operator: "=", want: true},
+		{policy: AllowFields(), field: "Name", operator: "=", want: false},
+		{policy: DenyFields("Labels['app.kubernetes.io/name']"), field: "labels.app", operator: "=", want: true},
+		{policy: DenyFields("Labels['app.kubernetes.io/name']"), field: "Labels['APP.kubernetes.io/name']", operator: "=", want: false},
+		{policy: DenyFields(), field: "", operator: "=", want: true},
+	}
+	for _, tt := range tests {
+		if got := tt.policy(ctx, tt.field, tt.operator); got != tt.want {
+			t.Errorf("policy(%q, %q) = %v, want %v", tt.field, tt.operator, got, tt.want)
+		}
+	}
+}
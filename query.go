@@ -0,0 +1,197 @@
+// Copyright 2023 Daniel Valdivia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rql
+
+import (
+	"slices"
+	"strings"
+)
+
+// Query is a compiled filter. It can be applied to any number of slices without being
+// parsed again, or translated into a query for a database
+type Query struct {
+	condition Condition // nil for an empty filter, which matches everything
+}
+
+// Compile parses a SQL-like filter into a Query. An empty filter matches every item
+func Compile(filter string) (*Query, error) {
+	if strings.TrimSpace(filter) == "" {
+		return &Query{}, nil
+	}
+
+	condition, err := parseFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	return &Query{condition: condition}, nil
+}
+
+// Match reports whether a single item satisfies the query
+func (q *Query) Match(item any) bool {
+	return q.condition == nil || q.condition.evaluate(item)
+}
+
+// And returns a query matching items that match the query and all of the others. The
+// queries are combined as syntax trees rather than as text, so nothing in one of them can
+// change how the others apply: a server-side filter such as a tenant check given here
+// holds whatever a user filter contains
+func (q *Query) And(others ...*Query) *Query {
+	expr := Expr{condition: q.condition}
+	for _, other := range others {
+		expr = expr.And(Expr{condition: other.condition})
+	}
+	return expr.Query()
+}
+
+// Or returns a query matching items that match the query or any of the others
+func (q *Query) Or(others ...*Query) *Query {
+	expr := Expr{condition: q.condition}
+	for _, other := range others {
+		expr = expr.Or(Expr{condition: other.condition})
+	}
+	return expr.Query()
+}
+
+// ApplyQuery filters a slice of generic structs with a compiled query, with optional
+// sorting and pagination
+func ApplyQuery[T any](query *Query, items []T, options ...FilterOptions) Result[T] {
+	// Set default options if none provided
+	opts := DefaultFilterOptions()
+	if len(options) > 0 {
+		opts = options[0]
+	}
+
+	var filteredItems []T
+	if query.condition == nil {
+		filteredItems = slices.Clone(items)
+	} else {
+		filteredItems = make([]T, 0)
+		for _, item := range items {
+			if query.condition.evaluate(item) {
+				filteredItems = append(filteredItems, item)
+			}
+		}
+	}
+
+	// Save the total count of matched items before pagination
+	totalCount := len(filteredItems)
+
+	// Apply sorting and pagination to filtered results
+	return Result[T]{
+		Items: applyPagination(applySort(filteredItems, opts), opts),
+		Count: totalCount,
+	}
+}
+
+// mappedField looks up the name a field is mapped to by a translator's field mapping,
+// matching the field name case-insensitively like the in-memory evaluator does
+func mappedField(mapping map[string]string, field string) (string, bool) {
+	name, ok := mapping[field]
+	if !ok {
+		for candidate, mapped := range mapping {
+			if strings.EqualFold(candidate, field) {
+				name, ok = mapped, true
+				break
+			}
+		}
+	}
+	return name, ok && name != ""
+}
+
+// documentPath joins the parts of a field path with dots, as document stores expect. It
+// fails for parts that are empty or contain dots themselves, such as some map keys
+func documentPath(field string) (string, bool) {
+	parts := splitFieldPath(field)
+	for _, part := range parts {
+		if part == "" || strings.ContainsAny(part, ".\x00") {
+			return "", false
+		}
+	}
+	return strings.Join(parts, "."), true
+}
+
+// fieldValues is a field compared with several values, merged from the operands of a chain
+// of AND or OR conditions
+type fieldValues struct {
+	field  string
+	values []Literal
+}
+
+// mergeFieldValues merges the equalities on the same field among the operands of OR, or the
+// inequalities on the same field among the operands of AND, into fieldValues. Other operands
+// are kept as they are, and the result keeps the order of first appearance
+func mergeFieldValues(operands []Condition, or bool) []any {
+	comparison := func(condition Condition) (string, Literal, bool) {
+		switch c := condition.(type) {
+		case *EqualCondition:
+			return c.field, c.value, or
+		case *NotEqualCondition:
+			return c.field, c.value, !or
+		}
+		return "", Literal{}, false
+	}
+
+	counts := map[string]int{}
+	for _, operand := range operands {
+		if field, _, ok := comparison(operand); ok {
+			counts[field]++
+		}
+	}
+
+	merged := make([]any, 0, len(operands))
+	positions := map[string]int{}
+	for _, operand := range operands {
+		field, value, ok := comparison(operand)
+		if !ok || counts[field] < 2 {
+			merged = append(merged, operand)
+			continue
+		}
+
+		if i, seen := positions[field]; seen {
+			group := merged[i].(fieldValues)
+			group.values = append(group.values, value)
+			merged[i] = group
+		} else {
+			positions[field] = len(merged)
+			merged = append(merged, fieldValues{field: field, values: []Literal{value}})
+		}
+	}
+	return merged
+}
+
+// flattenAnd returns the operands of a chain of AND conditions
+func flattenAnd(condition Condition) []Condition {
+	if c, ok := condition.(*AndCondition); ok {
+		return append(flattenAnd(c.left), flattenAnd(c.right)...)
+	}
+	return []Condition{condition}
+}
+
+// flattenOr returns the operands of a chain of OR conditions
+func flattenOr(condition Condition) []Condition {
+	if c, ok := condition.(*OrCondition); ok {
+		return append(flattenOr(c.left), flattenOr(c.right)...)
+	}
+	return []Condition{condition}
+}
+
+// typedValues converts literals into values of the matching Go types
+func typedValues(values []Literal) []any {
+	typed := make([]any, len(values))
+	for i, value := range values {
+		typed[i] = value.Value()
+	}
+	return typed
+}
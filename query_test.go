@@ -0,0 +1,176 @@
+// Copyright 2023 Daniel Valdivia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rql
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompile(t *testing.T) {
+	query, err := Compile("Active = true AND ANY(Tags) = ANY('go', 'react')")
+	if err != nil {
+		t.Fatalf("Compile() unexpected error = %v", err)
+	}
+
+	if !query.Match(testPeople[0]) || query.Match(testPeople[2]) {
+		t.Errorf("Match() = %v, %v, want true, false", query.Match(testPeople[0]), query.Match(testPeople[2]))
+	}
+
+	// The same compiled query can be applied repeatedly, with its own options each time
+	result := ApplyQuery(query, testPeople, FilterOptions{Sort: []string{"-Age"}, Limit: 1})
+	if result.Count != 2 || len(result.Items) != 1 || result.Items[0].Name != "Bob" {
+		t.Errorf("ApplyQuery() = %+v, want Bob out of 2 matches", result)
+	}
+
+	result = ApplyQuery(query, testPeople)
+	gotIDs := []int{}
+	for _, person := range result.Items {
+		gotIDs = append(gotIDs, person.ID)
+	}
+	if !reflect.DeepEqual(gotIDs, []int{1, 2}) {
+		t.Errorf("ApplyQuery() got IDs = %v, want [1 2]", gotIDs)
+	}
+
+	// Empty filters match everything
+	for _, filter := range []string{"", "  "} {
+		query, err := Compile(filter)
+		if err != nil {
+			t.Fatalf("Compile(%q) unexpected error = %v", filter, err)
+		}
+		if result := ApplyQuery(query, testPeople); result.Count != len(testPeople) {
+			t.Errorf("ApplyQuery() with filter %q Count = %d, want %d", filter, result.Count, len(testPeople))
+		}
+	}
+
+	if _, err := Compile("Age >"); err == nil {
+		t.Errorf("Compile() expected an error for an incomplete filter")
+	}
+}
+
+func TestQueryAnd(t *testing.T) {
+	type record struct {
+		ID       int
+		TenantID string
+		Name     string
+		Tags     []string
+	}
+	records := []record{
+		{ID: 1, TenantID: "a", Name: "x", Tags: []string{"go"}},
+		{ID: 2, TenantID: "b", Name: "x", Tags: []string{"go"}},
+		{ID: 3, TenantID: "a", Name: "y"},
+		{ID: 4, TenantID: "b", Name: "y'"},
+	}
+
+	server, err := Compile("TenantID = 'a'")
+	if err != nil {
+		t.Fatalf("Compile() unexpected error = %v", err)
+	}
+
+	// Filters a user could send to reach another tenant's records
+	filters := []string{
+		"",
+		"true",
+		"Name = 'x' OR true",
+		"Name = 'x' OR TenantID = 'b'",
+		"Name = 'x' OR NOT (TenantID = 'a')",
+		"TenantID IN ('a', 'b')",
+		"TenantID != 'a' OR TenantID = 'a'",
+		"Name = 'x') OR (TenantID = 'b'",
+		"Name = 'x' OR TenantID = 'b' --",
+		"Name = 'x' OR TenantID = 'b' /*",
+		"Name = 'y'' OR TenantID = ''b'",
+		"Name = 'x'; SELECT * FROM t WHERE TenantID = 'b'",
+		"ANY(Tags) = 'go' OR ANY(Tags, true)",
+		"ANY(Tags) = 'go') OR (TenantID = 'b'",
+		"ANY(Tags, Name = 'x') OR TenantID = 'b')",
+		"Name = 'x' UNION SELECT * FROM t",
+		"Name = 'x' ORDER BY TenantID",
+	}
+
+	for _, filter := range filters {
+		user, err := Compile(filter)
+		if err != nil {
+			continue
+		}
+
+		for _, query := range []*Query{user.And(server), server.And(user), user.And(&Query{}, server)} {
+			for _, item := range ApplyQuery(query, records).Items {
+				if item.TenantID != "a" {
+					t.Errorf("filter %q matched record %d of tenant %q", filter, item.ID, item.TenantID)
+				}
+			}
+
+			// The server's filter stays an operand of the top-level conjunction, also in
+			// the rendered query and its translations
+			if root, ok := query.Condition().(*AndCondition); user.Condition() != nil && (!ok || (root.Left() != server.Condition() && root.Right() != server.Condition())) {
+				t.Errorf("filter %q combined into %s, want a conjunction with the server filter", filter, query)
+			}
+			rendered, err := Compile(query.String())
+			if err != nil {
+				t.Fatalf("Compile(%q) unexpected error = %v", query.String(), err)
+			}
+			for _, item := range ApplyQuery(rendered, records).Items {
+				if item.TenantID != "a" {
+					t.Errorf("rendered filter %q matched record %d of tenant %q", rendered, item.ID, item.TenantID)
+				}
+			}
+		}
+	}
+
+	// Concatenating the text instead lets the user filter close the parentheses
+	leaky, err := Compile("(" + "Name = 'x') OR (TenantID = 'b'" + ") AND TenantID = 'a'")
+	if err != nil {
+		t.Fatalf("Compile() unexpected error = %v", err)
+	}
+	if result := ApplyQuery(leaky, records); result.Count != 2 || result.Items[1].TenantID != "b" {
+		t.Errorf("ApplyQuery() with a concatenated filter = %+v, want records 1 and 2", result.Items)
+	}
+
+	// Combining with empty queries leaves the others unchanged
+	if got := (&Query{}).And(server, &Query{}); !reflect.DeepEqual(got, server) {
+		t.Errorf("And() = %v, want %v", got, server)
+	}
+	if got := (&Query{}).And(); !reflect.DeepEqual(got, &Query{}) {
+		t.Errorf("And() = %v, want an empty query", got)
+	}
+
+	user, err := Compile("Name = 'x' OR Name = 'y'")
+	if err != nil {
+		t.Fatalf("Compile() unexpected error = %v", err)
+	}
+	combined := user.And(server)
+	if got, want := combined.String(), `"tenantid" = 'a' AND ("name" = 'x' OR "name" = 'y')`; got != want {
+		t.Errorf("String() = %s, want %s", got, want)
+	}
+	where, args, err := combined.ToSQL(SQLOptions{Columns: map[string]string{"name": "name", "tenantid": "tenant_id"}})
+	if err != nil {
+		t.Fatalf("ToSQL() unexpected error = %v", err)
+	}
+	if want := `("name" = $1 OR "name" = $2) AND "tenant_id" = $3`; where != want || !reflect.DeepEqual(args, []any{"x", "y", "a"}) {
+		t.Errorf("ToSQL() = %s %v, want %s [x y a]", where, args, want)
+	}
+	if user.String() != `"name" = 'x' OR "name" = 'y'` {
+		t.Errorf("And() changed the query to %s", user)
+	}
+
+	// Or keeps the others too, and an empty query matches everything
+	if got := ApplyQuery(user.Or(server), records).Count; got != 3 {
+		t.Errorf("ApplyQuery() with Or Count = %d, want 3", got)
+	}
+	if got := user.Or(&Query{}); got.Condition() != nil {
+		t.Errorf("Or() with an empty query = %s, want an empty query", got)
+	}
+}
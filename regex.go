@@ -0,0 +1,48 @@
+// Copyright 2023 Daniel Valdivia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rql
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// newRegexCondition returns a condition matching a field against a regular expression in
+// Go's RE2 syntax, compiled once for all items. Like PostgreSQL's ~ operator, the
+// expression matches anywhere in the value unless anchored with ^ or $
+func newRegexCondition(field string, pattern Literal, caseInsensitive bool) (*RegexCondition, error) {
+	expr := pattern.text
+	if caseInsensitive {
+		expr = "(?i)" + expr
+	}
+	compiled, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression for %s: %w", field, err)
+	}
+	return &RegexCondition{field: field, value: pattern, caseInsensitive: caseInsensitive, regexp: compiled}, nil
+}
+
+// regexComparison builds the condition for ~ or ~*, or for their negations !~ and !~*,
+// which like other negated comparisons don't match items missing the field
+func regexComparison(field string, pattern Literal, caseInsensitive, negate bool) (Condition, error) {
+	condition, err := newRegexCondition(field, pattern, caseInsensitive)
+	if err != nil {
+		return nil, err
+	}
+	if negate {
+		return &AndCondition{left: &HasCondition{field: field}, right: &NotCondition{condition: condition}}, nil
+	}
+	return condition, nil
+}
@@ -0,0 +1,133 @@
+// Copyright 2023 Daniel Valdivia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rql
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRegex(t *testing.T) {
+	tests := []struct {
+		value   string
+		filter  string
+		want    bool
+		wantErr string
+	}{
+		{value: "alice@example.com", filter: `v ~ 'example'`, want: true},
+		{value: "alice@example.com", filter: `v ~ '^example'`, want: false},
+		{value: "alice@example.com", filter: `v ~ '@example\.com$'`, want: true},
+		{value: "alice@exampleXcom", filter: `v ~ '@example\.com$'`, want: false},
+		{value: "alice@example.com", filter: `v ~ 'EXAMPLE'`, want: false},
+		{value: "alice@example.com", filter: `v ~* 'EXAMPLE'`, want: true},
+		{value: "alice@example.com", filter: `v ~* '^(bob|ALICE)@'`, want: true},
+		{value: "ñandú", filter: `v ~ '^.and.$'`, want: true},
+		{value: "ÀB", filter: `v ~* '^àb$'`, want: true},
+		{value: "", filter: `v ~ ''`, want: true},
+		{value: "a\nb", filter: `v ~ '^b'`, want: false},
+		{value: "a\nb", filter: `v ~ '(?m)^b'`, want: true},
+		{value: "it's", filter: `v ~ '^it''s$'`, want: true},
+
+		// Negations, which like other negated comparisons need the field
+		{value: "abc", filter: `v !~ '^a'`, want: false},
+		{value: "abc", filter: `v !~ '^b'`, want: true},
+		{value: "abc", filter: `v !~* '^A'`, want: false},
+		{value: "abc", filter: `v !~* '^B'`, want: true},
+		{value: "abc", filter: `w !~ '^b'`, want: false},
+		{value: "abc", filter: `w ~ ''`, want: false},
+
+		// Only RE2 syntax is accepted
+		{value: "abc", filter: `v ~ '('`, wantErr: "invalid regular expression for v"},
+		{value: "abab", filter: `v ~ '(ab)\1'`, wantErr: "invalid regular expression for v"},
+		{value: "abc", filter: `v ~ 'a(?=b)'`, wantErr: "invalid regular expression for v"},
+		{value: "abc", filter: `v ~* '['`, wantErr: "invalid regular expression for v"},
+	}
+
+	for _, tt := range tests {
+		query, err := Compile(tt.filter)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Compile(%q) error = %v, want %s", tt.filter, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Compile(%q) unexpected error = %v", tt.filter, err)
+			continue
+		}
+
+		if got := query.Match(map[string]any{"v": tt.value}); got != tt.want {
+			t.Errorf("%q with v = %q = %v, want %v", tt.filter, tt.value, got, tt.want)
+		}
+
+		canonical, err := Compile(query.String())
+		if err != nil {
+			t.Fatalf("Compile(%q) unexpected error = %v", query.String(), err)
+		}
+		if got := canonical.Match(map[string]any{"v": tt.value}); got != tt.want {
+			t.Errorf("%q with v = %q = %v, want %v", query.String(), tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestRegexPeople(t *testing.T) {
+	tests := []struct {
+		filter  string
+		wantIDs []int
+	}{
+		{filter: `Email ~ '^[a-c]'`, wantIDs: []int{1, 2, 3}},
+		{filter: `Name ~* '^(a|e)'`, wantIDs: []int{1, 5}},
+		{filter: `Name !~ 'e$'`, wantIDs: []int{2}},
+		{filter: `Department.Name ~ 'ing$' AND Age > 26`, wantIDs: []int{2, 3}},
+		{filter: `ANY(Tags, "" ~ '^(go|python)$')`, wantIDs: []int{2}},
+		{filter: `ALL(Tags, "" ~ 'r')`, wantIDs: []int{1, 4, 5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			query, err := Compile(tt.filter)
+			if err != nil {
+				t.Fatalf("Compile() unexpected error = %v", err)
+			}
+			gotIDs := []int{}
+			for _, person := range ApplyQuery(query, testPeople).Items {
+				gotIDs = append(gotIDs, person.ID)
+			}
+			if !reflect.DeepEqual(gotIDs, tt.wantIDs) {
+				t.Errorf("ApplyQuery() got IDs = %v, want %v", gotIDs, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func TestBuilderMatches(t *testing.T) {
+	expr := Field("Email").Matches(regexp.MustCompile(`(?i)@example\.com$`))
+	if got, want := expr.String(), `"Email" ~ '(?i)@example\.com$'`; got != want {
+		t.Errorf("String() = %s, want %s", got, want)
+	}
+
+	query, err := Compile(expr.String())
+	if err != nil {
+		t.Fatalf("Compile() unexpected error = %v", err)
+	}
+	if !reflect.DeepEqual(query, expr.Query()) {
+		t.Errorf("Compile(String()) = %#v, want %#v", query.condition, expr.Query().condition)
+	}
+	if got := ApplyQuery(expr.Query(), testPeople).Count; got != 4 {
+		t.Errorf("ApplyQuery() count = %d, want 4", got)
+	}
+}
@@ -0,0 +1,344 @@
+// Copyright 2023 Daniel Valdivia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// CompileRSQL parses a filter written in RSQL, the query language based on FIQL, e.g.
+//
+//	name==Alice;age=gt=30,dept.name=in=(Sales,HR)
+//
+// It supports ; (or and) and , (or or), where ; binds tighter, grouping parentheses, the ==,
+// !=, =lt=, =le=, =gt=, =ge=, =in= and =out= operators and their <, <=, > and >= aliases.
+// Values can be left unquoted unless they contain reserved characters, in which case they
+// are quoted with single or double quotes and backslash escapes. Unquoted numbers, true and
+// false compare as numbers and booleans, and * in an unquoted value compared with == or !=
+// is a wildcard. Selectors use dots for nested fields
+func CompileRSQL(filter string) (*Query, error) {
+	tokens, err := lexRSQL(filter)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 1 {
+		return &Query{}, nil
+	}
+
+	p := &rsqlParser{tokens: tokens}
+	condition, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != rsqlEOF {
+		return nil, p.unexpected()
+	}
+	return &Query{condition: condition}, nil
+}
+
+// rsqlTokenKind is the kind of a token in an RSQL filter
+type rsqlTokenKind int
+
+const (
+	rsqlEOF      rsqlTokenKind = iota
+	rsqlWord                   // An unquoted selector, value, and or or
+	rsqlString                 // A quoted value, already unescaped
+	rsqlOperator               // A comparison operator such as == or =gt=
+	rsqlSemicolon
+	rsqlComma
+	rsqlLeftParen
+	rsqlRightParen
+)
+
+// rsqlToken is a token in an RSQL filter
+type rsqlToken struct {
+	kind rsqlTokenKind
+	text string
+	pos  int
+}
+
+// lexRSQL splits an RSQL filter into tokens, ending with an EOF token
+func lexRSQL(filter string) ([]rsqlToken, error) {
+	var tokens []rsqlToken
+	punctuation := map[byte]rsqlTokenKind{';': rsqlSemicolon, ',': rsqlComma, '(': rsqlLeftParen, ')': rsqlRightParen}
+
+	for pos := 0; pos < len(filter); {
+		ch := filter[pos]
+		start := pos
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			pos++
+		case punctuation[ch] != rsqlEOF:
+			tokens = append(tokens, rsqlToken{kind: punctuation[ch], text: string(ch), pos: start})
+			pos++
+		case ch == '\'' || ch == '"':
+			var text strings.Builder
+			for pos++; pos < len(filter) && filter[pos] != ch; pos++ {
+				if filter[pos] == '\\' && pos+1 < len(filter) {
+					pos++
+				}
+				text.WriteByte(filter[pos])
+			}
+			if pos >= len(filter) {
+				return nil, fmt.Errorf("unterminated string at position %d", start)
+			}
+			pos++
+			tokens = append(tokens, rsqlToken{kind: rsqlString, text: text.String(), pos: start})
+		case ch == '=':
+			// ==, or an operator made of letters between equals signs such as =gt=
+			for pos++; pos < len(filter) && filter[pos] >= 'a' && filter[pos] <= 'z'; pos++ {
+			}
+			if pos >= len(filter) || filter[pos] != '=' {
+				return nil, fmt.Errorf("invalid operator %q at position %d", filter[start:pos], start)
+			}
+			pos++
+			tokens = append(tokens, rsqlToken{kind: rsqlOperator, text: filter[start:pos], pos: start})
+		case ch == '!' || ch == '<' || ch == '>':
+			pos++
+			if pos < len(filter) && filter[pos] == '=' {
+				pos++
+			} else if ch == '!' {
+				return nil, fmt.Errorf("invalid operator %q at position %d", filter[start:pos], start)
+			}
+			tokens = append(tokens, rsqlToken{kind: rsqlOperator, text: filter[start:pos], pos: start})
+		case !isRSQLReserved(ch):
+			for pos++; pos < len(filter) && !isRSQLReserved(filter[pos]); pos++ {
+			}
+			tokens = append(tokens, rsqlToken{kind: rsqlWord, text: filter[start:pos], pos: start})
+		default:
+			return nil, fmt.Errorf("unexpected character %q at position %d", ch, pos)
+		}
+	}
+
+	return append(tokens, rsqlToken{kind: rsqlEOF, pos: len(filter)}), nil
+}
+
+// isRSQLReserved reports whether a character cannot be part of an unquoted selector or value
+func isRSQLReserved(ch byte) bool {
+	return strings.IndexByte("\"'();,=!~<> \t\n\r", ch) >= 0
+}
+
+// rsqlParser is a recursive descent parser for RSQL filters
+type rsqlParser struct {
+	tokens []rsqlToken
+	pos    int
+	depth  parseDepth
+}
+
+// peek returns the current token
+func (p *rsqlParser) peek() rsqlToken {
+	return p.tokens[p.pos]
+}
+
+// next returns the current token and moves past it
+func (p *rsqlParser) next() rsqlToken {
+	token := p.tokens[p.pos]
+	if token.kind != rsqlEOF {
+		p.pos++
+	}
+	return token
+}
+
+// expect consumes a token of the given kind
+func (p *rsqlParser) expect(kind rsqlTokenKind) error {
+	if p.peek().kind != kind {
+		return p.unexpected()
+	}
+	p.next()
+	return nil
+}
+
+// unexpected returns an error for the current token
+func (p *rsqlParser) unexpected() error {
+	token := p.peek()
+	if token.kind == rsqlEOF {
+		return fmt.Errorf("unexpected end of filter")
+	}
+	return fmt.Errorf("unexpected %q at position %d", token.text, token.pos)
+}
+
+// isLogical reports whether the current token is the given logical operator, either as its
+// symbol or as its keyword
+func (p *rsqlParser) isLogical(symbol rsqlTokenKind, keyword string) bool {
+	token := p.peek()
+	return token.kind == symbol || token.kind == rsqlWord && strings.EqualFold(token.text, keyword)
+}
+
+// or parses expressions joined by , or or
+func (p *rsqlParser) or() (Condition, error) {
+	if err := p.depth.enter(); err != nil {
+		return nil, err
+	}
+	defer p.depth.leave()
+	condition, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.isLogical(rsqlComma, "or") {
+		p.next()
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		condition = &OrCondition{left: condition, right: right}
+	}
+	return condition, nil
+}
+
+// and parses expressions joined by ; or and
+func (p *rsqlParser) and() (Condition, error) {
+	condition, err := p.constraint()
+	if err != nil {
+		return nil, err
+	}
+	for p.isLogical(rsqlSemicolon, "and") {
+		p.next()
+		right, err := p.constraint()
+		if err != nil {
+			return nil, err
+		}
+		condition = &AndCondition{left: condition, right: right}
+	}
+	return condition, nil
+}
+
+// constraint parses a parenthesized expression or a comparison
+func (p *rsqlParser) constraint() (Condition, error) {
+	if p.peek().kind == rsqlLeftParen {
+		p.next()
+		condition, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		return condition, p.expect(rsqlRightParen)
+	}
+
+	if p.peek().kind != rsqlWord {
+		return nil, p.unexpected()
+	}
+	selector := p.next()
+	parts := strings.Split(selector.text, ".")
+	for _, part := range parts {
+		if part == "" {
+			return nil, fmt.Errorf("invalid selector %s at position %d", selector.text, selector.pos)
+		}
+	}
+	field := formatFieldPath(parts)
+
+	if p.peek().kind != rsqlOperator {
+		return nil, p.unexpected()
+	}
+	operator := p.next()
+
+	switch operator.text {
+	case "=in=", "=out=":
+		values, err := p.values()
+		if err != nil {
+			return nil, err
+		}
+		return inCondition(field, values, operator.text == "=out="), nil
+	}
+
+	value, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+
+	switch operator.text {
+	case "==", "!=":
+		token := p.tokens[p.pos-1]
+		if token.kind == rsqlWord && strings.Contains(token.text, "*") {
+			pattern := Literal{text: strings.ReplaceAll(escapeLike(token.text), "*", "%"), kind: StringLiteral}
+			if operator.text == "!=" {
+				// Like inequalities, a negated wildcard match requires the field to be present
+				return &AndCondition{left: &HasCondition{field: field}, right: &NotCondition{condition: newLikeCondition(field, pattern, false)}}, nil
+			}
+			return newLikeCondition(field, pattern, false), nil
+		}
+		if operator.text == "!=" {
+			return &NotEqualCondition{field: field, value: value}, nil
+		}
+		return &EqualCondition{field: field, value: value}, nil
+	case "=lt=", "<":
+		return &ComparisonCondition{field: field, value: value, operator: "<"}, nil
+	case "=le=", "<=":
+		return &ComparisonCondition{field: field, value: value, operator: "<="}, nil
+	case "=gt=", ">":
+		return &ComparisonCondition{field: field, value: value, operator: ">"}, nil
+	case "=ge=", ">=":
+		return &ComparisonCondition{field: field, value: value, operator: ">="}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported operator %s at position %d", operator.text, operator.pos)
+}
+
+// value parses a quoted or unquoted value
+func (p *rsqlParser) value() (Literal, error) {
+	if kind := p.peek().kind; kind != rsqlString && kind != rsqlWord {
+		return Literal{}, p.unexpected()
+	}
+
+	token := p.next()
+	switch {
+	case token.kind == rsqlString:
+		return Literal{text: token.text, kind: StringLiteral}, nil
+	case token.text == "true" || token.text == "false":
+		return Literal{text: token.text, kind: BoolLiteral}, nil
+	case isRSQLNumber(token.text):
+		return Literal{text: token.text, kind: NumberLiteral}, nil
+	}
+	return Literal{text: token.text, kind: StringLiteral}, nil
+}
+
+// values parses the argument of =in= and =out=, a parenthesized list or a single value
+func (p *rsqlParser) values() ([]Literal, error) {
+	if p.peek().kind != rsqlLeftParen {
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		return []Literal{value}, nil
+	}
+	p.next()
+
+	var values []Literal
+	for {
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+
+		if p.peek().kind == rsqlRightParen {
+			p.next()
+			return values, nil
+		}
+		if err := p.expect(rsqlComma); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// isRSQLNumber reports whether an unquoted value is a number, optionally negative
+func isRSQLNumber(text string) bool {
+	digits := strings.TrimPrefix(text, "-")
+	if digits == "" || digits[0] < '0' || digits[0] > '9' {
+		return false
+	}
+	_, err := strconv.ParseFloat(text, 64)
+	return err == nil
+}
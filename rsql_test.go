@@ -0,0 +1,130 @@
+// Copyright 2023 Daniel Valdivia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rql
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompileRSQL(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  string
+		wantIDs []int
+		wantErr bool
+	}{
+		{name: "empty filter", filter: "", wantIDs: []int{1, 2, 3, 4, 5}},
+		{name: "equality", filter: `Name==Alice`, wantIDs: []int{1}},
+		{name: "and binds tighter than or", filter: `Name==Alice;Age=gt=30,Department.Name=in=(Sales,HR)`, wantIDs: []int{4, 5}},
+		{name: "parentheses", filter: `Active==true;(Age=lt=30,Age=ge=40)`, wantIDs: []int{1, 4}},
+		{name: "keywords", filter: `Active==true and (Age<30 or Age>=40)`, wantIDs: []int{1, 4}},
+		{name: "comparison aliases", filter: `Age>25;Age<=40`, wantIDs: []int{2, 3, 4}},
+		{name: "inequality", filter: `Department.Name!=Engineering`, wantIDs: []int{3, 4, 5}},
+		{name: "in", filter: `Name=in=(Alice,"Bob")`, wantIDs: []int{1, 2}},
+		{name: "in with a single value", filter: `Age=in=35`, wantIDs: []int{3}},
+		{name: "out", filter: `Age=out=(25,45)`, wantIDs: []int{2, 3, 4}},
+		{name: "quoted values", filter: `Department.Location=="Building A",Department.Location=='Building B'`, wantIDs: []int{1, 2}},
+		{name: "escaped quotes", filter: `Name=='O\'Brien'`, wantIDs: []int{}},
+		{name: "numbers", filter: `Age=gt=-1;Age=lt=30.5`, wantIDs: []int{1, 2}},
+		{name: "wildcard", filter: `Email==*@example.com`, wantIDs: []int{1, 2, 3, 5}},
+		{name: "prefix wildcard", filter: `Name==A*`, wantIDs: []int{1}},
+		{name: "negated wildcard", filter: `Email!=*@example.com`, wantIDs: []int{4}},
+		{name: "quoted asterisk", filter: `Name=='A*'`, wantIDs: []int{}},
+		{name: "missing value", filter: `Name==`, wantErr: true},
+		{name: "missing operator", filter: `Name`, wantErr: true},
+		{name: "missing right operand", filter: `Name==Alice;`, wantErr: true},
+		{name: "unbalanced parentheses", filter: `(Name==Alice`, wantErr: true},
+		{name: "unterminated string", filter: `Name=='Alice`, wantErr: true},
+		{name: "unknown operator", filter: `Name=like=Alice`, wantErr: true},
+		{name: "malformed operator", filter: `Name=Alice`, wantErr: true},
+		{name: "empty selector part", filter: `Department..Name==Sales`, wantErr: true},
+		{name: "reserved character", filter: `Name~=Alice`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := CompileRSQL(tt.filter)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CompileRSQL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			gotIDs := []int{}
+			for _, person := range ApplyQuery(query, testPeople).Items {
+				gotIDs = append(gotIDs, person.ID)
+			}
+			if !reflect.DeepEqual(gotIDs, tt.wantIDs) {
+				t.Errorf("CompileRSQL() got IDs = %v, want %v", gotIDs, tt.wantIDs)
+			}
+		})
+	}
+}
+
+// TestCompileRSQLErrors tests that errors name the offending token, or the end of the filter
+func TestCompileRSQLErrors(t *testing.T) {
+	tests := []struct {
+		filter  string
+		wantErr string
+	}{
+		{filter: `Name==`, wantErr: "unexpected end of filter"},
+		{filter: `Name`, wantErr: "unexpected end of filter"},
+		{filter: `Name==Alice;`, wantErr: "unexpected end of filter"},
+		{filter: `Name=in=(Alice,`, wantErr: "unexpected end of filter"},
+		{filter: `Name==;Age==1`, wantErr: `unexpected ";" at position 6`},
+		{filter: `Name==Alice,==Bob`, wantErr: `unexpected "==" at position 12`},
+		{filter: `Name(`, wantErr: `unexpected "(" at position 4`},
+	}
+
+	for _, tt := range tests {
+		if _, err := CompileRSQL(tt.filter); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("CompileRSQL(%q) error = %v, want %s", tt.filter, err, tt.wantErr)
+		}
+	}
+}
+
+// TestCompileRSQLConditionTree tests that RSQL filters compile to the same conditions as the
+// equivalent SQL-like filters, so translators work unchanged
+func TestCompileRSQLConditionTree(t *testing.T) {
+	tests := []struct {
+		rsql string
+		sql  string
+	}{
+		{rsql: `name==Alice;age=gt=30,dept.name=in=(Sales,HR)`, sql: `name = 'Alice' AND age > 30 OR dept.name IN ('Sales', 'HR')`},
+		{rsql: `active==true;(score=le=4.5,name!="Bob")`, sql: `active = true AND (score <= 4.5 OR name != 'Bob')`},
+		{rsql: `age=out=(25,45)`, sql: `age NOT IN (25, 45)`},
+		{rsql: `email==*@example.com`, sql: `email LIKE '%@example.com'`},
+		{rsql: `code=='007'`, sql: `code = '007'`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rsql, func(t *testing.T) {
+			rsql, err := CompileRSQL(tt.rsql)
+			if err != nil {
+				t.Fatalf("CompileRSQL() unexpected error = %v", err)
+			}
+			sql, err := Compile(tt.sql)
+			if err != nil {
+				t.Fatalf("Compile() unexpected error = %v", err)
+			}
+			if !reflect.DeepEqual(rsql, sql) {
+				t.Errorf("CompileRSQL(%q) = %#v, want %#v", tt.rsql, rsql.condition, sql.condition)
+			}
+		})
+	}
+}
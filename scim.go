@@ -0,0 +1,501 @@
+// Copyright 2023 Daniel Valdivia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rql
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// SCIMOptions contains configuration options for compiling SCIM filters
+type SCIMOptions struct {
+	// MultiValued maps the multi-valued attributes, matched case-insensitively, to the
+	// sub-attribute compared when the attribute itself is filtered, e.g. "emails" to "value"
+	// so that emails co "@example.com" compares the address of each email. Simple
+	// multi-valued attributes such as "schemas" map to "". A comparison on a multi-valued
+	// attribute or one of its sub-attributes matches when any of its values matches, so
+	// emails.type eq "work" is the same as emails[type eq "work"]
+	MultiValued map[string]string
+
+	// CaseExact lists the attributes whose string values are compared exactly, matched
+	// case-insensitively, e.g. "id" or "emails.value". Strings are compared ignoring case
+	// otherwise, as for attributes with caseExact false, which is the default in RFC 7643
+	CaseExact []string
+}
+
+// CompileSCIM parses a SCIM filter as defined in RFC 7644 section 3.4.2.2, e.g.
+//
+//	userName sw "j" and emails[type eq "work" and value co "@example.com"]
+//
+// It supports the eq, ne, co, sw, ew, gt, ge, lt, le and pr operators, and, or, not and
+// grouping parentheses, and complex attribute filters in brackets, whose attributes are
+// resolved against each value of the multi-valued attribute. Attribute names are matched
+// case-insensitively and schema URI prefixes are dropped, so
+// urn:ietf:params:scim:schemas:core:2.0:User:userName is the same as userName. String
+// comparisons with eq, ne, co, sw and ew ignore case unless the attribute is listed in
+// SCIMOptions.CaseExact, while numbers and timestamps compare by value
+func CompileSCIM(filter string, options ...SCIMOptions) (*Query, error) {
+	tokens, err := lexSCIM(filter)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 1 {
+		return &Query{}, nil
+	}
+
+	p := &scimParser{tokens: tokens}
+	if len(options) > 0 {
+		p.multiValued = options[0].MultiValued
+		p.caseExact = options[0].CaseExact
+	}
+	condition, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != scimEOF {
+		return nil, p.unexpected()
+	}
+	return &Query{condition: condition}, nil
+}
+
+// scimTokenKind is the kind of a token in a SCIM filter
+type scimTokenKind int
+
+const (
+	scimEOF    scimTokenKind = iota
+	scimWord                 // An attribute path, operator, keyword or number
+	scimString               // A JSON string, already unescaped
+	scimLeftParen
+	scimRightParen
+	scimLeftBracket
+	scimRightBracket
+)
+
+// scimToken is a token in a SCIM filter
+type scimToken struct {
+	kind scimTokenKind
+	text string
+	pos  int
+}
+
+// lexSCIM splits a SCIM filter into tokens, ending with an EOF token
+func lexSCIM(filter string) ([]scimToken, error) {
+	var tokens []scimToken
+	punctuation := map[byte]scimTokenKind{'(': scimLeftParen, ')': scimRightParen, '[': scimLeftBracket, ']': scimRightBracket}
+
+	for pos := 0; pos < len(filter); {
+		ch := filter[pos]
+		start := pos
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			pos++
+		case punctuation[ch] != scimEOF:
+			tokens = append(tokens, scimToken{kind: punctuation[ch], text: string(ch), pos: start})
+			pos++
+		case ch == '"':
+			for pos++; pos < len(filter) && filter[pos] != '"'; pos++ {
+				if filter[pos] == '\\' {
+					pos++
+				}
+			}
+			if pos >= len(filter) {
+				return nil, fmt.Errorf("unterminated string at position %d", start)
+			}
+			pos++
+
+			var text string
+			if err := json.Unmarshal([]byte(filter[start:pos]), &text); err != nil {
+				return nil, fmt.Errorf("invalid string at position %d: %w", start, err)
+			}
+			tokens = append(tokens, scimToken{kind: scimString, text: text, pos: start})
+		case isSCIMWordChar(ch):
+			for pos++; pos < len(filter) && isSCIMWordChar(filter[pos]); pos++ {
+			}
+			tokens = append(tokens, scimToken{kind: scimWord, text: filter[start:pos], pos: start})
+		default:
+			return nil, fmt.Errorf("unexpected character %q at position %d", ch, pos)
+		}
+	}
+
+	return append(tokens, scimToken{kind: scimEOF, pos: len(filter)}), nil
+}
+
+// isSCIMWordChar reports whether a character can be part of an attribute path, including
+// schema URIs, or of a number
+func isSCIMWordChar(ch byte) bool {
+	return isIdentChar(ch) || ch == '-' || ch == '.' || ch == ':' || ch == '$' || ch == '+'
+}
+
+// scimParser is a recursive descent parser for SCIM filters
+type scimParser struct {
+	tokens      []scimToken
+	pos         int
+	multiValued map[string]string
+	caseExact   []string
+	depth       parseDepth
+
+	// The attribute of the complex attribute filter the parser is inside, if any, whose
+	// attributes are resolved against each value of the filtered attribute
+	valuePathAttribute string
+}
+
+// peek returns the current token
+func (p *scimParser) peek() scimToken {
+	return p.tokens[p.pos]
+}
+
+// next returns the current token and moves past it
+func (p *scimParser) next() scimToken {
+	token := p.tokens[p.pos]
+	if token.kind != scimEOF {
+		p.pos++
+	}
+	return token
+}
+
+// expect consumes a token of the given kind
+func (p *scimParser) expect(kind scimTokenKind) error {
+	if p.peek().kind != kind {
+		return p.unexpected()
+	}
+	p.next()
+	return nil
+}
+
+// isKeyword reports whether the current token is the given keyword, ignoring case
+func (p *scimParser) isKeyword(keyword string) bool {
+	token := p.peek()
+	return token.kind == scimWord && strings.EqualFold(token.text, keyword)
+}
+
+// unexpected returns an error for the current token
+func (p *scimParser) unexpected() error {
+	token := p.peek()
+	if token.kind == scimEOF {
+		return fmt.Errorf("unexpected end of filter")
+	}
+	return fmt.Errorf("unexpected %q at position %d", token.text, token.pos)
+}
+
+// or parses expressions joined by or
+func (p *scimParser) or() (Condition, error) {
+	if err := p.depth.enter(); err != nil {
+		return nil, err
+	}
+	defer p.depth.leave()
+	condition, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("or") {
+		p.next()
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		condition = &OrCondition{left: condition, right: right}
+	}
+	return condition, nil
+}
+
+// and parses expressions joined by and
+func (p *scimParser) and() (Condition, error) {
+	condition, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("and") {
+		p.next()
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		condition = &AndCondition{left: condition, right: right}
+	}
+	return condition, nil
+}
+
+// unary parses a grouped expression, which may be negated with not, or an attribute
+// expression
+func (p *scimParser) unary() (Condition, error) {
+	negate := false
+	if p.isKeyword("not") {
+		p.next()
+		if p.peek().kind != scimLeftParen {
+			return nil, fmt.Errorf("not must be followed by a parenthesized filter at position %d", p.peek().pos)
+		}
+		negate = true
+	}
+
+	if p.peek().kind == scimLeftParen {
+		p.next()
+		condition, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(scimRightParen); err != nil {
+			return nil, err
+		}
+		if negate {
+			return &NotCondition{condition: condition}, nil
+		}
+		return condition, nil
+	}
+
+	return p.attributeExpression()
+}
+
+// attributeExpression parses a comparison, a presence check or a complex attribute filter
+func (p *scimParser) attributeExpression() (Condition, error) {
+	if p.peek().kind != scimWord {
+		return nil, p.unexpected()
+	}
+	token := p.next()
+	attribute, subAttribute, err := scimAttributePath(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.peek().kind == scimLeftBracket {
+		return p.valuePath(token, attribute, subAttribute)
+	}
+
+	if p.peek().kind != scimWord {
+		return nil, p.unexpected()
+	}
+	operator := p.next()
+
+	primary, multiValued := p.multiValuedAttribute(attribute)
+	caseExact := p.isCaseExact(attribute, subAttribute, primary)
+
+	var predicate func(field string) (Condition, error)
+	if strings.EqualFold(operator.text, "pr") {
+		predicate = func(field string) (Condition, error) {
+			return &HasCondition{field: field}, nil
+		}
+	} else {
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		predicate = func(field string) (Condition, error) {
+			return scimComparison(field, operator, value, caseExact)
+		}
+	}
+
+	// Filters on a multi-valued attribute match when any of its values matches
+	if !multiValued {
+		return predicate(formatFieldPath(append([]string{attribute}, subAttribute...)))
+	}
+
+	if len(subAttribute) == 0 {
+		// A multi-valued attribute is present when it has at least one value
+		if strings.EqualFold(operator.text, "pr") {
+			return &QuantifierCondition{field: attribute, condition: &ConstantCondition{value: true}}, nil
+		}
+		if primary != "" {
+			subAttribute = []string{primary}
+		}
+	}
+	condition, err := predicate(formatFieldPath(subAttribute))
+	if err != nil {
+		return nil, err
+	}
+	if equal, ok := condition.(*EqualCondition); ok && equal.field == "" {
+		return &AnyArrayContainsCondition{field: attribute, value: equal.value}, nil
+	}
+	return &QuantifierCondition{field: attribute, condition: condition}, nil
+}
+
+// valuePath parses a complex attribute filter in brackets, e.g. emails[type eq "work"]
+func (p *scimParser) valuePath(token scimToken, attribute string, subAttribute []string) (Condition, error) {
+	if p.valuePathAttribute != "" {
+		return nil, fmt.Errorf("complex attribute filters cannot be nested at position %d", p.peek().pos)
+	}
+	if len(subAttribute) > 0 {
+		return nil, fmt.Errorf("complex attribute filter on sub-attribute %s at position %d", token.text, token.pos)
+	}
+	p.next() // The opening bracket
+
+	p.valuePathAttribute = attribute
+	condition, err := p.or()
+	p.valuePathAttribute = ""
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(scimRightBracket); err != nil {
+		return nil, err
+	}
+	return &QuantifierCondition{field: attribute, condition: condition}, nil
+}
+
+// multiValuedAttribute returns the primary sub-attribute of a multi-valued attribute, and
+// whether the attribute is multi-valued. Inside complex attribute filters attributes are
+// resolved against each value, so they are never multi-valued
+func (p *scimParser) multiValuedAttribute(attribute string) (string, bool) {
+	if p.valuePathAttribute != "" {
+		return "", false
+	}
+	for name, primary := range p.multiValued {
+		if strings.EqualFold(name, attribute) {
+			return primary, true
+		}
+	}
+	return "", false
+}
+
+// isCaseExact reports whether the string values of an attribute are compared exactly, given
+// the primary sub-attribute compared when it's multi-valued
+func (p *scimParser) isCaseExact(attribute string, subAttribute []string, primary string) bool {
+	path := append([]string{attribute}, subAttribute...)
+	switch {
+	case p.valuePathAttribute != "":
+		path = append([]string{p.valuePathAttribute}, path...)
+	case len(subAttribute) == 0 && primary != "":
+		path = append(path, primary)
+	}
+
+	name := strings.Join(path, ".")
+	for _, caseExact := range p.caseExact {
+		if strings.EqualFold(caseExact, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// scimAttributePath splits an attribute path such as name.familyName into the attribute and
+// its sub-attribute, dropping any schema URI prefix
+func scimAttributePath(token scimToken) (attribute string, subAttribute []string, err error) {
+	path := token.text
+	if i := strings.LastIndexByte(path, ':'); i >= 0 {
+		path = path[i+1:]
+	}
+
+	parts := strings.Split(path, ".")
+	if len(parts) > 2 {
+		return "", nil, fmt.Errorf("invalid attribute path %s at position %d", token.text, token.pos)
+	}
+	for _, part := range parts {
+		if part == "" || !isSCIMAttributeName(part) {
+			return "", nil, fmt.Errorf("invalid attribute path %s at position %d", token.text, token.pos)
+		}
+	}
+	return parts[0], parts[1:], nil
+}
+
+// isSCIMAttributeName reports whether a name is a valid attribute name, which starts with a
+// letter, or with $ for names such as $ref, followed by letters, digits, - or _
+func isSCIMAttributeName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		ch := name[i]
+		isLetter := ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z'
+		switch {
+		case i == 0 && (isLetter || ch == '$'):
+		case i > 0 && (isIdentChar(ch) || ch == '-'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// scimValue is a comparison value, where null has no literal
+type scimValue struct {
+	Literal
+	null bool
+}
+
+// value parses a comparison value: a string, a number, true, false or null
+func (p *scimParser) value() (scimValue, error) {
+	// The token is only consumed once known to be a value, so that errors name it, or the
+	// end of the filter
+	var value scimValue
+	token := p.peek()
+	switch token.kind {
+	case scimString:
+		value = scimValue{Literal: Literal{text: token.text, kind: StringLiteral}}
+	case scimWord:
+		var number json.Number
+		switch text := strings.ToLower(token.text); {
+		case text == "true" || text == "false":
+			value = scimValue{Literal: Literal{text: text, kind: BoolLiteral}}
+		case text == "null":
+			value = scimValue{null: true}
+		case json.Unmarshal([]byte(token.text), &number) == nil:
+			value = scimValue{Literal: Literal{text: token.text, kind: NumberLiteral}}
+		default:
+			return scimValue{}, p.unexpected()
+		}
+	default:
+		return scimValue{}, p.unexpected()
+	}
+
+	p.next()
+	return value, nil
+}
+
+// scimComparison builds the condition comparing a field with a value, ignoring the case of
+// strings unless the attribute is case exact
+func scimComparison(field string, operator scimToken, value scimValue, caseExact bool) (Condition, error) {
+	// Strings equal ignoring case match a pattern without wildcards, except timestamps,
+	// which compare by value
+	_, isTime := parseTime(value.text)
+	equalFold := !caseExact && !value.null && value.kind == StringLiteral && !isTime
+
+	op := strings.ToLower(operator.text)
+	switch op {
+	case "eq":
+		if value.null {
+			return &NotCondition{condition: &HasCondition{field: field}}, nil
+		}
+		if equalFold {
+			return newLikeCondition(field, Literal{text: escapeLike(value.text), kind: StringLiteral}, true), nil
+		}
+		return &EqualCondition{field: field, value: value.Literal}, nil
+	case "ne":
+		if value.null {
+			return &HasCondition{field: field}, nil
+		}
+		if equalFold {
+			condition := newLikeCondition(field, Literal{text: escapeLike(value.text), kind: StringLiteral}, true)
+			return &AndCondition{left: &HasCondition{field: field}, right: &NotCondition{condition: condition}}, nil
+		}
+		return &NotEqualCondition{field: field, value: value.Literal}, nil
+	case "co", "sw", "ew":
+		if value.null || value.kind != StringLiteral {
+			return nil, fmt.Errorf("%s expects a string at position %d", operator.text, operator.pos)
+		}
+		pattern := escapeLike(value.text)
+		switch op {
+		case "co":
+			pattern = "%" + pattern + "%"
+		case "sw":
+			pattern += "%"
+		case "ew":
+			pattern = "%" + pattern
+		}
+		return newLikeCondition(field, Literal{text: pattern, kind: StringLiteral}, !caseExact), nil
+	case "gt", "ge", "lt", "le":
+		if value.null || value.kind == BoolLiteral {
+			return nil, fmt.Errorf("%s expects a string or a number at position %d", operator.text, operator.pos)
+		}
+		operators := map[string]string{"gt": ">", "ge": ">=", "lt": "<", "le": "<="}
+		return &ComparisonCondition{field: field, value: value.Literal, operator: operators[op]}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported operator %q at position %d", operator.text, operator.pos)
+}
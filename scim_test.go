@@ -0,0 +1,202 @@
+// Copyright 2023 Daniel Valdivia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rql
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// scimUsers are SCIM user resources as decoded from JSON
+const scimUsers = `[
+	{"id": "1", "userName": "BJensen", "name": {"familyName": "Jensen", "givenName": "Barbara"},
+	 "title": "Tour Guide", "userType": "Employee",
+	 "emails": [{"type": "work", "value": "bjensen@example.com"}, {"type": "home", "value": "babs@jensen.org"}],
+	 "ims": [{"type": "aim", "value": "bjensen"}],
+	 "schemas": ["urn:ietf:params:scim:schemas:core:2.0:User", "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User"],
+	 "meta": {"lastModified": "2011-05-13T04:42:34Z"}},
+	{"id": "2", "userName": "JSmith", "name": {"familyName": "O'Malley"}, "userType": "Intern",
+	 "emails": [{"type": "home", "value": "jsmith@mail.test"}],
+	 "ims": [{"type": "xmpp", "value": "jsmith@foo.com"}],
+	 "schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"],
+	 "meta": {"lastModified": "2011-05-10T00:00:00Z"}},
+	{"id": "3", "userName": "mwilson", "name": {"familyName": "Wilson"}, "title": "Manager", "userType": "Employee",
+	 "emails": [{"type": "work", "value": "mwilson@corp.test"}],
+	 "schemas": ["urn:ietf:params:scim:schemas:core:2.0:User", "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User"],
+	 "meta": {"lastModified": "2012-01-01T00:00:00Z"}}
+]`
+
+// scimMultiValued declares the multi-valued attributes of scimUsers
+var scimMultiValued = SCIMOptions{MultiValued: map[string]string{"emails": "value", "ims": "value", "schemas": ""}}
+
+// scimCaseExact also declares attributes of scimUsers whose values are compared exactly
+var scimCaseExact = SCIMOptions{MultiValued: scimMultiValued.MultiValued, CaseExact: []string{"userName", "Emails.Value", "schemas"}}
+
+func TestCompileSCIM(t *testing.T) {
+	var users []map[string]any
+	if err := json.Unmarshal([]byte(scimUsers), &users); err != nil {
+		t.Fatalf("json.Unmarshal() unexpected error = %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		filter    string
+		caseExact bool // Compile with scimCaseExact rather than scimMultiValued
+		wantIDs   []string
+		wantErr   bool
+	}{
+		// The examples of RFC 7644 section 3.4.2.2
+		{name: "RFC equality", filter: `userName eq "bjensen"`, wantIDs: []string{"1"}},
+		{name: "RFC equality ignores case", filter: `userName eq "jsmith" or userName eq "MWilson"`, wantIDs: []string{"2", "3"}},
+		{name: "ne ignores case", filter: `userType ne "EMPLOYEE"`, wantIDs: []string{"2"}},
+		{name: "case exact eq", filter: `userName eq "bjensen" or userName eq "JSmith"`, caseExact: true, wantIDs: []string{"2"}},
+		{name: "case exact ne", filter: `userName ne "bjensen"`, caseExact: true, wantIDs: []string{"1", "2", "3"}},
+		{name: "case exact co", filter: `emails co "EXAMPLE" or ims co "FOO"`, caseExact: true, wantIDs: []string{"2"}},
+		{name: "eq timestamp", filter: `meta.lastModified eq "2011-05-13T04:42:34Z"`, wantIDs: []string{"1"}},
+		{name: "RFC contains", filter: `name.familyName co "O'Malley"`, wantIDs: []string{"2"}},
+		{name: "RFC starts with", filter: `userName sw "J"`, wantIDs: []string{"2"}},
+		{name: "RFC schema URI prefix", filter: `urn:ietf:params:scim:schemas:core:2.0:User:userName sw "J"`, wantIDs: []string{"2"}},
+		{name: "RFC present", filter: `title pr`, wantIDs: []string{"1", "3"}},
+		{name: "RFC greater than", filter: `meta.lastModified gt "2011-05-13T04:42:34Z"`, wantIDs: []string{"3"}},
+		{name: "RFC greater or equal", filter: `meta.lastModified ge "2011-05-13T04:42:34Z"`, wantIDs: []string{"1", "3"}},
+		{name: "RFC less than", filter: `meta.lastModified lt "2011-05-13T04:42:34Z"`, wantIDs: []string{"2"}},
+		{name: "RFC less or equal", filter: `meta.lastModified le "2011-05-13T04:42:34Z"`, wantIDs: []string{"1", "2"}},
+		{name: "RFC and", filter: `title pr and userType eq "Employee"`, wantIDs: []string{"1", "3"}},
+		{name: "RFC or", filter: `title pr or userType eq "Intern"`, wantIDs: []string{"1", "2", "3"}},
+		{name: "RFC simple multi-valued attribute", filter: `schemas eq "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User"`, wantIDs: []string{"1", "3"}},
+		{name: "RFC grouping", filter: `userType eq "Employee" and (emails co "example.com" or emails.value co "example.org")`, wantIDs: []string{"1"}},
+		{name: "RFC not", filter: `userType ne "Employee" and not (emails co "example.com" or emails.value co "example.org")`, wantIDs: []string{"2"}},
+		{name: "RFC sub-attribute of a multi-valued attribute", filter: `userType eq "Employee" and (emails.type eq "work")`, wantIDs: []string{"1", "3"}},
+		{name: "RFC complex attribute filter", filter: `userType eq "Employee" and emails[type eq "work" and value co "@example.com"]`, wantIDs: []string{"1"}},
+		{name: "RFC complex attribute filters", filter: `emails[type eq "work" and value co "@example.com"] or ims[type eq "xmpp" and value co "@foo.com"]`, wantIDs: []string{"1", "2"}},
+
+		{name: "empty filter", filter: " ", wantIDs: []string{"1", "2", "3"}},
+		{name: "case-insensitive attribute names", filter: `USERNAME eq "bjensen" or Emails[TYPE eq "home" and VALUE ew "TEST"]`, wantIDs: []string{"1", "2"}},
+		{name: "case-insensitive operators", filter: `title PR AND userName SW "m"`, wantIDs: []string{"3"}},
+		{name: "and binds tighter than or", filter: `userType eq "Intern" or title pr and userName eq "mwilson"`, wantIDs: []string{"2", "3"}},
+		{name: "present multi-valued attribute", filter: `ims pr`, wantIDs: []string{"1", "2"}},
+		{name: "present sub-attribute of a multi-valued attribute", filter: `ims.type pr`, wantIDs: []string{"1", "2"}},
+		{name: "eq null", filter: `title eq null`, wantIDs: []string{"2"}},
+		{name: "ne null", filter: `title ne null`, wantIDs: []string{"1", "3"}},
+		{name: "escaped strings", filter: `name.familyName eq "O\u0027Malley"`, wantIDs: []string{"2"}},
+		{name: "missing value", filter: `userName eq`, wantErr: true},
+		{name: "missing operator", filter: `title`, wantErr: true},
+		{name: "unknown operator", filter: `userName lk "b"`, wantErr: true},
+		{name: "not without parentheses", filter: `not userName eq "bjensen"`, wantErr: true},
+		{name: "unterminated complex attribute filter", filter: `emails[type eq "work"`, wantErr: true},
+		{name: "nested complex attribute filters", filter: `emails[ims[type eq "xmpp"]]`, wantErr: true},
+		{name: "ordering against a boolean", filter: `meta.lastModified gt true`, wantErr: true},
+		{name: "contains a number", filter: `userName co 5`, wantErr: true},
+		{name: "too many sub-attributes", filter: `name.familyName.first eq "a"`, wantErr: true},
+		{name: "single quotes", filter: `userName eq 'bjensen'`, wantErr: true},
+		{name: "invalid escape", filter: `userName eq "b\q"`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := scimMultiValued
+			if tt.caseExact {
+				options = scimCaseExact
+			}
+			query, err := CompileSCIM(tt.filter, options)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CompileSCIM() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			gotIDs := []string{}
+			for _, user := range ApplyQuery(query, users).Items {
+				gotIDs = append(gotIDs, user["id"].(string))
+			}
+			if !reflect.DeepEqual(gotIDs, tt.wantIDs) {
+				t.Errorf("CompileSCIM() got IDs = %v, want %v", gotIDs, tt.wantIDs)
+			}
+		})
+	}
+}
+
+// TestCompileSCIMErrors tests that errors name the offending token, or the end of the filter
+func TestCompileSCIMErrors(t *testing.T) {
+	tests := []struct {
+		filter  string
+		wantErr string
+	}{
+		{filter: `userName eq`, wantErr: "unexpected end of filter"},
+		{filter: `title`, wantErr: "unexpected end of filter"},
+		{filter: `userName eq "bjensen" and`, wantErr: "unexpected end of filter"},
+		{filter: `emails[type eq`, wantErr: "unexpected end of filter"},
+		{filter: `userName eq )`, wantErr: `unexpected ")" at position 12`},
+		{filter: `userName eq bjensen`, wantErr: `unexpected "bjensen" at position 12`},
+		{filter: `title (`, wantErr: `unexpected "(" at position 6`},
+		{filter: `(userName eq "b") and )`, wantErr: `unexpected ")" at position 22`},
+	}
+
+	for _, tt := range tests {
+		if _, err := CompileSCIM(tt.filter); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("CompileSCIM(%q) error = %v, want %s", tt.filter, err, tt.wantErr)
+		}
+	}
+}
+
+// TestCompileSCIMConditionTree tests that SCIM filters compile to the same conditions as the
+// equivalent SQL-like filters, so translators work unchanged
+func TestCompileSCIMConditionTree(t *testing.T) {
+	tests := []struct {
+		scim    string
+		sql     string
+		options []SCIMOptions
+	}{
+		{scim: `username eq "bjensen" and title pr`, sql: `username ILIKE 'bjensen' AND has(title)`},
+		{scim: `username ne "bjensen"`, sql: `username NOT ILIKE 'bjensen'`},
+		{scim: `username eq "50%_off"`, sql: `username ILIKE '50\%\_off'`},
+		{scim: `name.familyname sw "J"`, sql: `name.familyname ILIKE 'J%'`},
+		{scim: `emails[type eq "work" and value co "@example.com"]`, sql: `ANY(emails, type ILIKE 'work' AND value ILIKE '%@example.com%')`},
+		{scim: `not (age gt 30) or age le 4.5`, sql: `NOT (age > 30) OR age <= 4.5`},
+		{scim: `age eq 30 and active ne true`, sql: `age = 30 AND active != true`},
+		{scim: `meta.lastmodified eq "2011-05-13T04:42:34Z"`, sql: `meta.lastmodified = '2011-05-13T04:42:34Z'`},
+		{scim: `title eq null`, sql: `NOT has(title)`},
+		{scim: `emails.type eq "work"`, sql: `emails.type ILIKE 'work'`},
+		{scim: `emails.type eq "work"`, sql: `ANY(emails, type ILIKE 'work')`, options: []SCIMOptions{scimMultiValued}},
+		{scim: `emails co "example.com"`, sql: `ANY(emails, value ILIKE '%example.com%')`, options: []SCIMOptions{scimMultiValued}},
+		{scim: `schemas eq "urn:x"`, sql: `ANY(schemas, "" ILIKE 'urn:x')`, options: []SCIMOptions{scimMultiValued}},
+
+		// Case exact attributes
+		{scim: `username eq "bjensen" and title pr`, sql: `username = 'bjensen' AND has(title)`, options: []SCIMOptions{scimCaseExact}},
+		{scim: `username ne "bjensen"`, sql: `username != 'bjensen'`, options: []SCIMOptions{scimCaseExact}},
+		{scim: `emails[type eq "work" and value co "@example.com"]`, sql: `ANY(emails, type ILIKE 'work' AND value LIKE '%@example.com%')`, options: []SCIMOptions{scimCaseExact}},
+		{scim: `emails sw "b"`, sql: `ANY(emails, value LIKE 'b%')`, options: []SCIMOptions{scimCaseExact}},
+		{scim: `schemas eq "urn:x"`, sql: `ANY(schemas) = 'urn:x'`, options: []SCIMOptions{scimCaseExact}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.scim, func(t *testing.T) {
+			scim, err := CompileSCIM(tt.scim, tt.options...)
+			if err != nil {
+				t.Fatalf("CompileSCIM() unexpected error = %v", err)
+			}
+			sql, err := Compile(tt.sql)
+			if err != nil {
+				t.Fatalf("Compile() unexpected error = %v", err)
+			}
+			if !reflect.DeepEqual(scim, sql) {
+				t.Errorf("CompileSCIM(%q) = %#v, want %#v", tt.scim, scim.condition, sql.condition)
+			}
+		})
+	}
+}
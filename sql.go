@@ -0,0 +1,474 @@
+// Copyright 2023 Daniel Valdivia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rql
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrUnmappedField is returned when a filter references a field that has no column in the
+// SQL column mapping
+var ErrUnmappedField = errors.New("field is not mapped to a column")
+
+// Dialect is the SQL dialect a query is translated to
+type Dialect int
+
+const (
+	Postgres Dialect = iota // $n placeholders, native ILIKE and array columns
+	MySQL                   // ? placeholders, backtick quoting and JSON array columns
+	SQLite                  // ? placeholders and JSON array columns read with json_each
+)
+
+// String returns the name of the dialect
+func (d Dialect) String() string {
+	switch d {
+	case MySQL:
+		return "mysql"
+	case SQLite:
+		return "sqlite"
+	default:
+		return "postgres"
+	}
+}
+
+// SQLOptions contains configuration options for translating a query into SQL
+type SQLOptions struct {
+	// Columns maps the field names accepted in filters to column names, matching field
+	// names case-insensitively. Dots in a column name separate its qualifiers, e.g.
+	// "people.age". Filters referencing any other field are rejected
+	Columns map[string]string
+
+	// Dialect selects the SQL dialect, defaults to Postgres
+	Dialect Dialect
+}
+
+// ToSQL translates the query into a WHERE clause, without the WHERE keyword. Values are
+// never written into the clause: they are returned as args, referenced by placeholders
+// ($1, $2, ... for Postgres and ? otherwise), and every column comes from the column
+// mapping, so the result is safe to pass to database/sql as is. An empty query
+// translates to TRUE.
+//
+// ANY(field) conditions expect an array column in Postgres and a column holding a JSON
+// array in MySQL and SQLite
+func (q *Query) ToSQL(options SQLOptions) (where string, args []any, err error) {
+	if q.condition == nil {
+		return "TRUE", nil, nil
+	}
+
+	w := &sqlWriter{columns: options.Columns, dialect: options.Dialect}
+	if err := w.writeCondition(q.condition); err != nil {
+		return "", nil, err
+	}
+	return w.String(), w.args, nil
+}
+
+// sqlWriter accumulates the text and arguments of a WHERE clause
+type sqlWriter struct {
+	strings.Builder
+	columns map[string]string
+	dialect Dialect
+	args    []any
+	result  string // The SQL of the function whose result the field "" refers to, if any
+}
+
+// writeCondition writes a condition, wrapping boolean operands in parentheses wherever
+// SQL precedence would otherwise regroup them
+func (w *sqlWriter) writeCondition(condition Condition) error {
+	switch c := condition.(type) {
+	case *AndCondition:
+		return w.writeBinary(c, c.left, c.right, " AND ")
+	case *OrCondition:
+		return w.writeBinary(c, c.left, c.right, " OR ")
+	case *NotCondition:
+		w.WriteString("NOT ")
+		return w.writeOperand(c, c.condition)
+	case *ConstantCondition:
+		w.WriteString(strings.ToUpper(strconv.FormatBool(c.value)))
+		return nil
+	case *EqualCondition:
+		return w.writeComparison(c.field, "=", c.value)
+	case *NotEqualCondition:
+		return w.writeComparison(c.field, "<>", c.value)
+	case *ComparisonCondition:
+		return w.writeComparison(c.field, c.operator, c.value)
+	case *LikeCondition:
+		return w.writeLike(c.field, c.value, c.caseInsensitive)
+	case *RegexCondition:
+		return w.writeRegex(c.field, c.value, c.caseInsensitive)
+	case *HasCondition:
+		return w.writeHas(c.field)
+	case *AnyArrayContainsCondition:
+		return w.writeArrayContains(c.field, c.value, false)
+	case *AnyArrayNotContainsCondition:
+		return w.writeArrayContains(c.field, c.value, true)
+	case *AnyArrayContainsAnyCondition:
+		return w.writeArrayContainsAny(c.field, c.values, false)
+	case *AnyArrayNotContainsAnyCondition:
+		return w.writeArrayContainsAny(c.field, c.values, true)
+	case *QuantifierCondition:
+		return fmt.Errorf("quantifier over %s cannot be translated to SQL", c.field)
+	case *FunctionCondition:
+		result, err := w.function(c.function)
+		if err != nil {
+			return err
+		}
+		w.result = result
+		defer func() { w.result = "" }()
+		return w.writeCondition(c.condition)
+	}
+
+	return fmt.Errorf("unsupported condition %T", condition)
+}
+
+// writeBinary writes both operands of AND or OR
+func (w *sqlWriter) writeBinary(parent, left, right Condition, operator string) error {
+	if err := w.writeOperand(parent, left); err != nil {
+		return err
+	}
+	w.WriteString(operator)
+	return w.writeOperand(parent, right)
+}
+
+// writeOperand writes an operand of a boolean operator, in parentheses if it binds
+// less tightly than the operator
+func (w *sqlWriter) writeOperand(parent, operand Condition) error {
+	if sqlPrecedence(operand) >= sqlPrecedence(parent) {
+		return w.writeCondition(operand)
+	}
+
+	w.WriteByte('(')
+	if err := w.writeCondition(operand); err != nil {
+		return err
+	}
+	w.WriteByte(')')
+	return nil
+}
+
+// sqlPrecedence returns how tightly a condition binds in SQL. OR binds loosest, then AND,
+// then NOT, while everything else is a single comparison or a parenthesized group
+func sqlPrecedence(condition Condition) int {
+	switch condition.(type) {
+	case *OrCondition:
+		return 1
+	case *AndCondition:
+		return 2
+	case *NotCondition:
+		return 3
+	default:
+		return 4
+	}
+}
+
+// writeComparison writes a column, an operator and a placeholder for the value
+func (w *sqlWriter) writeComparison(field, operator string, value Literal) error {
+	column, err := w.column(field)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "%s %s %s", column, operator, w.placeholder(value))
+	return nil
+}
+
+// writeLike writes a LIKE match. Dialects without ILIKE lower-case both sides instead,
+// and SQLite is told to use the backslash as escape character like the other dialects.
+// SQLite's LIKE ignores ASCII case by default, so case-sensitive matches use GLOB there
+func (w *sqlWriter) writeLike(field string, pattern Literal, caseInsensitive bool) error {
+	column, err := w.column(field)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case !caseInsensitive && w.dialect == SQLite:
+		glob := Literal{text: likeGlob(pattern.text), kind: StringLiteral}
+		fmt.Fprintf(w, "%s GLOB %s", column, w.placeholder(glob))
+		return nil
+	case caseInsensitive && w.dialect == Postgres:
+		fmt.Fprintf(w, "%s ILIKE %s", column, w.placeholder(pattern))
+	case caseInsensitive:
+		fmt.Fprintf(w, "LOWER(%s) LIKE LOWER(%s)", column, w.placeholder(pattern))
+	default:
+		fmt.Fprintf(w, "%s LIKE %s", column, w.placeholder(pattern))
+	}
+	if w.dialect == SQLite {
+		w.WriteString(` ESCAPE '\'`)
+	}
+	return nil
+}
+
+// likeGlob converts a LIKE pattern into a GLOB pattern: % becomes *, _ becomes ? and the
+// characters GLOB treats specially match literally inside brackets
+func likeGlob(pattern string) string {
+	var b strings.Builder
+	for _, token := range compileLike(pattern, false).tokens {
+		switch {
+		case token.wildcard == '%':
+			b.WriteByte('*')
+		case token.wildcard == '_':
+			b.WriteByte('?')
+		case token.text == "*" || token.text == "?" || token.text == "[":
+			b.WriteString("[" + token.text + "]")
+		default:
+			b.WriteString(token.text)
+		}
+	}
+	return b.String()
+}
+
+// writeRegex writes a regular expression match. MySQL's REGEXP_LIKE is told the case
+// sensitivity, and SQLite's REGEXP operator needs a regexp() function registered with the
+// connection, which is given the case insensitivity as an (?i) flag. Each database has its
+// own regular expression flavor, which may not accept every RE2 expression the same way
+func (w *sqlWriter) writeRegex(field string, pattern Literal, caseInsensitive bool) error {
+	column, err := w.column(field)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case w.dialect == Postgres && caseInsensitive:
+		fmt.Fprintf(w, "%s ~* %s", column, w.placeholder(pattern))
+	case w.dialect == Postgres:
+		fmt.Fprintf(w, "%s ~ %s", column, w.placeholder(pattern))
+	case w.dialect == MySQL && caseInsensitive:
+		fmt.Fprintf(w, "REGEXP_LIKE(%s, %s, 'i')", column, w.placeholder(pattern))
+	case w.dialect == MySQL:
+		fmt.Fprintf(w, "REGEXP_LIKE(%s, %s, 'c')", column, w.placeholder(pattern))
+	case caseInsensitive:
+		fmt.Fprintf(w, "%s REGEXP %s", column, w.placeholder(Literal{text: "(?i)" + pattern.text, kind: StringLiteral}))
+	default:
+		fmt.Fprintf(w, "%s REGEXP %s", column, w.placeholder(pattern))
+	}
+	return nil
+}
+
+// writeArrayContains checks whether any element of an array column equals a value, or
+// when negated that none does
+func (w *sqlWriter) writeArrayContains(field string, value Literal, negate bool) error {
+	column, err := w.column(field)
+	if err != nil {
+		return err
+	}
+
+	not := ""
+	if negate {
+		not = "NOT "
+	}
+
+	switch w.dialect {
+	case MySQL:
+		fmt.Fprintf(w, "%sJSON_CONTAINS(%s, JSON_ARRAY(%s))", not, column, w.placeholder(value))
+	case SQLite:
+		fmt.Fprintf(w, "%sEXISTS (SELECT 1 FROM json_each(%s) WHERE json_each.value = %s)", not, column, w.placeholder(value))
+	default:
+		if negate {
+			fmt.Fprintf(w, "%s <> ALL(%s)", w.placeholder(value), column)
+		} else {
+			fmt.Fprintf(w, "%s = ANY(%s)", w.placeholder(value), column)
+		}
+	}
+	return nil
+}
+
+// writeArrayContainsAny checks whether any element of an array column equals any of the
+// values, or when negated that none does
+func (w *sqlWriter) writeArrayContainsAny(field string, values []Literal, negate bool) error {
+	join := " OR "
+	if negate {
+		join = " AND "
+	}
+
+	if len(values) > 1 {
+		w.WriteByte('(')
+	}
+	for i, value := range values {
+		if i > 0 {
+			w.WriteString(join)
+		}
+		if err := w.writeArrayContains(field, value, negate); err != nil {
+			return err
+		}
+	}
+	if len(values) > 1 {
+		w.WriteByte(')')
+	}
+	return nil
+}
+
+// writeHas writes a presence check. A mapped field must not be NULL, while a key of a
+// mapped JSON column, such as Labels ? 'env' or has(Labels.env), must exist
+func (w *sqlWriter) writeHas(field string) error {
+	if column, err := w.column(field); err == nil {
+		fmt.Fprintf(w, "%s IS NOT NULL", column)
+		return nil
+	}
+
+	parts := splitFieldPath(field)
+	if len(parts) > 1 {
+		if column, err := w.column(formatFieldPath(parts[:len(parts)-1])); err == nil {
+			w.writeKeyExists(column, parts[len(parts)-1])
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %s", ErrUnmappedField, field)
+}
+
+// writeKeyExists checks whether a JSON column holds an object with the given key
+func (w *sqlWriter) writeKeyExists(column, key string) {
+	switch w.dialect {
+	case MySQL:
+		fmt.Fprintf(w, "JSON_CONTAINS_PATH(%s, 'one', %s)", column, w.placeholder(jsonKeyPath(key)))
+	case SQLite:
+		fmt.Fprintf(w, "json_type(%s, %s) IS NOT NULL", column, w.placeholder(jsonKeyPath(key)))
+	default:
+		fmt.Fprintf(w, "%s ? %s", column, w.placeholder(Literal{text: key, kind: StringLiteral}))
+	}
+}
+
+// jsonKeyPath returns the JSON path selecting a key of the root object, e.g. $."env"
+func jsonKeyPath(key string) Literal {
+	key = strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(key)
+	return Literal{text: `$."` + key + `"`, kind: StringLiteral}
+}
+
+// function returns the SQL of a function call, adding its literal arguments to the
+// arguments of the clause
+func (w *sqlWriter) function(function *Function) (string, error) {
+	if function.name == "substring" && w.dialect != Postgres && !positiveInteger(function.args[1]) {
+		return w.clampedSubstring(function)
+	}
+
+	args := make([]string, len(function.args))
+	for i := range function.args {
+		var err error
+		if args[i], err = w.functionArg(function, i); err != nil {
+			return "", err
+		}
+	}
+
+	name := strings.ToUpper(function.name)
+	switch {
+	case function.name == "length" && w.dialect != SQLite:
+		name = "CHAR_LENGTH"
+	case function.name == "substring" && w.dialect == SQLite:
+		name = "SUBSTR"
+	case function.name == "trim" && len(args) > 1 && w.dialect == Postgres:
+		name = "BTRIM"
+	case function.name == "trim" && len(args) > 1 && w.dialect == MySQL:
+		// MySQL's TRIM removes a whole string rather than any of its characters
+		return "", fmt.Errorf("trim with characters cannot be translated to %s", w.dialect)
+	}
+	return name + "(" + strings.Join(args, ", ") + ")", nil
+}
+
+// functionArg writes an argument of a function, adding the values of literals to the
+// arguments each time it's called
+func (w *sqlWriter) functionArg(function *Function, i int) (string, error) {
+	arg := function.args[i]
+	switch {
+	case arg.value != nil:
+		placeholder := w.placeholder(*arg.value)
+		// PostgreSQL resolves substring($1, $2, $3) with untyped parameters to the overload
+		// matching a regular expression, so positions and lengths are cast to integers
+		if w.dialect == Postgres && arg.valueType() == numberValue && function.name == "substring" {
+			return "CAST(" + placeholder + " AS integer)", nil
+		}
+		return placeholder, nil
+	case arg.function != nil:
+		return w.function(arg.function)
+	}
+	return w.column(arg.field)
+}
+
+// clampedSubstring writes substring for MySQL and SQLite when the start position may be
+// below 1, where both count from the end of the string. The start is clamped to the first
+// character and the characters before it count towards the length, as in PostgreSQL:
+// SUBSTRING(s, GREATEST(start, 1), GREATEST(start + length - GREATEST(start, 1), 0))
+func (w *sqlWriter) clampedSubstring(function *Function) (string, error) {
+	name, greatest := "SUBSTRING", "GREATEST"
+	if w.dialect == SQLite {
+		name, greatest = "SUBSTR", "MAX"
+	}
+
+	// Arguments in the order they appear, so placeholders number the values in order
+	order := []int{0, 1, 1, 2, 1}
+	if len(function.args) < 3 {
+		order = order[:2]
+	}
+	parts := make([]string, len(order))
+	for i, arg := range order {
+		var err error
+		if parts[i], err = w.functionArg(function, arg); err != nil {
+			return "", err
+		}
+	}
+
+	start := greatest + "(" + parts[1] + ", 1)"
+	if len(parts) == 2 {
+		return name + "(" + parts[0] + ", " + start + ")", nil
+	}
+	length := greatest + "(" + parts[2] + " + " + parts[3] + " - " + greatest + "(" + parts[4] + ", 1), 0)"
+	return name + "(" + parts[0] + ", " + start + ", " + length + ")", nil
+}
+
+// positiveInteger reports whether a function argument is an integer value of at least 1
+func positiveInteger(arg FunctionArg) bool {
+	if arg.value == nil {
+		return false
+	}
+	number, ok := arg.value.Value().(int64)
+	return ok && number > 0
+}
+
+// column returns the quoted column a field is mapped to, or the function whose result a
+// comparison of the field "" refers to
+func (w *sqlWriter) column(field string) (string, error) {
+	if field == "" && w.result != "" {
+		return w.result, nil
+	}
+	name, ok := mappedField(w.columns, field)
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnmappedField, field)
+	}
+	return quoteIdentifier(name, w.dialect), nil
+}
+
+// placeholder adds a value to the arguments and returns the placeholder referencing it
+func (w *sqlWriter) placeholder(value Literal) string {
+	w.args = append(w.args, value.Value())
+	if w.dialect != Postgres {
+		return "?"
+	}
+	return "$" + strconv.Itoa(len(w.args))
+}
+
+// quoteIdentifier quotes each dot-separated part of a column name as an identifier,
+// with backticks for MySQL and double quotes otherwise
+func quoteIdentifier(name string, dialect Dialect) string {
+	quote := `"`
+	if dialect == MySQL {
+		quote = "`"
+	}
+
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = quote + strings.ReplaceAll(part, quote, quote+quote) + quote
+	}
+	return strings.Join(parts, ".")
+}
@@ -0,0 +1,272 @@
+// Copyright 2023 Daniel Valdivia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rql
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata")
+
+// personColumns maps the TestPerson fields to the columns of a people table
+var personColumns = map[string]string{
+	"ID":              "id",
+	"Name":            "name",
+	"Email":           "email",
+	"Age":             "age",
+	"Active":          "active",
+	"Score":           "score",
+	"Tags":            "tags",
+	"Labels":          "labels",
+	"Department.Name": "departments.name",
+	"Nickname":        `nick"name`,
+}
+
+func TestToSQL(t *testing.T) {
+	tests := []struct {
+		name      string
+		filter    string
+		wantWhere string
+		wantArgs  []any
+	}{
+		{
+			name:      "empty filter",
+			filter:    "",
+			wantWhere: "TRUE",
+		},
+		{
+			name:      "typed arguments",
+			filter:    "Age >= 30 AND Name = 'Alice' AND Active = true AND Score < 4.5",
+			wantWhere: `"age" >= $1 AND "name" = $2 AND "active" = $3 AND "score" < $4`,
+			wantArgs:  []any{int64(30), "Alice", true, 4.5},
+		},
+		{
+			name:      "quoted numbers stay strings",
+			filter:    "Name = '007'",
+			wantWhere: `"name" = $1`,
+			wantArgs:  []any{"007"},
+		},
+		{
+			name:      "OR nested under AND",
+			filter:    "Age > 30 AND (Name = 'Bob' OR Name <> 'Eve')",
+			wantWhere: `"age" > $1 AND ("name" = $2 OR "name" <> $3)`,
+			wantArgs:  []any{int64(30), "Bob", "Eve"},
+		},
+		{
+			name:      "NOT",
+			filter:    "NOT (Active = false OR Age < 18)",
+			wantWhere: `NOT ("active" = $1 OR "age" < $2)`,
+			wantArgs:  []any{false, int64(18)},
+		},
+		{
+			name:      "LIKE and native ILIKE",
+			filter:    "Name LIKE 'A%' OR Email ILIKE '%@EXAMPLE.COM'",
+			wantWhere: `"name" LIKE $1 OR "email" ILIKE $2`,
+			wantArgs:  []any{"A%", "%@EXAMPLE.COM"},
+		},
+		{
+			name:      "IN and NOT IN",
+			filter:    "ID IN (1, 2) AND Name NOT IN ('Bob', 'Eve')",
+			wantWhere: `("id" = $1 OR "id" = $2) AND "name" <> $3 AND "name" <> $4`,
+			wantArgs:  []any{int64(1), int64(2), "Bob", "Eve"},
+		},
+		{
+			name:      "ANY element equals",
+			filter:    "ANY(Tags) = 'go'",
+			wantWhere: `$1 = ANY("tags")`,
+			wantArgs:  []any{"go"},
+		},
+		{
+			name:      "no element equals",
+			filter:    "ANY(Tags) != 'go'",
+			wantWhere: `$1 <> ALL("tags")`,
+			wantArgs:  []any{"go"},
+		},
+		{
+			name:      "native ANY and ALL forms",
+			filter:    "'go' = ANY(Tags) AND 'php' <> ALL(Tags)",
+			wantWhere: `$1 = ANY("tags") AND $2 <> ALL("tags")`,
+			wantArgs:  []any{"go", "php"},
+		},
+		{
+			name:      "ANY element equals any value",
+			filter:    "ANY(Tags) = ANY('go', 'rust')",
+			wantWhere: `($1 = ANY("tags") OR $2 = ANY("tags"))`,
+			wantArgs:  []any{"go", "rust"},
+		},
+		{
+			name:      "no element equals any value",
+			filter:    "ANY(Tags) != ANY('go', 'rust') OR Age = 1",
+			wantWhere: `($1 <> ALL("tags") AND $2 <> ALL("tags")) OR "age" = $3`,
+			wantArgs:  []any{"go", "rust", int64(1)},
+		},
+		{
+			name:      "qualified and case-insensitive columns",
+			filter:    "department.name = 'Engineering' AND age = 25",
+			wantWhere: `"departments"."name" = $1 AND "age" = $2`,
+			wantArgs:  []any{"Engineering", int64(25)},
+		},
+		{
+			name:      "column names are quoted",
+			filter:    "Nickname = 'Al'",
+			wantWhere: `"nick""name" = $1`,
+			wantArgs:  []any{"Al"},
+		},
+		{
+			name:      "presence and key existence",
+			filter:    "has(Email) AND Labels ? 'env' AND has(Labels['app.kubernetes.io/name'])",
+			wantWhere: `"email" IS NOT NULL AND "labels" ? $1 AND "labels" ? $2`,
+			wantArgs:  []any{"env", "app.kubernetes.io/name"},
+		},
+		{
+			name:      "constants",
+			filter:    "true AND NOT false",
+			wantWhere: `TRUE AND NOT FALSE`,
+		},
+		{
+			name:      "values cannot inject SQL",
+			filter:    "Name = 'x'' OR 1=1 --'",
+			wantWhere: `"name" = $1`,
+			wantArgs:  []any{"x' OR 1=1 --"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := Compile(tt.filter)
+			if err != nil {
+				t.Fatalf("Compile() unexpected error = %v", err)
+			}
+
+			where, args, err := query.ToSQL(SQLOptions{Columns: personColumns})
+			if err != nil {
+				t.Fatalf("ToSQL() unexpected error = %v", err)
+			}
+			if where != tt.wantWhere {
+				t.Errorf("ToSQL() where = %s, want %s", where, tt.wantWhere)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("ToSQL() args = %#v, want %#v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestToSQLErrors(t *testing.T) {
+	tests := []struct {
+		name         string
+		filter       string
+		wantUnmapped bool
+	}{
+		{name: "unmapped field", filter: "Name = 'Alice' OR Password = 'secret'", wantUnmapped: true},
+		{name: "unmapped array field", filter: "ANY(Skills) = 'go'", wantUnmapped: true},
+		{name: "unmapped nested field", filter: "Department.Location = 'Building A'", wantUnmapped: true},
+		{name: "unmapped presence check", filter: "has(Metadata.Categories)", wantUnmapped: true},
+		{name: "quantifiers", filter: "ANY(Orders, Total > 100)"},
+		{name: "unmapped field in a function", filter: "coalesce(Nickname, lower(Password)) = 'x'", wantUnmapped: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := Compile(tt.filter)
+			if err != nil {
+				t.Fatalf("Compile() unexpected error = %v", err)
+			}
+
+			_, _, err = query.ToSQL(SQLOptions{Columns: personColumns})
+			if err == nil {
+				t.Fatalf("ToSQL() expected an error")
+			}
+			if got := errors.Is(err, ErrUnmappedField); got != tt.wantUnmapped {
+				t.Errorf("ToSQL() error = %v, errors.Is(ErrUnmappedField) = %v, want %v", err, got, tt.wantUnmapped)
+			}
+		})
+	}
+
+	// MySQL's TRIM removes a whole string rather than any of the characters given
+	query, err := Compile("trim(Name, '-_') = 'x'")
+	if err != nil {
+		t.Fatalf("Compile() unexpected error = %v", err)
+	}
+	if _, _, err := query.ToSQL(SQLOptions{Columns: personColumns, Dialect: MySQL}); err == nil {
+		t.Errorf("ToSQL() expected an error for MySQL")
+	}
+}
+
+// sqlGoldenFilters are translated to every dialect and compared with testdata/sql/<dialect>.golden
+var sqlGoldenFilters = []string{
+	"",
+	"Age >= 30 AND Name = 'Alice' AND Active = true AND Score < 4.5",
+	"Age > 30 AND (Name = 'Bob' OR Name <> 'Eve')",
+	"NOT (Active = false OR Age < 18)",
+	"Name LIKE 'A%' OR Email ILIKE '%@EXAMPLE.COM'",
+	`Name LIKE '[a]*?\%_%' OR Name NOT LIKE 'B_b'`,
+	`Email ~* '@(example|test)\.com$' OR Name !~ '^A'`,
+	"lower(Email) LIKE '%@example.com' AND length(trim(Name)) > 3 OR substring(coalesce(Nickname, Name), 1, 2) NOT IN ('Al', 'Bo')",
+	"substring(Name, 0, 2) = 'A' OR substring(Name, -1) = 'Al' OR substring(Email, Age, 3) = 'x'",
+	"ID IN (1, 2) AND Name NOT IN ('Bob', 'Eve')",
+	"ANY(Tags) = 'go'",
+	"ANY(Tags) != 'go'",
+	"ANY(Tags) = ANY('go', 'rust') AND ANY(Tags) != ANY('php', 'perl')",
+	"department.name = 'Engineering' AND Nickname = 'Al'",
+	"has(Email) AND Labels ? 'env' AND has(Labels['app.kubernetes.io/\"name\"'])",
+	"Name = 'x'' OR 1=1 --'",
+}
+
+func TestToSQLDialects(t *testing.T) {
+	for _, dialect := range []Dialect{Postgres, MySQL, SQLite} {
+		t.Run(dialect.String(), func(t *testing.T) {
+			var got strings.Builder
+			for _, filter := range sqlGoldenFilters {
+				query, err := Compile(filter)
+				if err != nil {
+					t.Fatalf("Compile(%q) unexpected error = %v", filter, err)
+				}
+				where, args, err := query.ToSQL(SQLOptions{Columns: personColumns, Dialect: dialect})
+				if err != nil {
+					t.Fatalf("ToSQL(%q) unexpected error = %v", filter, err)
+				}
+
+				fmt.Fprintf(&got, "%s\n%s\n", strings.TrimSpace("-- "+filter), where)
+				for _, arg := range args {
+					fmt.Fprintf(&got, "%T(%#v)\n", arg, arg)
+				}
+				got.WriteString("\n")
+			}
+
+			golden := filepath.Join("testdata", "sql", dialect.String()+".golden")
+			if *updateGolden {
+				if err := os.WriteFile(golden, []byte(got.String()), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("reading golden file: %v (run go test -update to create it)", err)
+			}
+			if got.String() != string(want) {
+				t.Errorf("ToSQL() output differs from %s:\n%s", golden, got.String())
+			}
+		})
+	}
+}